@@ -8,10 +8,20 @@ import (
 // Router api/v1 base router
 func Router(g *echo.Group) {
 	ResourceRouter(g.Group("/resources"))
+	KubeEventRouter(g.Group("/kube_events"))
 }
 
 // ResourceRouter api/v1/resources/* router
 func ResourceRouter(g *echo.Group) {
 	resourceRouter := NewResourceApi(dependency.GetV1ResourceService())
 	g.POST("", resourceRouter.Update, AuthenticationAndAuthorizationHandler)
+	g.POST("/abort", resourceRouter.Abort, AuthenticationAndAuthorizationHandler)
+	g.POST("/diff", resourceRouter.Diff, AuthenticationAndAuthorizationHandler)
+}
+
+// KubeEventRouter api/v1/kube_events/* router
+func KubeEventRouter(g *echo.Group) {
+	kubeEventRouter := NewKubeEventApi(dependency.GetV1KubeEventReplayService(), dependency.GetV1KubeEventOutboxService())
+	g.POST("/replay", kubeEventRouter.Replay, AuthenticationAndAuthorizationHandler)
+	g.GET("/metrics", kubeEventRouter.Metrics, AuthenticationAndAuthorizationHandler)
 }
@@ -0,0 +1,58 @@
+package v1
+
+import (
+	"github.com/klovercloud-ci-cd/agent/api/common"
+	"github.com/klovercloud-ci-cd/agent/core/v1/api"
+	"github.com/klovercloud-ci-cd/agent/core/v1/service"
+	"github.com/labstack/echo/v4"
+	"strconv"
+)
+
+type kubeEventApi struct {
+	kubeEventReplayService service.KubeEventReplay
+	kubeEventOutboxService service.KubeEventOutbox
+}
+
+// Replay... Replay kube events
+// @Summary Replay kube events
+// @Description Resends buffered kube event messages from the given offset on. Messages of another epoch are all resent.
+// @Tags KubeEvent
+// @Produce json
+// @Param epoch query int false "Epoch of the last received message"
+// @Param offset query int true "First offset to resend"
+// @Success 200 {object} common.ResponseDTO{data=v1.KubeEventReplay}
+// @Router /api/v1/kube_events/replay [POST]
+func (k kubeEventApi) Replay(context echo.Context) error {
+	offset, err := strconv.Atoi(context.QueryParam("offset"))
+	if err != nil {
+		return common.GenerateErrorResponse(context, nil, "offset is required!")
+	}
+	var epoch int64
+	if context.QueryParam("epoch") != "" {
+		epoch, err = strconv.ParseInt(context.QueryParam("epoch"), 10, 64)
+		if err != nil {
+			return common.GenerateErrorResponse(context, nil, "epoch is invalid!")
+		}
+	}
+	result := k.kubeEventReplayService.Replay(epoch, offset)
+	return common.GenerateSuccessResponse(context, result, nil, "Kube events replayed!")
+}
+
+// Metrics... Kube event delivery metrics
+// @Summary Kube event delivery metrics
+// @Description Returns delivery counters of the kube event outbox
+// @Tags KubeEvent
+// @Produce json
+// @Success 200 {object} common.ResponseDTO{data=v1.KubeEventOutboxMetrics}
+// @Router /api/v1/kube_events/metrics [GET]
+func (k kubeEventApi) Metrics(context echo.Context) error {
+	return common.GenerateSuccessResponse(context, k.kubeEventOutboxService.Metrics(), nil, "")
+}
+
+// NewKubeEventApi returns KubeEvent type api
+func NewKubeEventApi(kubeEventReplayService service.KubeEventReplay, kubeEventOutboxService service.KubeEventOutbox) api.KubeEvent {
+	return &kubeEventApi{
+		kubeEventReplayService: kubeEventReplayService,
+		kubeEventOutboxService: kubeEventOutboxService,
+	}
+}
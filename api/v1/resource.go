@@ -37,6 +37,55 @@ func (r resourceApi) Update(context echo.Context) error {
 	return common.GenerateSuccessResponse(context, "", nil, "Pipeline successfully triggered!")
 }
 
+// Abort... Abort progressive delivery
+// @Summary Abort progressive delivery
+// @Description Aborts running blue green or canary deployment of a step
+// @Tags Resource
+// @Produce json
+// @Param process_id query string true "Process Id"
+// @Param step query string true "Step name"
+// @Success 200 {object} common.ResponseDTO
+// @Router /api/v1/resources/abort [POST]
+func (r resourceApi) Abort(context echo.Context) error {
+	processId := context.QueryParam("process_id")
+	step := context.QueryParam("step")
+	if processId == "" || step == "" {
+		return common.GenerateErrorResponse(context, nil, "process_id and step are required!")
+	}
+	err := r.resourceService.Abort(processId, step)
+	if err != nil {
+		log.Println("Abort Error:", err.Error())
+		return common.GenerateErrorResponse(context, err.Error(), "Failed to abort!")
+	}
+	return common.GenerateSuccessResponse(context, "", nil, "Abort signal sent!")
+}
+
+// Diff... Diff resources
+// @Summary Diff resources
+// @Description Returns changes applying the resource would make, using server side dry run
+// @Tags Resource
+// @Produce json
+// @Param data body v1.Resource true "Resource Data"
+// @Success 200 {object} common.ResponseDTO{data=[]v1.ResourceDiff}
+// @Router /api/v1/resources/diff [POST]
+func (r resourceApi) Diff(context echo.Context) error {
+	data := v1.Resource{}
+	err := context.Bind(&data)
+	if err != nil {
+		log.Println("Input Error:", err.Error())
+		return common.GenerateErrorResponse(context, nil, err.Error())
+	}
+	if data.Pipeline == nil {
+		data.Pipeline = &v1.Pipeline{}
+	}
+	diffs, err := r.resourceService.Diff(data)
+	if err != nil {
+		log.Println("Diff Error:", err.Error())
+		return common.GenerateErrorResponse(context, err.Error(), "Failed to compute diff!")
+	}
+	return common.GenerateSuccessResponse(context, diffs, nil, "Diff computed!")
+}
+
 // NewResourceApi returns Resource type api
 func NewResourceApi(resourceService service.Resource) api.Resource {
 	return &resourceApi{
@@ -42,10 +42,61 @@ var EnableOpenTracing bool
 // KafkaPublisherEnabled set true if kafka publisher is enabled
 var KafkaPublisherEnabled bool
 
+// KafkaBrokers refers to kafka brokers of kube event publisher.
+var KafkaBrokers []string
+
+// KafkaTopic refers to kafka topic kube events are published to.
+var KafkaTopic string
+
+// KafkaVersion refers to kafka version the producer speaks, at least 0.11.0.0 for idempotent delivery.
+var KafkaVersion string
+
+// KafkaCompression refers to compression codec of produced batches, none, gzip, snappy, lz4 or zstd.
+var KafkaCompression string
+
+// KafkaSaslMechanism refers to sasl mechanism, PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512. Sasl is disabled if empty.
+var KafkaSaslMechanism string
+
+// KafkaSaslUser refers to sasl user.
+var KafkaSaslUser string
+
+// KafkaSaslPassword refers to sasl password.
+var KafkaSaslPassword string
+
+// KafkaTlsEnabled set true if kafka brokers are connected over tls.
+var KafkaTlsEnabled bool
+
+// KafkaTlsCaFile refers to ca certificate file of kafka brokers.
+var KafkaTlsCaFile string
+
+// KafkaTlsCertFile refers to client certificate file for kafka brokers.
+var KafkaTlsCertFile string
+
+// KafkaTlsKeyFile refers to client key file for kafka brokers.
+var KafkaTlsKeyFile string
+
+// KafkaTlsInsecureSkipVerify set true to skip verification of kafka broker certificates.
+var KafkaTlsInsecureSkipVerify bool
+
+// KubeEventBufferSize refers to number of published kube event messages kept for replay.
+var KubeEventBufferSize int
+
+// KubeEventQueueSize refers to capacity of in-memory queue of kube events waiting for delivery.
+var KubeEventQueueSize int
+
+// KubeEventBatchSize refers to maximum number of kube events delivered in a request.
+var KubeEventBatchSize int
+
+// KubeEventSpoolDir refers to directory kube events overflowing the queue are spilled to, they are dropped if empty.
+var KubeEventSpoolDir string
+
+// KubeEventSpoolMaxSize refers to maximum bytes of spilled kube events.
+var KubeEventSpoolMaxSize int64
+
 // LighthouseEnabled set true if lighthouse is enabled
 var LighthouseEnabled bool
 
-// CurrentConcurrentJobs running jobs count.
+// CurrentConcurrentJobs running jobs count, accessed atomically.
 var CurrentConcurrentJobs int64
 
 // TerminalBaseUrl base url of terminal.
@@ -54,6 +105,53 @@ var TerminalBaseUrl string
 // TerminalApiVersion api version of terminal.
 var TerminalApiVersion string
 
+// FieldManager refers to field manager name used for server-side apply.
+var FieldManager string
+
+// DefaultNamespace refers to namespace used when neither descriptor nor resource has one.
+var DefaultNamespace string
+
+// AutoCreateNamespace set true if missing namespaces should be created while applying descriptors.
+var AutoCreateNamespace bool
+
+// ProtectedNamespaces refers to namespaces agent must never apply descriptors into.
+var ProtectedNamespaces []string
+
+// InventoryNamespace refers to namespace where inventories of applied objects are kept for pruning.
+var InventoryNamespace string
+
+// LighthouseNamespaces refers to namespaces lighthouse watches and caches klovercloud_ci labelled objects of, every namespace if empty.
+// Deploy paths read workloads and pods from the cache only if namespaces are set.
+var LighthouseNamespaces []string
+
+// LighthouseResources refers to resources lighthouse watches, as resource[.version][.group], preferred version is used if version is omitted.
+var LighthouseResources []string
+
+// ClusterName refers to name of the cluster agent is running in.
+var ClusterName string
+
+// TemplateVariables refers to agent level descriptor template variables, set by TEMPLATE_VAR_ prefixed environment variables.
+var TemplateVariables map[string]string
+
+// JobDelivery refers to how jobs are received from api service, poll or sse.
+var JobDelivery enums.JOB_DELIVERY
+
+// LeaseSeconds refers to seconds a job lease lasts without heartbeat.
+var LeaseSeconds int64
+
+// JournalStore refers to store of the job journal, journal is disabled if empty.
+var JournalStore enums.JOURNAL_STORE
+
+// JournalDir refers to directory of file journal. Unless it is on a persistent volume, file journal only survives container
+// restarts, configmap journal also survives the agent pod being replaced.
+var JournalDir string
+
+// JournalNamespace refers to namespace of configmap journal.
+var JournalNamespace string
+
+// RolloutTimeout refers to seconds to wait for a workload rollout to finish.
+var RolloutTimeout int64
+
 // InitEnvironmentVariables initializes environment variables
 func InitEnvironmentVariables() {
 	RunMode = os.Getenv("RUN_MODE")
@@ -78,12 +176,92 @@ func InitEnvironmentVariables() {
 	AgentName = os.Getenv("AGENT_NAME")
 	TerminalBaseUrl = os.Getenv("TERMINAL_BASE_URL")
 	TerminalApiVersion=os.Getenv("TERMINAL_API_VERSION")
+	FieldManager = os.Getenv("FIELD_MANAGER")
+	if FieldManager == "" {
+		FieldManager = AgentName
+	}
+	if FieldManager == "" {
+		FieldManager = "klovercloud-ci-agent"
+	}
+	DefaultNamespace = os.Getenv("DEFAULT_NAMESPACE")
+	if DefaultNamespace == "" {
+		DefaultNamespace = "default"
+	}
+	if strings.ToLower(os.Getenv("AUTO_CREATE_NAMESPACE")) == "true" {
+		AutoCreateNamespace = true
+	} else {
+		AutoCreateNamespace = false
+	}
+	InventoryNamespace = os.Getenv("INVENTORY_NAMESPACE")
+	if InventoryNamespace == "" {
+		InventoryNamespace = DefaultNamespace
+	}
+	ClusterName = os.Getenv("CLUSTER_NAME")
+	JobDelivery = enums.JOB_DELIVERY(strings.ToLower(os.Getenv("JOB_DELIVERY")))
+	if JobDelivery == "" {
+		JobDelivery = enums.POLL_DELIVERY
+	}
+	JournalStore = enums.JOURNAL_STORE(strings.ToLower(os.Getenv("JOURNAL_STORE")))
+	JournalDir = os.Getenv("JOURNAL_DIR")
+	if JournalDir == "" {
+		JournalDir = "/var/lib/klovercloud-ci/journal"
+	}
+	JournalNamespace = os.Getenv("JOURNAL_NAMESPACE")
+	if JournalNamespace == "" {
+		JournalNamespace = InventoryNamespace
+	}
+	TemplateVariables = make(map[string]string)
+	for _, each := range os.Environ() {
+		if strings.HasPrefix(each, "TEMPLATE_VAR_") {
+			pair := strings.SplitN(strings.TrimPrefix(each, "TEMPLATE_VAR_"), "=", 2)
+			if len(pair) == 2 && pair[0] != "" {
+				TemplateVariables[pair[0]] = pair[1]
+			}
+		}
+	}
+	protectedNamespaces := os.Getenv("PROTECTED_NAMESPACES")
+	if protectedNamespaces == "" {
+		protectedNamespaces = "kube-system,kube-public,kube-node-lease"
+	}
+	ProtectedNamespaces = nil
+	for _, each := range strings.Split(protectedNamespaces, ",") {
+		if strings.TrimSpace(each) != "" {
+			ProtectedNamespaces = append(ProtectedNamespaces, strings.TrimSpace(each))
+		}
+	}
+	LighthouseNamespaces = nil
+	for _, each := range strings.Split(os.Getenv("LIGHTHOUSE_NAMESPACES"), ",") {
+		if strings.TrimSpace(each) != "" {
+			LighthouseNamespaces = append(LighthouseNamespaces, strings.TrimSpace(each))
+		}
+	}
+	lighthouseResources := os.Getenv("LIGHTHOUSE_RESOURCES")
+	if lighthouseResources == "" {
+		lighthouseResources = "namespaces,services,pods,deployments.apps,ingresses.networking.k8s.io,networkpolicies.networking.k8s.io," +
+			"clusterrolebindings.rbac.authorization.k8s.io,clusterroles.rbac.authorization.k8s.io,rolebindings.rbac.authorization.k8s.io," +
+			"roles.rbac.authorization.k8s.io,serviceaccounts,secrets,configmaps,persistentvolumeclaims,persistentvolumes," +
+			"daemonsets.apps,replicasets.apps,statefulsets.apps,events"
+	}
+	LighthouseResources = nil
+	for _, each := range strings.Split(lighthouseResources, ",") {
+		if strings.TrimSpace(each) != "" {
+			LighthouseResources = append(LighthouseResources, strings.TrimSpace(each))
+		}
+	}
 	err := error(nil)
 	PullSize, err = strconv.ParseInt(os.Getenv("PULL_SIZE"), 10, 64)
 	if err != nil {
 		PullSize = 4
 	}
 	log.Println(PullSize)
+	LeaseSeconds, err = strconv.ParseInt(os.Getenv("LEASE_SECONDS"), 10, 64)
+	if err != nil || LeaseSeconds < 3 {
+		LeaseSeconds = 60
+	}
+	RolloutTimeout, err = strconv.ParseInt(os.Getenv("ROLLOUT_TIMEOUT"), 10, 64)
+	if err != nil || RolloutTimeout < 1 {
+		RolloutTimeout = 600
+	}
 	CurrentConcurrentJobs=0
 	Publickey = os.Getenv("PUBLIC_KEY")
 	IsK8 = os.Getenv("IS_K8")
@@ -114,6 +292,52 @@ func InitEnvironmentVariables() {
 		KafkaPublisherEnabled = false
 	}
 
+	KafkaBrokers = nil
+	for _, each := range strings.Split(os.Getenv("KAFKA_BROKERS"), ",") {
+		if strings.TrimSpace(each) != "" {
+			KafkaBrokers = append(KafkaBrokers, strings.TrimSpace(each))
+		}
+	}
+	KafkaTopic = os.Getenv("KAFKA_TOPIC")
+	if KafkaTopic == "" {
+		KafkaTopic = "kube_events"
+	}
+	KafkaVersion = os.Getenv("KAFKA_VERSION")
+	if KafkaVersion == "" {
+		KafkaVersion = "2.1.0"
+	}
+	KafkaCompression = os.Getenv("KAFKA_COMPRESSION")
+	if KafkaCompression == "" {
+		KafkaCompression = "snappy"
+	}
+	KafkaSaslMechanism = os.Getenv("KAFKA_SASL_MECHANISM")
+	KafkaSaslUser = os.Getenv("KAFKA_SASL_USER")
+	KafkaSaslPassword = os.Getenv("KAFKA_SASL_PASSWORD")
+	KafkaTlsEnabled = strings.ToLower(os.Getenv("KAFKA_TLS_ENABLED")) == "true"
+	KafkaTlsCaFile = os.Getenv("KAFKA_TLS_CA_FILE")
+	KafkaTlsCertFile = os.Getenv("KAFKA_TLS_CERT_FILE")
+	KafkaTlsKeyFile = os.Getenv("KAFKA_TLS_KEY_FILE")
+	KafkaTlsInsecureSkipVerify = strings.ToLower(os.Getenv("KAFKA_TLS_INSECURE_SKIP_VERIFY")) == "true"
+
+	KubeEventBufferSize, err = strconv.Atoi(os.Getenv("KUBE_EVENT_BUFFER_SIZE"))
+	if err != nil || KubeEventBufferSize < 1 {
+		KubeEventBufferSize = 1024
+	}
+
+	KubeEventQueueSize, err = strconv.Atoi(os.Getenv("KUBE_EVENT_QUEUE_SIZE"))
+	if err != nil || KubeEventQueueSize < 1 {
+		KubeEventQueueSize = 1000
+	}
+	KubeEventBatchSize, err = strconv.Atoi(os.Getenv("KUBE_EVENT_BATCH_SIZE"))
+	if err != nil || KubeEventBatchSize < 1 {
+		KubeEventBatchSize = 50
+	}
+	KubeEventSpoolDir = os.Getenv("KUBE_EVENT_SPOOL_DIR")
+	KubeEventSpoolMaxSize, err = strconv.ParseInt(os.Getenv("KUBE_EVENT_SPOOL_MAX_SIZE"), 10, 64)
+	if err != nil || KubeEventSpoolMaxSize < 1 {
+		KubeEventSpoolMaxSize = 256 * 1024 * 1024
+	}
+
 	if os.Getenv("LIGHTHOUSE_ENABLED") == "true" {
 		LighthouseEnabled = true
 	} else {
@@ -0,0 +1,11 @@
+package api
+
+import (
+	"github.com/labstack/echo/v4"
+)
+
+// KubeEvent kube event api operations
+type KubeEvent interface {
+	Replay(ctx echo.Context) error
+	Metrics(ctx echo.Context) error
+}
@@ -7,4 +7,6 @@ import (
 // Resource resource api operations
 type Resource interface {
 	Update(ctx echo.Context) error
+	Abort(ctx echo.Context) error
+	Diff(ctx echo.Context) error
 }
@@ -0,0 +1,14 @@
+package v1
+
+import "time"
+
+// JournalEntry claimed job kept in the job journal until the agent finishes it.
+type JournalEntry struct {
+	Resource  Resource  `json:"resource"`
+	Footmark  string    `json:"footmark"`
+	Footmarks []string  `json:"footmarks"`
+	Status    string    `json:"status"`
+	Log       string    `json:"log"`
+	ClaimedAt time.Time `json:"claimed_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
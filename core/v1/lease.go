@@ -0,0 +1,15 @@
+package v1
+
+import "time"
+
+// JobLease job the agent has claimed and is working on, along with its latest progress.
+type JobLease struct {
+	ProcessId  string    `json:"process_id"`
+	Step       string    `json:"step"`
+	Claim      int       `json:"claim"`
+	Footmark   string    `json:"footmark"`
+	Status     string    `json:"status"`
+	Log        string    `json:"log"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
@@ -0,0 +1,135 @@
+package logic
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/klovercloud-ci-cd/agent/config"
+	v1 "github.com/klovercloud-ci-cd/agent/core/v1"
+	"github.com/klovercloud-ci-cd/agent/core/v1/service"
+	coreV1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"log"
+)
+
+const (
+	journalLabel           = "klovercloud_ci_journal"
+	journalAgentAnnotation = "ci.klovercloud.com/agent"
+)
+
+type configMapJournal struct {
+	kcs    *kubernetes.Clientset
+	writer *journalWriter
+}
+
+func journalConfigMapName(processId, step string) string {
+	return "klovercloud-ci-journal-" + journalKey(processId, step)
+}
+
+func (c configMapJournal) Save(entry v1.JournalEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	configMap := &coreV1.ConfigMap{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:        journalConfigMapName(entry.Resource.ProcessId, entry.Resource.Step),
+			Namespace:   config.JournalNamespace,
+			Labels:      map[string]string{journalLabel: "enabled"},
+			Annotations: map[string]string{journalAgentAnnotation: config.AgentName},
+		},
+		Data: map[string]string{"entry": string(data)},
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current, err := c.kcs.CoreV1().ConfigMaps(configMap.Namespace).Get(context.Background(), configMap.Name, metaV1.GetOptions{})
+		if k8sErrors.IsNotFound(err) {
+			_, err = c.kcs.CoreV1().ConfigMaps(configMap.Namespace).Create(context.Background(), configMap, metaV1.CreateOptions{FieldManager: config.FieldManager})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		current.Data = configMap.Data
+		_, err = c.kcs.CoreV1().ConfigMaps(current.Namespace).Update(context.Background(), current, metaV1.UpdateOptions{FieldManager: config.FieldManager})
+		return err
+	})
+}
+
+func (c configMapJournal) Remove(processId, step string) error {
+	err := c.kcs.CoreV1().ConfigMaps(config.JournalNamespace).Delete(context.Background(), journalConfigMapName(processId, step), metaV1.DeleteOptions{})
+	if k8sErrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (c configMapJournal) List() ([]v1.JournalEntry, error) {
+	configMaps, err := c.kcs.CoreV1().ConfigMaps(config.JournalNamespace).List(context.Background(), metaV1.ListOptions{LabelSelector: journalLabel + "=enabled"})
+	if err != nil {
+		return nil, err
+	}
+	var entries []v1.JournalEntry
+	for _, each := range configMaps.Items {
+		if each.Annotations[journalAgentAnnotation] != config.AgentName {
+			continue
+		}
+		entry := v1.JournalEntry{}
+		if err := json.Unmarshal([]byte(each.Data["entry"]), &entry); err != nil {
+			log.Println("Skipping journal entry", each.Name+":", err.Error())
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Listen records progress of the subject's job, if the job is still in the journal. Progress is coalesced by the writer.
+func (c configMapJournal) Listen(subject v1.Subject) {
+	if subject.ProcessId == "" {
+		return
+	}
+	c.writer.add(subject)
+}
+
+// progress records progress of subjects into the job's entry with a single update.
+// Only existing entries are updated, so a late event never brings back a removed entry.
+func (c configMapJournal) progress(processId, step string, subjects []v1.Subject) {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current, err := c.kcs.CoreV1().ConfigMaps(config.JournalNamespace).Get(context.Background(), journalConfigMapName(processId, step), metaV1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		entry := v1.JournalEntry{}
+		if err := json.Unmarshal([]byte(current.Data["entry"]), &entry); err != nil {
+			return err
+		}
+		changed := false
+		for _, each := range subjects {
+			changed = journalProgress(&entry, each) || changed
+		}
+		if !changed {
+			return nil
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		current.Data["entry"] = string(data)
+		_, err = c.kcs.CoreV1().ConfigMaps(current.Namespace).Update(context.Background(), current, metaV1.UpdateOptions{FieldManager: config.FieldManager})
+		return err
+	})
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		log.Println("Failed to update journal entry:", err.Error())
+	}
+}
+
+// NewConfigMapJournal returns Journal type service
+func NewConfigMapJournal(kcs *kubernetes.Clientset) service.Journal {
+	journal := &configMapJournal{
+		kcs: kcs,
+	}
+	journal.writer = newJournalWriter(journal.progress)
+	return journal
+}
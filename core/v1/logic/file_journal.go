@@ -0,0 +1,132 @@
+package logic
+
+import (
+	"encoding/json"
+	"github.com/klovercloud-ci-cd/agent/config"
+	v1 "github.com/klovercloud-ci-cd/agent/core/v1"
+	"github.com/klovercloud-ci-cd/agent/core/v1/service"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// fileJournalMutex guards journal files, shared by every file journal of the agent.
+var fileJournalMutex sync.Mutex
+
+type fileJournal struct {
+	dir    string
+	writer *journalWriter
+}
+
+func (f fileJournal) path(processId, step string) string {
+	return filepath.Join(f.dir, journalKey(processId, step)+".json")
+}
+
+func (f fileJournal) Save(entry v1.JournalEntry) error {
+	fileJournalMutex.Lock()
+	defer fileJournalMutex.Unlock()
+	return f.write(entry)
+}
+
+// write replaces entry file atomically, so a crash never leaves a partial entry behind.
+func (f fileJournal) write(entry v1.JournalEntry) error {
+	if err := os.MkdirAll(f.dir, 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	path := f.path(entry.Resource.ProcessId, entry.Resource.Step)
+	if err := ioutil.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (f fileJournal) read(path string) (*v1.JournalEntry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	entry := v1.JournalEntry{}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (f fileJournal) Remove(processId, step string) error {
+	fileJournalMutex.Lock()
+	defer fileJournalMutex.Unlock()
+	err := os.Remove(f.path(processId, step))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (f fileJournal) List() ([]v1.JournalEntry, error) {
+	fileJournalMutex.Lock()
+	defer fileJournalMutex.Unlock()
+	files, err := ioutil.ReadDir(f.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []v1.JournalEntry
+	for _, each := range files {
+		if each.IsDir() || !strings.HasSuffix(each.Name(), ".json") {
+			continue
+		}
+		entry, err := f.read(filepath.Join(f.dir, each.Name()))
+		if err != nil {
+			log.Println("Skipping journal entry", each.Name()+":", err.Error())
+			continue
+		}
+		entries = append(entries, *entry)
+	}
+	return entries, nil
+}
+
+// Listen records progress of the subject's job, if the job is still in the journal. Progress is coalesced by the writer.
+func (f fileJournal) Listen(subject v1.Subject) {
+	if subject.ProcessId == "" {
+		return
+	}
+	f.writer.add(subject)
+}
+
+// progress records progress of subjects into the job's entry with a single write.
+func (f fileJournal) progress(processId, step string, subjects []v1.Subject) {
+	fileJournalMutex.Lock()
+	defer fileJournalMutex.Unlock()
+	entry, err := f.read(f.path(processId, step))
+	if err != nil {
+		return
+	}
+	changed := false
+	for _, each := range subjects {
+		changed = journalProgress(entry, each) || changed
+	}
+	if !changed {
+		return
+	}
+	if err := f.write(*entry); err != nil {
+		log.Println("Failed to update journal entry:", err.Error())
+	}
+}
+
+// NewFileJournal returns Journal type service
+func NewFileJournal() service.Journal {
+	journal := &fileJournal{
+		dir: config.JournalDir,
+	}
+	journal.writer = newJournalWriter(journal.progress)
+	return journal
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/klovercloud-ci-cd/agent/opentracing"
 	opentracer "github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -18,9 +19,14 @@ import (
 type httpClientService struct {
 }
 
+// Post posts body to url. Non 2xx responses are logged and returned as errors, so that the kube event outbox retries
+// them and job leases fail, other callers only log them.
 func (h httpClientService) Post(url string, header map[string]string, body []byte) error {
 	//log.Println("Posting ...", url, string(body))
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
 	for k, v := range header {
 		req.Header.Set(k, v)
 	}
@@ -33,13 +39,14 @@ func (h httpClientService) Post(url string, header map[string]string, body []byt
 		return err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
 		body, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
 			log.Println("[ERROR] Failed communicate :", err.Error())
 		} else {
 			log.Println("[ERROR] Failed communicate ::", string(body))
 		}
+		return errors.New("Status: " + resp.Status + ", code: " + strconv.Itoa(resp.StatusCode))
 	}
 	return nil
 }
@@ -72,6 +79,28 @@ func (h httpClientService) Get(url string, header map[string]string) ([]byte, er
 	return nil, errors.New("Status: " + res.Status + ", code: " + strconv.Itoa(res.StatusCode))
 }
 
+// Stream opens a long-lived GET request and returns its body. Request is aborted once ctx is done.
+func (h httpClientService) Stream(ctx context.Context, url string, header map[string]string) (io.ReadCloser, error) {
+	client := http.Client{}
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	startTraceSpan(req, url, "GET")
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, errors.New("Status: " + res.Status + ", code: " + strconv.Itoa(res.StatusCode))
+	}
+	return res.Body, nil
+}
+
 // startTraceSpan starts a span
 func startTraceSpan(req *http.Request, url, httpMethod string) {
 	if config.EnableOpenTracing {
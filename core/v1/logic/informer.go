@@ -0,0 +1,240 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"github.com/klovercloud-ci-cd/agent/config"
+	appsV1 "k8s.io/api/apps/v1"
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"sync"
+)
+
+// ciLabelSelector selects objects managed by the agent, pushed to api server so only those are cached.
+const ciLabelSelector = "klovercloud_ci"
+
+var (
+	podsResource         = schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+	eventsResource       = schema.GroupVersionResource{Version: "v1", Resource: "events"}
+	deploymentsResource  = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	statefulSetsResource = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "statefulsets"}
+	daemonSetsResource   = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "daemonsets"}
+)
+
+// ciInformers shared informer factories of the agent. Namespaced kinds are watched per scoped namespace,
+// or across every namespace if none is configured. Pod events are not labelled, they are watched with a
+// field selector instead.
+type ciInformers struct {
+	cluster    dynamicinformer.DynamicSharedInformerFactory
+	namespaced map[string]dynamicinformer.DynamicSharedInformerFactory
+	events     map[string]dynamicinformer.DynamicSharedInformerFactory
+	stop       chan struct{}
+	// caching is true if informers of the kinds read by deploy paths are run, so their listers can stand in for live reads.
+	caching   bool
+	startOnce sync.Once
+}
+
+var (
+	sharedInformers     *ciInformers
+	sharedInformersOnce sync.Once
+)
+
+// getCiInformers returns informer factories shared by every k8s service of the agent. Informers of the kinds
+// read by deploy paths are only requested if lighthouse is enabled and scoped to namespaces, so that the agent
+// does not cache objects of the whole cluster.
+func getCiInformers(dynamicClient dynamic.Interface) *ciInformers {
+	sharedInformersOnce.Do(func() {
+		withCiLabel := func(options *metaV1.ListOptions) {
+			options.LabelSelector = ciLabelSelector
+		}
+		withPodEvents := func(options *metaV1.ListOptions) {
+			options.FieldSelector = "involvedObject.kind=Pod"
+		}
+		sharedInformers = &ciInformers{
+			cluster:    dynamicinformer.NewFilteredDynamicSharedInformerFactory(dynamicClient, 0, metaV1.NamespaceAll, withCiLabel),
+			namespaced: make(map[string]dynamicinformer.DynamicSharedInformerFactory),
+			events:     make(map[string]dynamicinformer.DynamicSharedInformerFactory),
+			stop:       make(chan struct{}),
+			caching:    config.LighthouseEnabled && len(config.LighthouseNamespaces) > 0,
+		}
+		namespaces := config.LighthouseNamespaces
+		if len(namespaces) == 0 {
+			namespaces = []string{metaV1.NamespaceAll}
+		}
+		for _, each := range namespaces {
+			factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(dynamicClient, 0, each, withCiLabel)
+			if sharedInformers.caching {
+				factory.ForResource(podsResource).Informer()
+				factory.ForResource(deploymentsResource).Informer()
+				factory.ForResource(statefulSetsResource).Informer()
+				factory.ForResource(daemonSetsResource).Informer()
+			}
+			sharedInformers.namespaced[each] = factory
+			sharedInformers.events[each] = dynamicinformer.NewFilteredDynamicSharedInformerFactory(dynamicClient, 0, each, withPodEvents)
+		}
+	})
+	return sharedInformers
+}
+
+// start starts informers requested so far. Informers requested later are started by the next call.
+func (c *ciInformers) start() {
+	c.cluster.Start(c.stop)
+	for _, each := range c.namespaced {
+		each.Start(c.stop)
+	}
+	for _, each := range c.events {
+		each.Start(c.stop)
+	}
+}
+
+// watch registers handler on informers of the resource, in every scoped namespace if the resource is namespaced.
+func (c *ciInformers) watch(resource lighthouseResource, handler cache.ResourceEventHandler) {
+	factories := c.namespaced
+	if resource.resource == eventsResource {
+		factories = c.events
+	} else if !resource.namespaced {
+		factories = map[string]dynamicinformer.DynamicSharedInformerFactory{metaV1.NamespaceAll: c.cluster}
+	}
+	for _, each := range factories {
+		each.ForResource(resource.resource).Informer().AddEventHandler(handler)
+	}
+}
+
+// factoryOf returns the factory watching namespace, nil if namespace is out of scope.
+func (c *ciInformers) factoryOf(namespace string) dynamicinformer.DynamicSharedInformerFactory {
+	if factory, ok := c.namespaced[metaV1.NamespaceAll]; ok {
+		return factory
+	}
+	return c.namespaced[namespace]
+}
+
+// lister returns lister of the resource in namespace, false if caching is disabled, namespace is out of scope or the informer
+// has not synced yet. Informers are started on first read.
+func (c *ciInformers) lister(resource schema.GroupVersionResource, namespace string) (cache.GenericNamespaceLister, bool) {
+	if !c.caching {
+		return nil, false
+	}
+	factory := c.factoryOf(namespace)
+	if factory == nil {
+		return nil, false
+	}
+	informer := factory.ForResource(resource)
+	c.startOnce.Do(c.start)
+	if !informer.Informer().HasSynced() {
+		return nil, false
+	}
+	return informer.Lister().ByNamespace(namespace), true
+}
+
+// fromCached converts a cached object into out. Conversion makes a copy, so out can be modified freely.
+func fromCached(obj runtime.Object, out interface{}) error {
+	object, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return errors.New("cached object is not unstructured")
+	}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(object.UnstructuredContent(), out)
+}
+
+// requiresCiLabel returns true if every object matching selector carries the klovercloud_ci label, so the cache can answer it.
+func requiresCiLabel(selector labels.Selector) bool {
+	requirements, _ := selector.Requirements()
+	for _, each := range requirements {
+		if each.Key() != ciLabelSelector {
+			continue
+		}
+		switch each.Operator() {
+		case selection.Exists, selection.Equals, selection.DoubleEquals, selection.In:
+			return true
+		}
+	}
+	return false
+}
+
+// listPods lists pods matching selector from the shared cache. Api server is asked instead while the cache is not synced,
+// namespace is out of scope, selector may match pods without the klovercloud_ci label or the cache has no match yet.
+func (k k8sService) listPods(namespace, selector string) ([]coreV1.Pod, error) {
+	parsed, err := labels.Parse(selector)
+	if err != nil {
+		return nil, err
+	}
+	if requiresCiLabel(parsed) {
+		if lister, ok := k.informers.lister(podsResource, namespace); ok {
+			cached, err := lister.List(parsed)
+			if err == nil && len(cached) > 0 {
+				pods := make([]coreV1.Pod, len(cached))
+				for i, each := range cached {
+					if err := fromCached(each, &pods[i]); err != nil {
+						return nil, err
+					}
+				}
+				return pods, nil
+			}
+		}
+	}
+	podList, err := k.kcs.CoreV1().Pods(namespace).List(context.Background(), metaV1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+	return podList.Items, nil
+}
+
+// cachedPod returns a klovercloud_ci labelled pod from the shared cache, or api server if the cache can not answer.
+func (k k8sService) cachedPod(namespace, name string) (*coreV1.Pod, error) {
+	if lister, ok := k.informers.lister(podsResource, namespace); ok {
+		obj, err := lister.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		pod := &coreV1.Pod{}
+		return pod, fromCached(obj, pod)
+	}
+	return k.kcs.CoreV1().Pods(namespace).Get(context.Background(), name, metaV1.GetOptions{})
+}
+
+// cachedDeployment returns deployment from the shared cache if the cached one has observed at least minGeneration,
+// otherwise from api server. Zero minGeneration always reads from api server, as cache may lag behind own writes.
+func (k k8sService) cachedDeployment(name, namespace string, minGeneration int64) (*appsV1.Deployment, error) {
+	if minGeneration > 0 {
+		if lister, ok := k.informers.lister(deploymentsResource, namespace); ok {
+			deployment := &appsV1.Deployment{}
+			if obj, err := lister.Get(name); err == nil && fromCached(obj, deployment) == nil && deployment.Generation >= minGeneration {
+				return deployment, nil
+			}
+		}
+	}
+	return k.GetDeployment(name, namespace)
+}
+
+// cachedStatefulSet returns statefulSet from the shared cache, following cachedDeployment semantics.
+func (k k8sService) cachedStatefulSet(name, namespace string, minGeneration int64) (*appsV1.StatefulSet, error) {
+	if minGeneration > 0 {
+		if lister, ok := k.informers.lister(statefulSetsResource, namespace); ok {
+			statefulSet := &appsV1.StatefulSet{}
+			if obj, err := lister.Get(name); err == nil && fromCached(obj, statefulSet) == nil && statefulSet.Generation >= minGeneration {
+				return statefulSet, nil
+			}
+		}
+	}
+	return k.GetStatefulSet(name, namespace)
+}
+
+// cachedDaemonSet returns daemonSet from the shared cache, following cachedDeployment semantics.
+func (k k8sService) cachedDaemonSet(name, namespace string, minGeneration int64) (*appsV1.DaemonSet, error) {
+	if minGeneration > 0 {
+		if lister, ok := k.informers.lister(daemonSetsResource, namespace); ok {
+			daemonSet := &appsV1.DaemonSet{}
+			if obj, err := lister.Get(name); err == nil && fromCached(obj, daemonSet) == nil && daemonSet.Generation >= minGeneration {
+				return daemonSet, nil
+			}
+		}
+	}
+	return k.GetDaemonSet(name, namespace)
+}
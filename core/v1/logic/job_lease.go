@@ -0,0 +1,136 @@
+package logic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/klovercloud-ci-cd/agent/config"
+	v1 "github.com/klovercloud-ci-cd/agent/core/v1"
+	"github.com/klovercloud-ci-cd/agent/core/v1/service"
+	"log"
+	"sync"
+	"time"
+)
+
+type jobLeaseService struct {
+	httpClient service.HttpClient
+	mutex      *sync.Mutex
+	leases     map[string]*v1.JobLease
+}
+
+func jobLeaseKey(processId, step string) string {
+	return processId + "/" + step
+}
+
+func (j jobLeaseService) post(path string, body interface{}) error {
+	header := make(map[string]string)
+	header["Content-Type"] = "application/json"
+	header["token"] = config.Token
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return j.httpClient.Post(config.ApiServiceUrl+"/process_life_cycle_events/"+path, header, b)
+}
+
+// Acquire acknowledges receipt of the job to api service and starts heartbeating it.
+func (j jobLeaseService) Acquire(resource v1.Resource) error {
+	now := time.Now().UTC()
+	j.mutex.Lock()
+	j.leases[jobLeaseKey(resource.ProcessId, resource.Step)] = &v1.JobLease{
+		ProcessId:  resource.ProcessId,
+		Step:       resource.Step,
+		Claim:      resource.Claim,
+		AcquiredAt: now,
+		UpdatedAt:  now,
+	}
+	j.mutex.Unlock()
+	return j.post("acknowledgements", map[string]interface{}{
+		"agent":         config.AgentName,
+		"process_id":    resource.ProcessId,
+		"step":          resource.Step,
+		"claim":         resource.Claim,
+		"lease_seconds": config.LeaseSeconds,
+	})
+}
+
+// Complete stops heartbeating a finished job. Its final status is reported by the process life cycle events.
+func (j jobLeaseService) Complete(resource v1.Resource) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	delete(j.leases, jobLeaseKey(resource.ProcessId, resource.Step))
+}
+
+// Release gives a job the agent can not complete back to api service, so that it can be reassigned.
+func (j jobLeaseService) Release(resource v1.Resource, reason string) error {
+	j.Complete(resource)
+	log.Println("Releasing job of process", resource.ProcessId, "step", resource.Step+":", reason)
+	return j.post("releases", map[string]interface{}{
+		"agent":      config.AgentName,
+		"process_id": resource.ProcessId,
+		"step":       resource.Step,
+		"claim":      resource.Claim,
+		"reason":     reason,
+	})
+}
+
+// Heartbeat renews leases of active jobs along with their progress, until ctx is done.
+// Heartbeats are sent even without active jobs, so api service can tell the agent is alive.
+func (j jobLeaseService) Heartbeat(ctx context.Context) {
+	interval := time.Second * time.Duration(config.LeaseSeconds) / 3
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		j.mutex.Lock()
+		leases := []v1.JobLease{}
+		for _, each := range j.leases {
+			leases = append(leases, *each)
+		}
+		j.mutex.Unlock()
+		err := j.post("heartbeats", map[string]interface{}{
+			"agent":         config.AgentName,
+			"lease_seconds": config.LeaseSeconds,
+			"leases":        leases,
+		})
+		if err != nil {
+			log.Println("Failed to send heartbeat:", err.Error())
+		}
+	}
+}
+
+// Listen records progress of the subject's job, if the job is leased.
+func (j jobLeaseService) Listen(subject v1.Subject) {
+	if subject.EventData == nil {
+		return
+	}
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	lease, ok := j.leases[jobLeaseKey(subject.ProcessId, subject.Step)]
+	if !ok {
+		return
+	}
+	if footmark, ok := subject.EventData["footmark"]; ok {
+		lease.Footmark = fmt.Sprint(footmark)
+	}
+	if status, ok := subject.EventData["status"]; ok {
+		lease.Status = fmt.Sprint(status)
+	}
+	if subject.Log != "" {
+		lease.Log = subject.Log
+	}
+	lease.UpdatedAt = time.Now().UTC()
+}
+
+// NewJobLeaseService returns JobLease type service
+func NewJobLeaseService(httpClient service.HttpClient) service.JobLease {
+	return &jobLeaseService{
+		httpClient: httpClient,
+		mutex:      &sync.Mutex{},
+		leases:     make(map[string]*v1.JobLease),
+	}
+}
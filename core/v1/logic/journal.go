@@ -0,0 +1,93 @@
+package logic
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	v1 "github.com/klovercloud-ci-cd/agent/core/v1"
+	"github.com/klovercloud-ci-cd/agent/enums"
+	"sync"
+	"time"
+)
+
+// journalFlushDelay is how long progress of a job is collected before it is written.
+const journalFlushDelay = time.Second
+
+// journalWriter coalesces progress of journaled jobs. Subjects of a job are queued and written by a single flush at a time,
+// in the order they were received, so writes of a job never interleave and a burst of log lines costs a single write.
+type journalWriter struct {
+	sync.Mutex
+	pending map[string][]v1.Subject
+	write   func(processId, step string, subjects []v1.Subject)
+}
+
+func newJournalWriter(write func(processId, step string, subjects []v1.Subject)) *journalWriter {
+	return &journalWriter{
+		pending: make(map[string][]v1.Subject),
+		write:   write,
+	}
+}
+
+// add queues subject, starting a flush for its job unless one is running.
+func (w *journalWriter) add(subject v1.Subject) {
+	key := journalKey(subject.ProcessId, subject.Step)
+	w.Lock()
+	subjects, flushing := w.pending[key]
+	w.pending[key] = append(subjects, subject)
+	w.Unlock()
+	if !flushing {
+		go w.flush(key, subject.ProcessId, subject.Step)
+	}
+}
+
+// flush writes queued subjects of the job until none is left.
+func (w *journalWriter) flush(key, processId, step string) {
+	for {
+		time.Sleep(journalFlushDelay)
+		w.Lock()
+		subjects := w.pending[key]
+		if len(subjects) == 0 {
+			delete(w.pending, key)
+			w.Unlock()
+			return
+		}
+		w.pending[key] = []v1.Subject{}
+		w.Unlock()
+		w.write(processId, step, subjects)
+	}
+}
+
+func journalKey(processId, step string) string {
+	hash := sha1.Sum([]byte(processId + "/" + step))
+	return hex.EncodeToString(hash[:])[:16]
+}
+
+// journalProgress copies footmark, status and log of subject into entry. Returns false if subject carries no progress.
+func journalProgress(entry *v1.JournalEntry, subject v1.Subject) bool {
+	if subject.EventData == nil {
+		return false
+	}
+	if footmark, ok := subject.EventData["footmark"]; ok {
+		entry.Footmark = fmt.Sprint(footmark)
+		if !hasFootmark(*entry, enums.FOOTMARK(entry.Footmark)) {
+			entry.Footmarks = append(entry.Footmarks, entry.Footmark)
+		}
+	}
+	if status, ok := subject.EventData["status"]; ok {
+		entry.Status = fmt.Sprint(status)
+	}
+	if subject.Log != "" {
+		entry.Log = subject.Log
+	}
+	entry.UpdatedAt = time.Now().UTC()
+	return true
+}
+
+func hasFootmark(entry v1.JournalEntry, footmark enums.FOOTMARK) bool {
+	for _, each := range entry.Footmarks {
+		if each == string(footmark) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+package logic
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "github.com/klovercloud-ci-cd/agent/core/v1"
+	"github.com/klovercloud-ci-cd/agent/enums"
+)
+
+func TestJournalProgress(t *testing.T) {
+	testData := []struct {
+		name      string
+		entry     v1.JournalEntry
+		subject   v1.Subject
+		progress  bool
+		footmark  string
+		footmarks string
+		status    string
+		log       string
+	}{
+		{
+			name:    "no event data",
+			entry:   v1.JournalEntry{Footmark: "init_agent_job", Status: "INITIALIZING", Log: "started"},
+			subject: v1.Subject{Log: "ignored"},
+			// entry is left as it is.
+			footmark: "init_agent_job", status: "INITIALIZING", log: "started",
+		},
+		{
+			name:     "first footmark",
+			subject:  v1.Subject{Log: "Deploy Step Started", EventData: map[string]interface{}{"footmark": enums.INIT_AGNET_JOB, "status": enums.INITIALIZING}},
+			progress: true,
+			footmark: "init_agent_job", footmarks: "init_agent_job", status: "INITIALIZING", log: "Deploy Step Started",
+		},
+		{
+			name:     "next footmark",
+			entry:    v1.JournalEntry{Footmark: "init_agent_job", Footmarks: []string{"init_agent_job"}, Status: "INITIALIZING"},
+			subject:  v1.Subject{EventData: map[string]interface{}{"footmark": enums.UPDATE_RESOURCE, "status": enums.PROCESSING}},
+			progress: true,
+			footmark: "update_resource", footmarks: "init_agent_job,update_resource", status: "PROCESSING",
+		},
+		{
+			name:     "repeated footmark",
+			entry:    v1.JournalEntry{Footmark: "update_resource", Footmarks: []string{"init_agent_job", "update_resource"}, Log: "previous"},
+			subject:  v1.Subject{Log: "Waiting for rollout", EventData: map[string]interface{}{"footmark": enums.UPDATE_RESOURCE}},
+			progress: true,
+			footmark: "update_resource", footmarks: "init_agent_job,update_resource", log: "Waiting for rollout",
+		},
+		{
+			name:     "log kept without subject log",
+			entry:    v1.JournalEntry{Log: "previous"},
+			subject:  v1.Subject{EventData: map[string]interface{}{"status": enums.SUCCESSFUL}},
+			progress: true,
+			status:   "SUCCESSFUL", log: "previous",
+		},
+	}
+	for _, each := range testData {
+		entry := each.entry
+		if progress := journalProgress(&entry, each.subject); progress != each.progress {
+			t.Errorf("%s: expected progress %v, got %v", each.name, each.progress, progress)
+		}
+		if entry.Footmark != each.footmark || strings.Join(entry.Footmarks, ",") != each.footmarks || entry.Status != each.status || entry.Log != each.log {
+			t.Errorf("%s: unexpected entry %+v", each.name, entry)
+		}
+		if each.progress && entry.UpdatedAt.IsZero() {
+			t.Errorf("%s: expected updated at to be set", each.name)
+		}
+	}
+}
+
+// journalRecorder records writes of a journal writer.
+type journalRecorder struct {
+	sync.Mutex
+	writes map[string][][]string
+}
+
+func (j *journalRecorder) write(processId, step string, subjects []v1.Subject) {
+	j.Lock()
+	defer j.Unlock()
+	var logs []string
+	for _, each := range subjects {
+		logs = append(logs, each.Log)
+	}
+	j.writes[processId+"/"+step] = append(j.writes[processId+"/"+step], logs)
+}
+
+func (j *journalRecorder) written(key string) (int, []string) {
+	j.Lock()
+	defer j.Unlock()
+	var logs []string
+	for _, each := range j.writes[key] {
+		logs = append(logs, each...)
+	}
+	return len(j.writes[key]), logs
+}
+
+func TestJournalWriter(t *testing.T) {
+	recorder := &journalRecorder{writes: make(map[string][][]string)}
+	writer := newJournalWriter(recorder.write)
+	jobs := []string{"process-1", "process-2"}
+	var wg sync.WaitGroup
+	for _, each := range jobs {
+		wg.Add(1)
+		go func(processId string) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				writer.add(v1.Subject{ProcessId: processId, Step: "deploy", Log: strconv.Itoa(i)})
+			}
+		}(each)
+	}
+	wg.Wait()
+	deadline := time.Now().Add(journalFlushDelay * 10)
+	for _, each := range jobs {
+		for {
+			writes, logs := recorder.written(each + "/deploy")
+			if len(logs) == 50 {
+				// a burst is coalesced, subjects are written in the order they were received.
+				if writes > 2 {
+					t.Errorf("%s: expected burst to be coalesced, got %d writes", each, writes)
+				}
+				for i, log := range logs {
+					if log != strconv.Itoa(i) {
+						t.Fatalf("%s: expected subjects in order, got %v", each, logs)
+					}
+				}
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: expected 50 subjects to be written, got %d", each, len(logs))
+			}
+			time.Sleep(time.Millisecond * 50)
+		}
+	}
+	// flush of a job ends once nothing is left, next subject starts a new one.
+	for {
+		writer.Lock()
+		pending := len(writer.pending)
+		writer.Unlock()
+		if pending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected flushes to end")
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+	writer.add(v1.Subject{ProcessId: "process-1", Step: "deploy", Log: "50"})
+	for {
+		if _, logs := recorder.written("process-1/deploy"); len(logs) == 51 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected subject added after flush ended to be written")
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+}
@@ -1,7 +1,11 @@
 package logic
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,25 +14,30 @@ import (
 	"github.com/klovercloud-ci-cd/agent/core/v1/service"
 	"github.com/klovercloud-ci-cd/agent/enums"
 	appsV1 "k8s.io/api/apps/v1"
+	autoscalingV1 "k8s.io/api/autoscaling/v1"
+	batchV1 "k8s.io/api/batch/v1"
+	batchV1beta1 "k8s.io/api/batch/v1beta1"
 	coreV1 "k8s.io/api/core/v1"
-	"k8s.io/api/extensions/v1beta1"
-	networkingV1 "k8s.io/api/networking/v1"
-	rbacV1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/strategicpatch"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/util/retry"
 	"log"
+	"math"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -38,6 +47,7 @@ type k8sService struct {
 	discoveryClient    *discovery.DiscoveryClient
 	observerList       []service.Observer
 	kubeEventPublisher service.KubeEventPublisher
+	informers          *ciInformers
 }
 
 type KubeObject struct {
@@ -45,1391 +55,2627 @@ type KubeObject struct {
 	NewK8sObj interface{} `json:"new_k8s_obj" bson:"new_k8s_obj"`
 }
 
-func (k k8sService) ListenNamespaceEvents() (cache.Store, cache.Controller) {
-	watchlist := cache.NewListWatchFromClient(k.kcs.CoreV1().RESTClient(), "namespaces", "",
-		fields.Everything())
-	extrasMap := map[string]string{"type": "namespace", "agent": config.AgentName, "object": string(enums.NAMESPACE)}
-	return cache.NewInformer(
-		watchlist,
-		&coreV1.Namespace{},
-		time.Second*0,
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				ns := obj.(*coreV1.Namespace)
-				if _, ok := ns.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: ns,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.ADD,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("add Namespace: ", ns.Name)
-				}
-			},
-			DeleteFunc: func(obj interface{}) {
-				ns := obj.(*coreV1.Namespace)
-				if _, ok := ns.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: ns,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.DELETE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("delete Namespace:", ns.Name)
-				}
-			},
-			UpdateFunc: func(oldObj, newObj interface{}) {
-				oldK8sObj := oldObj.(*coreV1.Namespace)
-				newK8sObj := newObj.(*coreV1.Namespace)
-				obj := KubeObject{
-					OldK8sObj: oldK8sObj,
-					NewK8sObj: newK8sObj,
-				}
-				if _, ok := newK8sObj.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: obj,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.UPDATE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("old Namespace:", oldK8sObj.Name, ", new Namespace:", newK8sObj.Name)
-				}
-			},
-		},
-	)
-}
-
-func (k k8sService) ListenServiceEvents() (cache.Store, cache.Controller) {
-	watchlist := cache.NewListWatchFromClient(k.kcs.CoreV1().RESTClient(), "services", "", fields.Everything())
-	extrasMap := map[string]string{"type": "service", "agent": config.AgentName, "object": string(enums.SERVICE)}
-	return cache.NewInformer(
-		watchlist,
-		&coreV1.Service{},
-		time.Second*0,
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				svc := obj.(*coreV1.Service)
-				if _, ok := svc.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: svc,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.ADD,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("add Service: ", svc.Name)
-				}
-			},
-			DeleteFunc: func(obj interface{}) {
-				svc := obj.(*coreV1.Service)
-				if _, ok := svc.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: svc,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.DELETE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("delete Service:", svc.Name)
-				}
-			},
-			UpdateFunc: func(oldObj, newObj interface{}) {
-				oldK8sObj := oldObj.(*coreV1.Service)
-				newK8sObj := newObj.(*coreV1.Service)
-				obj := KubeObject{
-					OldK8sObj: oldK8sObj,
-					NewK8sObj: newK8sObj,
-				}
-				if _, ok := newK8sObj.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: obj,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.UPDATE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("old Service:", oldK8sObj.Name, ", new Service:", newK8sObj.Name)
-				}
-			},
-		},
-	)
-}
-
-func (k k8sService) ListenPodEvents() (cache.Store, cache.Controller) {
-	watchlist := cache.NewListWatchFromClient(k.kcs.CoreV1().RESTClient(), "pods", "",
-		fields.Everything())
-	extrasMap := map[string]string{"type": "pod", "agent": config.AgentName, "object": string(enums.POD)}
-	return cache.NewInformer(
-		watchlist,
-		&coreV1.Pod{},
-		time.Second*0,
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				pod := obj.(*coreV1.Pod)
-				if _, ok := pod.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: pod,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.ADD,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("add Pod: ", pod.Name)
-				}
-			},
-			DeleteFunc: func(obj interface{}) {
-				pod := obj.(*coreV1.Pod)
-				if _, ok := pod.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: pod,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.DELETE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("delete Pod:", pod.Name)
-				}
-			},
-			UpdateFunc: func(oldObj, newObj interface{}) {
-				oldK8sObj := oldObj.(*coreV1.Pod)
-				newK8sObj := newObj.(*coreV1.Pod)
-				log.Println("Status:", newK8sObj.Status.Phase)
-				if _, ok := newK8sObj.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: KubeObject{
-							OldK8sObj: oldK8sObj,
-							NewK8sObj: newK8sObj,
-						},
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.UPDATE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("old Pod:", oldK8sObj.Name, ", new Pod:", newK8sObj.Name)
-				}
-			},
-		},
-	)
-}
-
-func (k k8sService) ListenDeployEvents() (cache.Store, cache.Controller) {
-	watchlist := cache.NewListWatchFromClient(k.kcs.AppsV1().RESTClient(), "deployments", "", fields.Everything())
-	extrasMap := map[string]string{"type": "deployment", "agent": config.AgentName, "object": string(enums.DEPLOYMENT)}
-	return cache.NewInformer(
-		watchlist,
-		&appsV1.Deployment{},
-		time.Second*0,
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				deployment := obj.(*appsV1.Deployment)
-				if _, ok := deployment.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: deployment,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.ADD,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("add Deploy: ", deployment.Name)
-				}
-			},
-			DeleteFunc: func(obj interface{}) {
-				deployment := obj.(*appsV1.Deployment)
-				if _, ok := deployment.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: deployment,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.DELETE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("delete Deploy: ", deployment.Name)
-				}
-			},
-			UpdateFunc: func(oldObj, newObj interface{}) {
-				oldK8sObj := oldObj.(*appsV1.Deployment)
-				newK8sObj := newObj.(*appsV1.Deployment)
-				obj := KubeObject{
-					OldK8sObj: oldK8sObj,
-					NewK8sObj: newK8sObj,
-				}
-				if _, ok := newK8sObj.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: obj,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.UPDATE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("old Deploy:", oldK8sObj.Name, ", new Deploy:", newK8sObj.Name)
-				}
-			},
-		},
-	)
-}
-
-func (k k8sService) ListenIngressEvents() (cache.Store, cache.Controller) {
-	watchlist := cache.NewListWatchFromClient(k.kcs.ExtensionsV1beta1().RESTClient(), "ingresses", "", fields.Everything())
-	extrasMap := map[string]string{"type": "ingress", "agent": config.AgentName, "object": string(enums.INGRESS)}
-	return cache.NewInformer(
-		watchlist,
-		&v1beta1.Ingress{},
-		time.Second*0,
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				ingress := obj.(*v1beta1.Ingress)
-				if _, ok := ingress.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: ingress,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.ADD,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("add Ingress: ", ingress.Name)
-				}
-			},
-			DeleteFunc: func(obj interface{}) {
-				ingress := obj.(*v1beta1.Ingress)
-				if _, ok := ingress.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: ingress,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.DELETE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("delete Ingress: ", ingress.Name)
-				}
-			},
-			UpdateFunc: func(oldObj, newObj interface{}) {
-				oldK8sObj := oldObj.(*v1beta1.Ingress)
-				newK8sObj := newObj.(*v1beta1.Ingress)
-				obj := KubeObject{
-					OldK8sObj: oldK8sObj,
-					NewK8sObj: newK8sObj,
-				}
-				if _, ok := newK8sObj.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: obj,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.UPDATE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("old Ingress:", oldK8sObj.Name, ", new Ingress:", newK8sObj.Name)
-				}
-			},
-		},
-	)
-}
-
-func (k k8sService) ListenNetworkPolicyEvents() (cache.Store, cache.Controller) {
-	watchlist := cache.NewListWatchFromClient(k.kcs.NetworkingV1().RESTClient(), "networkpolicies", "", fields.Everything())
-	extrasMap := map[string]string{"type": "networkPolicy", "agent": config.AgentName, "object": string(enums.NETWORK_POLICY)}
-	return cache.NewInformer(
-		watchlist,
-		&networkingV1.NetworkPolicy{},
-		time.Second*0,
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				networkPolicy := obj.(*networkingV1.NetworkPolicy)
-				if _, ok := networkPolicy.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: networkPolicy,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.ADD,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("add NetworkPolicy: ", networkPolicy.Name)
-				}
-			},
-			DeleteFunc: func(obj interface{}) {
-				networkPolicy := obj.(*networkingV1.NetworkPolicy)
-				if _, ok := networkPolicy.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: networkPolicy,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.DELETE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("delete NetworkPolicy: ", networkPolicy.Name)
-				}
-			},
-			UpdateFunc: func(oldObj, newObj interface{}) {
-				oldK8sObj := oldObj.(*networkingV1.NetworkPolicy)
-				newK8sObj := newObj.(*networkingV1.NetworkPolicy)
-				obj := KubeObject{
-					OldK8sObj: oldK8sObj,
-					NewK8sObj: newK8sObj,
-				}
-				if _, ok := newK8sObj.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: obj,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.UPDATE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("old NetworkPolicy:", oldK8sObj.Name, ", new NetworkPolicy:", newK8sObj.Name)
-				}
-			},
-		},
-	)
-}
-
-func (k k8sService) ListenClusterRoleBindingEvents() (cache.Store, cache.Controller) {
-	watchlist := cache.NewListWatchFromClient(k.kcs.RbacV1().RESTClient(), "clusterrolebindings", "", fields.Everything())
-	extrasMap := map[string]string{"type": "clusterRoleBinding", "agent": config.AgentName, "object": string(enums.CLUSTER_ROLE_BINDGING)}
-	return cache.NewInformer(
-		watchlist,
-		&rbacV1.ClusterRoleBinding{},
-		time.Second*0,
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				clusterRoleBinding := obj.(*rbacV1.ClusterRoleBinding)
-				if _, ok := clusterRoleBinding.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: clusterRoleBinding,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.ADD,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("add ClusterRoleBinding: ", clusterRoleBinding.Name)
-				}
-			},
-			DeleteFunc: func(obj interface{}) {
-				clusterRoleBinding := obj.(*rbacV1.ClusterRoleBinding)
-				if _, ok := clusterRoleBinding.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: clusterRoleBinding,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.DELETE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("delete ClusterRoleBinding: ", clusterRoleBinding.Name)
-				}
-			},
-			UpdateFunc: func(oldObj, newObj interface{}) {
-				oldK8sObj := oldObj.(*rbacV1.ClusterRoleBinding)
-				newK8sObj := newObj.(*rbacV1.ClusterRoleBinding)
-				obj := KubeObject{
-					OldK8sObj: oldK8sObj,
-					NewK8sObj: newK8sObj,
-				}
-				if _, ok := newK8sObj.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: obj,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.UPDATE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("old ClusterRoleBinding:", oldK8sObj.Name, ", new ClusterRoleBinding:", newK8sObj.Name)
-				}
-			},
-		},
-	)
-}
-
-func (k k8sService) ListenClusterRoleEvents() (cache.Store, cache.Controller) {
-	watchlist := cache.NewListWatchFromClient(k.kcs.RbacV1().RESTClient(), "clusterroles", "", fields.Everything())
-	extrasMap := map[string]string{"type": "clusterRole", "agent": config.AgentName, "object": string(enums.CLUSTER_ROLE)}
-	return cache.NewInformer(
-		watchlist,
-		&rbacV1.ClusterRole{},
-		time.Second*0,
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				clusterRole := obj.(*rbacV1.ClusterRole)
-				if _, ok := clusterRole.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: clusterRole,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.ADD,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("add ClusterRole: ", clusterRole.Name)
-				}
-			},
-			DeleteFunc: func(obj interface{}) {
-				clusterRole := obj.(*rbacV1.ClusterRole)
-				if _, ok := clusterRole.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: clusterRole,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.DELETE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("delete ClusterRole: ", clusterRole.Name)
-				}
-			},
-			UpdateFunc: func(oldObj, newObj interface{}) {
-				oldK8sObj := oldObj.(*rbacV1.ClusterRole)
-				newK8sObj := newObj.(*rbacV1.ClusterRole)
-				obj := KubeObject{
-					OldK8sObj: oldK8sObj,
-					NewK8sObj: newK8sObj,
-				}
-				if _, ok := newK8sObj.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: obj,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.UPDATE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("old ClusterRole:", oldK8sObj.Name, ", new ClusterRole:", newK8sObj.Name)
-				}
-			},
-		},
-	)
-}
-
-func (k k8sService) ListenRoleBindingEvents() (cache.Store, cache.Controller) {
-	watchlist := cache.NewListWatchFromClient(k.kcs.RbacV1().RESTClient(), "rolebindings", "", fields.Everything())
-	extrasMap := map[string]string{"type": "roleBinding", "agent": config.AgentName, "object": string(enums.ROLE_BINDING)}
-	return cache.NewInformer(
-		watchlist,
-		&rbacV1.RoleBinding{},
-		time.Second*0,
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				roleBinding := obj.(*rbacV1.RoleBinding)
-				if _, ok := roleBinding.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: roleBinding,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.ADD,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("add RoleBinding: ", roleBinding.Name)
-				}
-			},
-			DeleteFunc: func(obj interface{}) {
-				roleBinding := obj.(*rbacV1.RoleBinding)
-				if _, ok := roleBinding.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: roleBinding,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.DELETE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("delete RoleBinding: ", roleBinding.Name)
-				}
-			},
-			UpdateFunc: func(oldObj, newObj interface{}) {
-				oldK8sObj := oldObj.(*rbacV1.RoleBinding)
-				newK8sObj := newObj.(*rbacV1.RoleBinding)
-				obj := KubeObject{
-					OldK8sObj: oldK8sObj,
-					NewK8sObj: newK8sObj,
-				}
-				if _, ok := newK8sObj.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: obj,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.UPDATE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("old RoleBinding:", oldK8sObj.Name, ", new RoleBinding:", newK8sObj.Name)
-				}
-			},
-		},
-	)
-}
-
-func (k k8sService) ListenRoleEvents() (cache.Store, cache.Controller) {
-	watchlist := cache.NewListWatchFromClient(k.kcs.RbacV1().RESTClient(), "roles", "", fields.Everything())
-	extrasMap := map[string]string{"type": "role", "agent": config.AgentName, "object": string(enums.ROLE)}
-	return cache.NewInformer(
-		watchlist,
-		&rbacV1.Role{},
-		time.Second*0,
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				role := obj.(*rbacV1.Role)
-				if _, ok := role.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: role,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.ADD,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("add Role: ", role.Name)
-				}
-			},
-			DeleteFunc: func(obj interface{}) {
-				role := obj.(*rbacV1.Role)
-				if _, ok := role.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: role,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.DELETE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("delete Role: ", role.Name)
-				}
-			},
-			UpdateFunc: func(oldObj, newObj interface{}) {
-				oldK8sObj := oldObj.(*rbacV1.Role)
-				newK8sObj := newObj.(*rbacV1.Role)
-				obj := KubeObject{
-					OldK8sObj: oldK8sObj,
-					NewK8sObj: newK8sObj,
-				}
-				if _, ok := newK8sObj.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: obj,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.UPDATE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("old Role:", oldK8sObj.Name, ", new Role:", newK8sObj.Name)
-				}
-			},
-		},
-	)
-}
-
-func (k k8sService) ListenServiceAccountEvents() (cache.Store, cache.Controller) {
-	watchlist := cache.NewListWatchFromClient(k.kcs.CoreV1().RESTClient(), "serviceAccounts", "", fields.Everything())
-	extrasMap := map[string]string{"type": "serviceAccount", "agent": config.AgentName, "object": string(enums.SERVICE_ACCOUNT)}
-	return cache.NewInformer(
-		watchlist,
-		&coreV1.ServiceAccount{},
-		time.Second*0,
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				serviceAccount := obj.(*coreV1.ServiceAccount)
-				if _, ok := serviceAccount.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: serviceAccount,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.ADD,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("add ServiceAccount: ", serviceAccount.Name)
-				}
-			},
-			DeleteFunc: func(obj interface{}) {
-				serviceAccount := obj.(*coreV1.ServiceAccount)
-				if _, ok := serviceAccount.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: serviceAccount,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.DELETE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("delete ServiceAccount: ", serviceAccount.Name)
-				}
-			},
-			UpdateFunc: func(oldObj, newObj interface{}) {
-				oldK8sObj := oldObj.(*coreV1.ServiceAccount)
-				newK8sObj := newObj.(*coreV1.ServiceAccount)
-				obj := KubeObject{
-					OldK8sObj: oldK8sObj,
-					NewK8sObj: newK8sObj,
-				}
-				if _, ok := newK8sObj.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: obj,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.UPDATE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("old ServiceAccount:", oldK8sObj.Name, ", new ServiceAccount:", newK8sObj.Name)
-				}
-			},
-		},
-	)
-}
-
-func (k k8sService) ListenSecretEvents() (cache.Store, cache.Controller) {
-	watchlist := cache.NewListWatchFromClient(k.kcs.CoreV1().RESTClient(), "secrets", "", fields.Everything())
-	extrasMap := map[string]string{"type": "secret", "agent": config.AgentName, "object": string(enums.SECRET)}
-	return cache.NewInformer(
-		watchlist,
-		&coreV1.Secret{},
-		time.Second*0,
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				secret := obj.(*coreV1.Secret)
-				if _, ok := secret.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: secret,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.ADD,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("add Secret: ", secret.Name)
-				}
-			},
-			DeleteFunc: func(obj interface{}) {
-				secret := obj.(*coreV1.Secret)
-				if _, ok := secret.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: secret,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.DELETE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("delete Secret: ", secret.Name)
-				}
-			},
-			UpdateFunc: func(oldObj, newObj interface{}) {
-				oldK8sObj := oldObj.(*coreV1.Secret)
-				newK8sObj := newObj.(*coreV1.Secret)
-				obj := KubeObject{
-					OldK8sObj: oldK8sObj,
-					NewK8sObj: newK8sObj,
-				}
-				if _, ok := newK8sObj.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: obj,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.UPDATE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("old Secret:", oldK8sObj.Name, ", new Secret:", newK8sObj.Name)
-				}
-			},
-		},
-	)
-}
-
-func (k k8sService) ListenConfigMapEvents() (cache.Store, cache.Controller) {
-	watchlist := cache.NewListWatchFromClient(k.kcs.CoreV1().RESTClient(), "configMaps", "", fields.Everything())
-	extrasMap := map[string]string{"type": "configMap", "agent": config.AgentName, "object": string(enums.CONFIG_MAP)}
-	return cache.NewInformer(
-		watchlist,
-		&coreV1.ConfigMap{},
-		time.Second*0,
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				configMap := obj.(*coreV1.ConfigMap)
-				if _, ok := configMap.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: configMap,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.ADD,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("add ConfigMap: ", configMap.Name)
-				}
-			},
-			DeleteFunc: func(obj interface{}) {
-				configMap := obj.(*coreV1.ConfigMap)
-				if _, ok := configMap.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: configMap,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.DELETE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("delete ConfigMap: ", configMap.Name)
-				}
-			},
-			UpdateFunc: func(oldObj, newObj interface{}) {
-				oldK8sObj := oldObj.(*coreV1.ConfigMap)
-				newK8sObj := newObj.(*coreV1.ConfigMap)
-				obj := KubeObject{
-					OldK8sObj: oldK8sObj,
-					NewK8sObj: newK8sObj,
-				}
-				if _, ok := newK8sObj.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: obj,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.UPDATE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("old ConfigMap:", oldK8sObj.Name, ", new ConfigMap:", newK8sObj.Name)
-				}
-			},
-		},
-	)
-}
-
-func (k k8sService) ListenPVCEvents() (cache.Store, cache.Controller) {
-	watchlist := cache.NewListWatchFromClient(k.kcs.CoreV1().RESTClient(), "persistentVolumeClaims", "", fields.Everything())
-	extrasMap := map[string]string{"type": "pvc", "agent": config.AgentName, "object": string(enums.PERSISTENT_VOLUME_CLAIM)}
-	return cache.NewInformer(
-		watchlist,
-		&coreV1.PersistentVolumeClaim{},
-		time.Second*0,
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				persistentVolumeClaim := obj.(*coreV1.PersistentVolumeClaim)
-				if _, ok := persistentVolumeClaim.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: persistentVolumeClaim,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.ADD,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("add PVC: ", persistentVolumeClaim.Name)
-				}
-			},
-			DeleteFunc: func(obj interface{}) {
-				persistentVolumeClaim := obj.(*coreV1.PersistentVolumeClaim)
-				if _, ok := persistentVolumeClaim.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: persistentVolumeClaim,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.DELETE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("delete PVC: ", persistentVolumeClaim.Name)
-				}
-			},
-			UpdateFunc: func(oldObj, newObj interface{}) {
-				oldK8sObj := oldObj.(*coreV1.PersistentVolumeClaim)
-				newK8sObj := newObj.(*coreV1.PersistentVolumeClaim)
-				obj := KubeObject{
-					OldK8sObj: oldK8sObj,
-					NewK8sObj: newK8sObj,
-				}
-				if _, ok := newK8sObj.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: obj,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.UPDATE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("old PVC:", oldK8sObj.Name, ", new PVC:", newK8sObj.Name)
-				}
-			},
+func (k k8sService) Apply(resource v1.Resource, data unstructured.Unstructured) error {
+	_, err := k.Deploy(resource, &data)
+	if err != nil {
+		log.Println(err.Error())
+		return err
+	}
+	return nil
+}
+
+func (k k8sService) Deploy(resource v1.Resource, data *unstructured.Unstructured) (bool, error) {
+	resourceInterface, err := k.resourceInterfaceOf(resource, data)
+	if err != nil {
+		return false, err
+	}
+	_, err = k.applyObject(resourceInterface, resource, data, false)
+	if err != nil {
+		return false, err
+	}
+	if data.GetKind() == "CustomResourceDefinition" {
+		if err := k.waitForCustomResourceDefinition(resource, resourceInterface, data.GetName()); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// waitForCustomResourceDefinition waits until the CRD is Established and its served versions are discoverable, so that its custom resources can be applied.
+func (k k8sService) waitForCustomResourceDefinition(resource v1.Resource, resourceInterface dynamic.ResourceInterface, name string) error {
+	return k.waitForRollout(resource, "", config.RolloutTimeout, func() (bool, string, error) {
+		crd, err := resourceInterface.Get(context.Background(), name, metaV1.GetOptions{})
+		if err != nil {
+			return false, "", err
+		}
+		conditions, _, _ := unstructured.NestedSlice(crd.Object, "status", "conditions")
+		established := false
+		for _, each := range conditions {
+			condition, ok := each.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if condition["type"] == "Established" && condition["status"] == "True" {
+				established = true
+			} else if condition["type"] == "NamesAccepted" && condition["status"] == "False" {
+				return false, "", errors.New("CustomResourceDefinition " + name + " names are not accepted: " + fmt.Sprint(condition["message"]))
+			}
+		}
+		if !established {
+			return false, "Waiting for CustomResourceDefinition " + name + " to be established ...", nil
+		}
+		group, _, _ := unstructured.NestedString(crd.Object, "spec", "group")
+		versions, _, _ := unstructured.NestedSlice(crd.Object, "spec", "versions")
+		for _, each := range versions {
+			version, ok := each.(map[string]interface{})
+			if !ok || version["served"] != true {
+				continue
+			}
+			if _, err := k.discoveryClient.ServerResourcesForGroupVersion(group + "/" + fmt.Sprint(version["name"])); err != nil {
+				return false, "Waiting for CustomResourceDefinition " + name + " to be discoverable ...", nil
+			}
+		}
+		return true, "CustomResourceDefinition " + name + " established", nil
+	})
+}
+
+// resourceInterfaceOf resolves dynamic client interface of the descriptor through discovery and sets its namespace.
+func (k k8sService) resourceInterfaceOf(resource v1.Resource, data *unstructured.Unstructured) (dynamic.ResourceInterface, error) {
+	groupVersionResource, namespaced, err := k.groupVersionResourceOf(data.GetAPIVersion(), data.GetKind())
+	if err != nil {
+		return nil, err
+	}
+	if data.GetKind() == "Namespace" && isProtectedNamespace(data.GetName()) {
+		return nil, fmt.Errorf("namespace %s is protected", data.GetName())
+	}
+	if !namespaced {
+		return k.dynamicClient.Resource(groupVersionResource), nil
+	}
+	namespace, err := k.resolveNamespace(resource, data)
+	if err != nil {
+		return nil, err
+	}
+	data.SetNamespace(namespace)
+	return k.dynamicClient.Resource(groupVersionResource).Namespace(namespace), nil
+}
+
+// resourceInterfaceFor returns dynamic client interface of the kind, scoped to namespace if the kind is namespaced.
+func (k k8sService) resourceInterfaceFor(apiVersion, kind, namespace string) (dynamic.ResourceInterface, error) {
+	groupVersionResource, namespaced, err := k.groupVersionResourceOf(apiVersion, kind)
+	if err != nil {
+		return nil, err
+	}
+	if namespaced {
+		return k.dynamicClient.Resource(groupVersionResource).Namespace(namespace), nil
+	}
+	return k.dynamicClient.Resource(groupVersionResource), nil
+}
+
+// groupVersionResourceOf resolves resource of the kind through discovery, reporting if it is namespaced.
+func (k k8sService) groupVersionResourceOf(apiVersion, kind string) (schema.GroupVersionResource, bool, error) {
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		gv = schema.GroupVersion{Version: apiVersion}
+	}
+	apiResourceList, err := k.discoveryClient.ServerResourcesForGroupVersion(apiVersion)
+	if err != nil {
+		return schema.GroupVersionResource{}, false, err
+	}
+	for _, apiResource := range apiResourceList.APIResources {
+		if apiResource.Kind == kind && !strings.Contains(apiResource.Name, "/") {
+			return schema.GroupVersionResource{Group: gv.Group, Version: gv.Version, Resource: apiResource.Name}, apiResource.Namespaced, nil
+		}
+	}
+	return schema.GroupVersionResource{}, false, fmt.Errorf("unknown resource kind: %s", kind)
+}
+
+// applyObject server-side applies the descriptor. In dry run, nothing is persisted and the object that would be persisted is returned.
+func (k k8sService) applyObject(resourceInterface dynamic.ResourceInterface, resource v1.Resource, data *unstructured.Unstructured, dryRun bool) (*unstructured.Unstructured, error) {
+	// managedFields must be empty for an apply request, server owns them.
+	data.SetManagedFields(nil)
+	body, err := data.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	force := resource.ForceConflicts
+	patchOptions := metaV1.PatchOptions{FieldManager: config.FieldManager, Force: &force}
+	if dryRun {
+		patchOptions.DryRun = []string{metaV1.DryRunAll}
+	}
+	out, err := resourceInterface.Patch(context.Background(), data.GetName(), types.ApplyPatchType, body, patchOptions)
+	if err != nil {
+		if k8sErrors.IsConflict(err) {
+			k.notifyManagedFieldConflicts(resource, data, err)
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+func (k k8sService) Diff(resource v1.Resource, data *unstructured.Unstructured) v1.ResourceDiff {
+	diff := v1.ResourceDiff{ApiVersion: data.GetAPIVersion(), Kind: data.GetKind(), Name: data.GetName(), Namespace: data.GetNamespace()}
+	resourceInterface, err := k.resourceInterfaceOf(resource, data)
+	diff.Namespace = data.GetNamespace()
+	if err != nil {
+		diff.Error = err.Error()
+		return diff
+	}
+	live, err := resourceInterface.Get(context.Background(), data.GetName(), metaV1.GetOptions{})
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		diff.Error = err.Error()
+		return diff
+	}
+	desired, err := k.applyObject(resourceInterface, resource, data, true)
+	if err != nil {
+		diff.Error = err.Error()
+		return diff
+	}
+	if live == nil || live.Object == nil {
+		diff.Operation = enums.OBJECT_CREATED
+		diff.Patch = withoutServerFields(desired).Object
+		return diff
+	}
+	patch, err := objectPatch(live, desired)
+	if err != nil {
+		diff.Error = err.Error()
+		return diff
+	}
+	setDiffPatch(&diff, patch)
+	return diff
+}
+
+func (k k8sService) DiffWorkload(resource v1.Resource) v1.ResourceDiff {
+	diff := v1.ResourceDiff{Name: resource.Name, Namespace: resource.Namespace}
+	subject := v1.Subject{Step: resource.Step, Name: resource.Name, Namespace: resource.Namespace, ProcessId: resource.ProcessId, Pipeline: resource.Pipeline}
+	subject.EventData = map[string]interface{}{"footmark": enums.DRY_RUN, "reason": "n/a", "status": enums.PROCESSING, "step": resource.Step, "process_id": resource.ProcessId, "company_id": resource.Pipeline.MetaData.CompanyId, "claim": strconv.Itoa(resource.Claim)}
+	var cur, mod, dataStruct interface{}
+	var dryRunPatch func(patch []byte) error
+	dryRunOptions := metaV1.PatchOptions{DryRun: []string{metaV1.DryRunAll}}
+	switch resource.Type {
+	case enums.DEPLOYMENT:
+		deployment, err := k.GetDeployment(resource.Name, resource.Namespace)
+		if err != nil {
+			diff.Error = err.Error()
+			return diff
+		}
+		modified := deployment.DeepCopy()
+		if err := k.setContainerImages(resource, &modified.Spec.Template.Spec, subject); err != nil {
+			diff.Error = err.Error()
+			return diff
+		}
+		modified.Labels = mergeLabels(modified.Labels, ciLabels(resource))
+		modified.Spec.Template.Labels = mergeLabels(modified.Spec.Template.Labels, ciLabels(resource))
+		if resource.Replica > 0 {
+			replicas, err := k.replicaDiff(resource, deployment.Spec.Replicas)
+			if err != nil {
+				diff.Error = err.Error()
+				return diff
+			}
+			modified.Spec.Replicas = &replicas.Target
+			diff.Replicas = replicas
+		}
+		diff.ApiVersion, diff.Kind = "apps/v1", "Deployment"
+		cur, mod, dataStruct = deployment, modified, appsV1.Deployment{}
+		dryRunPatch = func(patch []byte) error {
+			_, err := k.kcs.AppsV1().Deployments(resource.Namespace).Patch(context.Background(), resource.Name, types.StrategicMergePatchType, patch, dryRunOptions)
+			return err
+		}
+	case enums.STATEFULSET:
+		statefulSet, err := k.GetStatefulSet(resource.Name, resource.Namespace)
+		if err != nil {
+			diff.Error = err.Error()
+			return diff
+		}
+		modified := statefulSet.DeepCopy()
+		if err := k.setContainerImages(resource, &modified.Spec.Template.Spec, subject); err != nil {
+			diff.Error = err.Error()
+			return diff
+		}
+		modified.Labels = mergeLabels(modified.Labels, ciLabels(resource))
+		modified.Spec.Template.Labels = mergeLabels(modified.Spec.Template.Labels, ciLabels(resource))
+		if resource.Replica > 0 {
+			replicas, err := k.replicaDiff(resource, statefulSet.Spec.Replicas)
+			if err != nil {
+				diff.Error = err.Error()
+				return diff
+			}
+			modified.Spec.Replicas = &replicas.Target
+			diff.Replicas = replicas
+		}
+		diff.ApiVersion, diff.Kind = "apps/v1", "StatefulSet"
+		cur, mod, dataStruct = statefulSet, modified, appsV1.StatefulSet{}
+		dryRunPatch = func(patch []byte) error {
+			_, err := k.kcs.AppsV1().StatefulSets(resource.Namespace).Patch(context.Background(), resource.Name, types.StrategicMergePatchType, patch, dryRunOptions)
+			return err
+		}
+	case enums.DAEMONSET:
+		daemonSet, err := k.GetDaemonSet(resource.Name, resource.Namespace)
+		if err != nil {
+			diff.Error = err.Error()
+			return diff
+		}
+		modified := daemonSet.DeepCopy()
+		if err := k.setContainerImages(resource, &modified.Spec.Template.Spec, subject); err != nil {
+			diff.Error = err.Error()
+			return diff
+		}
+		modified.Labels = mergeLabels(modified.Labels, ciLabels(resource))
+		modified.Spec.Template.Labels = mergeLabels(modified.Spec.Template.Labels, ciLabels(resource))
+		diff.ApiVersion, diff.Kind = "apps/v1", "DaemonSet"
+		cur, mod, dataStruct = daemonSet, modified, appsV1.DaemonSet{}
+		dryRunPatch = func(patch []byte) error {
+			_, err := k.kcs.AppsV1().DaemonSets(resource.Namespace).Patch(context.Background(), resource.Name, types.StrategicMergePatchType, patch, dryRunOptions)
+			return err
+		}
+	default:
+		diff.Error = fmt.Sprintf("dry run is not supported for resource type: %s", resource.Type)
+		return diff
+	}
+	patch, err := createTwoWayMergePatch(cur, mod, dataStruct)
+	if err != nil {
+		diff.Error = err.Error()
+		return diff
+	}
+	if len(patch) != 0 && string(patch) != "{}" {
+		if err := dryRunPatch(patch); err != nil {
+			diff.Error = err.Error()
+			return diff
+		}
+	}
+	setDiffPatch(&diff, patch)
+	return diff
+}
+
+// createTwoWayMergePatch returns strategic merge patch that turns cur into mod.
+func createTwoWayMergePatch(cur, mod, dataStruct interface{}) ([]byte, error) {
+	curJson, err := json.Marshal(cur)
+	if err != nil {
+		return nil, err
+	}
+	modJson, err := json.Marshal(mod)
+	if err != nil {
+		return nil, err
+	}
+	return strategicpatch.CreateTwoWayMergePatch(curJson, modJson, dataStruct)
+}
+
+// objectPatch returns patch that turns live object into desired one. Strategic merge patch is used for built-in kinds,
+// json merge patch for custom resources.
+func objectPatch(live, desired *unstructured.Unstructured) ([]byte, error) {
+	live = withoutServerFields(live)
+	desired = withoutServerFields(desired)
+	typed, err := scheme.Scheme.New(live.GroupVersionKind())
+	if err == nil {
+		return createTwoWayMergePatch(live.Object, desired.Object, typed)
+	}
+	return json.Marshal(jsonMergePatch(live.Object, desired.Object))
+}
+
+// jsonMergePatch returns RFC 7386 merge patch that turns cur into mod.
+func jsonMergePatch(cur, mod map[string]interface{}) map[string]interface{} {
+	patch := make(map[string]interface{})
+	for key, modValue := range mod {
+		curValue, ok := cur[key]
+		if !ok {
+			patch[key] = modValue
+			continue
+		}
+		curMap, curIsMap := curValue.(map[string]interface{})
+		modMap, modIsMap := modValue.(map[string]interface{})
+		if curIsMap && modIsMap {
+			if nested := jsonMergePatch(curMap, modMap); len(nested) > 0 {
+				patch[key] = nested
+			}
+		} else if !equality.Semantic.DeepEqual(curValue, modValue) {
+			patch[key] = modValue
+		}
+	}
+	for key := range cur {
+		if _, ok := mod[key]; !ok {
+			patch[key] = nil
+		}
+	}
+	return patch
+}
+
+// withoutServerFields returns copy of the object without fields that server changes on every write.
+func withoutServerFields(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	obj = obj.DeepCopy()
+	unstructured.RemoveNestedField(obj.Object, "metadata", "managedFields")
+	unstructured.RemoveNestedField(obj.Object, "metadata", "resourceVersion")
+	unstructured.RemoveNestedField(obj.Object, "metadata", "generation")
+	unstructured.RemoveNestedField(obj.Object, "metadata", "uid")
+	unstructured.RemoveNestedField(obj.Object, "metadata", "creationTimestamp")
+	unstructured.RemoveNestedField(obj.Object, "status")
+	return obj
+}
+
+func setDiffPatch(diff *v1.ResourceDiff, patch []byte) {
+	if len(patch) == 0 || string(patch) == "{}" {
+		diff.Operation = enums.OBJECT_UNCHANGED
+		return
+	}
+	diff.Operation = enums.OBJECT_UPDATED
+	diff.Patch = make(map[string]interface{})
+	if err := json.Unmarshal(patch, &diff.Patch); err != nil {
+		diff.Error = err.Error()
+	}
+}
+
+// ciLabels returns labels the agent puts on every object it applies.
+func ciLabels(resource v1.Resource) map[string]string {
+	return map[string]string{"company": resource.Pipeline.MetaData.CompanyId, "klovercloud_ci": "enabled", "process_id": resource.ProcessId, "claim": strconv.Itoa(resource.Claim)}
+}
+
+// mergeLabels adds labels to existing ones, overriding same keys.
+func mergeLabels(existing, labels map[string]string) map[string]string {
+	if existing == nil {
+		existing = make(map[string]string)
+	}
+	for key, value := range labels {
+		existing[key] = value
+	}
+	return existing
+}
+
+// resolveNamespace returns namespace of a namespaced descriptor. Descriptor namespace gets priority, then resource namespace, then agents default namespace.
+func (k k8sService) resolveNamespace(resource v1.Resource, data *unstructured.Unstructured) (string, error) {
+	namespace := data.GetNamespace()
+	if namespace == "" {
+		namespace = resource.Namespace
+	}
+	if namespace == "" {
+		namespace = config.DefaultNamespace
+	}
+	if isProtectedNamespace(namespace) {
+		return "", fmt.Errorf("namespace %s is protected, refusing to apply %s/%s", namespace, data.GetKind(), data.GetName())
+	}
+	if config.AutoCreateNamespace && !resource.DryRun {
+		if err := k.createNamespaceIfNotExists(namespace); err != nil {
+			return "", err
+		}
+	}
+	return namespace, nil
+}
+
+// createNamespaceIfNotExists creates namespace labelled for klovercloud ci if it is missing.
+func (k k8sService) createNamespaceIfNotExists(namespace string) error {
+	_, err := k.kcs.CoreV1().Namespaces().Get(context.Background(), namespace, metaV1.GetOptions{})
+	if err == nil {
+		return nil
+	}
+	if !k8sErrors.IsNotFound(err) {
+		return err
+	}
+	ns := &coreV1.Namespace{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:   namespace,
+			Labels: map[string]string{"klovercloud_ci": "enabled"},
 		},
-	)
-}
-
-func (k k8sService) ListenPVEvents() (cache.Store, cache.Controller) {
-	watchlist := cache.NewListWatchFromClient(k.kcs.CoreV1().RESTClient(), "persistentVolumes", "", fields.Everything())
-	extrasMap := map[string]string{"type": "persistentVolume", "agent": config.AgentName, "object": string(enums.PERSISTENT_VOLUME)}
-	return cache.NewInformer(
-		watchlist,
-		&coreV1.PersistentVolume{},
-		time.Second*0,
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				persistentVolume := obj.(*coreV1.PersistentVolume)
-				if _, ok := persistentVolume.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: persistentVolume,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.ADD,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("add PV: ", persistentVolume.Name)
-				}
-			},
-			DeleteFunc: func(obj interface{}) {
-				persistentVolume := obj.(*coreV1.PersistentVolume)
-				if _, ok := persistentVolume.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: persistentVolume,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.DELETE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("delete PV: ", persistentVolume.Name)
-				}
-			},
-			UpdateFunc: func(oldObj, newObj interface{}) {
-				oldK8sObj := oldObj.(*coreV1.PersistentVolume)
-				newK8sObj := newObj.(*coreV1.PersistentVolume)
-				obj := KubeObject{
-					OldK8sObj: oldK8sObj,
-					NewK8sObj: newK8sObj,
-				}
-				if _, ok := newK8sObj.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: obj,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.UPDATE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("old PV:", oldK8sObj.Name, ", new PV:", newK8sObj.Name)
-				}
-			},
-		},
-	)
-}
-
-func (k k8sService) ListenDaemonSetEvents() (cache.Store, cache.Controller) {
-	watchlist := cache.NewListWatchFromClient(k.kcs.AppsV1().RESTClient(), "daemonSets", "", fields.Everything())
-	extrasMap := map[string]string{"type": "daemonSet", "agent": config.AgentName, "object": string(enums.DAEMONSET)}
-	return cache.NewInformer(
-		watchlist,
-		&appsV1.DaemonSet{},
-		time.Second*0,
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				daemonSet := obj.(*appsV1.DaemonSet)
-				if _, ok := daemonSet.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: daemonSet,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.ADD,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("add DaemonSet: ", daemonSet.Name)
-				}
-			},
-			DeleteFunc: func(obj interface{}) {
-				daemonSet := obj.(*appsV1.DaemonSet)
-				if _, ok := daemonSet.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: daemonSet,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.DELETE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("delete DaemonSet: ", daemonSet.Name)
-				}
-			},
-			UpdateFunc: func(oldObj, newObj interface{}) {
-				oldK8sObj := oldObj.(*appsV1.DaemonSet)
-				newK8sObj := newObj.(*appsV1.DaemonSet)
-				obj := KubeObject{
-					OldK8sObj: oldK8sObj,
-					NewK8sObj: newK8sObj,
-				}
-				if _, ok := newK8sObj.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: obj,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.UPDATE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("old DaemonSet:", oldK8sObj.Name, ", new DaemonSet:", newK8sObj.Name)
-				}
-			},
-		},
-	)
-}
-
-func (k k8sService) ListenReplicaSetEvents() (cache.Store, cache.Controller) {
-	watchlist := cache.NewListWatchFromClient(k.kcs.AppsV1().RESTClient(), "replicaSets", "", fields.Everything())
-	extrasMap := map[string]string{"type": "replicaSet", "agent": config.AgentName, "object": string(enums.REPLICASET)}
-	return cache.NewInformer(
-		watchlist,
-		&appsV1.ReplicaSet{},
-		time.Second*0,
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				replicaSet := obj.(*appsV1.ReplicaSet)
-				if _, ok := replicaSet.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: replicaSet,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.ADD,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("add ReplicaSet: ", replicaSet.Name)
-				}
-			},
-			DeleteFunc: func(obj interface{}) {
-				replicaSet := obj.(*appsV1.ReplicaSet)
-				if _, ok := replicaSet.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: replicaSet,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.DELETE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("delete ReplicaSet: ", replicaSet.Name)
-				}
-			},
-			UpdateFunc: func(oldObj, newObj interface{}) {
-				oldK8sObj := oldObj.(*appsV1.ReplicaSet)
-				newK8sObj := newObj.(*appsV1.ReplicaSet)
-				obj := KubeObject{
-					OldK8sObj: oldK8sObj,
-					NewK8sObj: newK8sObj,
-				}
-				if _, ok := newK8sObj.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: obj,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.UPDATE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("old ReplicaSet:", oldK8sObj.Name, ", new ReplicaSet:", newK8sObj.Name)
-				}
-			},
-		},
-	)
-}
-
-func (k k8sService) ListenStateFullSetSetEvents() (cache.Store, cache.Controller) {
-	watchlist := cache.NewListWatchFromClient(k.kcs.AppsV1().RESTClient(), "statefulSets", "", fields.Everything())
-	extrasMap := map[string]string{"type": "statefulSet", "agent": config.AgentName, "object": string(enums.STATEFULSET)}
-	return cache.NewInformer(
-		watchlist,
-		&appsV1.StatefulSet{},
-		time.Second*0,
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				statefulSet := obj.(*appsV1.StatefulSet)
-				if _, ok := statefulSet.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: statefulSet,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.ADD,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("add StatefulSet: ", statefulSet.Name)
-				}
-			},
-			DeleteFunc: func(obj interface{}) {
-				statefulSet := obj.(*appsV1.StatefulSet)
-				if _, ok := statefulSet.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: statefulSet,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.DELETE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("delete StatefulSet: ", statefulSet.Name)
-				}
-			},
-			UpdateFunc: func(oldObj, newObj interface{}) {
-				oldK8sObj := oldObj.(*appsV1.StatefulSet)
-				newK8sObj := newObj.(*appsV1.StatefulSet)
-				obj := KubeObject{
-					OldK8sObj: oldK8sObj,
-					NewK8sObj: newK8sObj,
-				}
-				if _, ok := newK8sObj.Labels["klovercloud_ci"]; ok {
-					k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-						Body: obj,
-						Header: v1.MessageHeader{
-							Offset:  0,
-							Command: enums.UPDATE,
-							Extras:  extrasMap,
-						},
-					})
-					log.Println("old StatefulSet:", oldK8sObj.Name, ", new StatefulSet:", newK8sObj.Name)
-				}
-			},
-		},
-	)
-}
-
-func (k k8sService) ListenKubeEvents() (cache.Store, cache.Controller) {
-	watchlist := cache.NewListWatchFromClient(k.kcs.CoreV1().RESTClient(), "events", "",
-		fields.Everything())
-	extrasMap := map[string]string{"agent": config.AgentName, "object": string(enums.EVENT)}
-	return cache.NewInformer(
-		watchlist,
-		&coreV1.Event{},
-		time.Second*0,
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				eventObj := obj.(*coreV1.Event)
-				if eventObj.InvolvedObject.Kind == "Pod" {
-					pod, err := k.kcs.CoreV1().Pods(eventObj.InvolvedObject.Namespace).Get(context.TODO(), eventObj.InvolvedObject.Name, metaV1.GetOptions{})
-					if err != nil {
-						log.Println(err.Error())
-					} else {
-						if _, ok := pod.Labels["klovercloud_ci"]; ok {
-							k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-								Body: eventObj,
-								Header: v1.MessageHeader{
-									Offset:  0,
-									Command: enums.ADD,
-									Extras:  extrasMap,
-								},
-							})
-							log.Println("action:", "add", "type", eventObj.Type, "kind:", eventObj.InvolvedObject.Kind, " reason:", eventObj.Reason, " age:", eventObj.CreationTimestamp, " from:", eventObj.InvolvedObject.Name, "", "msg:", eventObj.Message)
-						}
-					}
-				}
+	}
+	_, err = k.kcs.CoreV1().Namespaces().Create(context.Background(), ns, metaV1.CreateOptions{FieldManager: config.FieldManager})
+	if err != nil && !k8sErrors.IsAlreadyExists(err) {
+		return err
+	}
+	log.Println("created namespace:", namespace)
+	return nil
+}
+
+func isProtectedNamespace(namespace string) bool {
+	for _, each := range config.ProtectedNamespaces {
+		if each == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyManagedFieldConflicts reports server-side apply conflicts to the observers.
+func (k k8sService) notifyManagedFieldConflicts(resource v1.Resource, data *unstructured.Unstructured, err error) {
+	status, ok := err.(k8sErrors.APIStatus)
+	if !ok || status.Status().Details == nil {
+		return
+	}
+	var conflicts []map[string]string
+	for _, cause := range status.Status().Details.Causes {
+		if cause.Type != metaV1.CauseTypeFieldManagerConflict {
+			continue
+		}
+		conflicts = append(conflicts, map[string]string{"field": cause.Field, "message": cause.Message})
+	}
+	if len(conflicts) == 0 {
+		return
+	}
+	subject := v1.Subject{Step: resource.Step, Name: resource.Name, Namespace: resource.Namespace, ProcessId: resource.ProcessId, Pipeline: resource.Pipeline}
+	subject.Log = "[WARNING]Server-side apply conflict for " + data.GetKind() + "/" + data.GetName() + ", " + strconv.Itoa(len(conflicts)) + " field(s) owned by other managers. Set force_conflicts to take ownership."
+	subject.EventData = make(map[string]interface{})
+	subject.EventData["log"] = subject.Log
+	subject.EventData["reason"] = "ManagedFieldsConflict"
+	subject.EventData["conflicts"] = conflicts
+	subject.EventData["object"] = map[string]string{"kind": data.GetKind(), "name": data.GetName(), "namespace": data.GetNamespace()}
+	subject.EventData["field_manager"] = config.FieldManager
+	subject.EventData["footmark"] = enums.UPDATE_RESOURCE
+	subject.EventData["status"] = enums.PROCESSING
+	subject.EventData["step"] = resource.Step
+	subject.EventData["process_id"] = resource.ProcessId
+	subject.EventData["company_id"] = resource.Pipeline.MetaData.CompanyId
+	subject.EventData["claim"] = strconv.Itoa(resource.Claim)
+	k.notifyAll(subject)
+}
+
+func (k k8sService) UpdateDeployment(resource v1.Resource) error {
+	subject := v1.Subject{Step: resource.Step, Log: "Initiating  Deployment ...", Name: resource.Name, Namespace: resource.Namespace, ProcessId: resource.ProcessId, Pipeline: resource.Pipeline}
+	subject.EventData = make(map[string]interface{})
+	subject.EventData["footmark"] = enums.UPDATE_RESOURCE
+	subject.EventData["log"] = subject.Log
+	subject.EventData["reason"] = "n/a"
+	subject.EventData["status"] = enums.INITIALIZING
+	subject.EventData["step"] = resource.Step
+	subject.EventData["process_id"] = resource.ProcessId
+	subject.EventData["company_id"] = resource.Pipeline.MetaData.CompanyId
+	subject.EventData["claim"] = strconv.Itoa(resource.Claim)
+	k.notifyAll(subject)
+	// snapshot is the pre-job state rollback restores, so it is taken before anything is changed.
+	snapshot, err := k.GetDeployment(resource.Name, resource.Namespace)
+	if err != nil {
+		subject.Log = "Failed to get latest version of Deployment: " + err.Error()
+		subject.EventData["log"] = subject.Log
+		subject.EventData["footmark"] = enums.POST_AGENT_JOB
+		subject.EventData["status"] = enums.DEPLOYMENT_FAILED
+		k.notifyAll(subject)
+		return err
+	}
+	if resource.Replica > 0 {
+		subject.EventData["status"] = enums.PROCESSING
+		if err := k.applyReplicas(resource, subject); err != nil {
+			return err
+		}
+	}
+	failedSelector := ""
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		subject.Log = "Applying Deployment ..."
+		subject.EventData["log"] = subject.Log
+		subject.EventData["footmark"] = enums.UPDATE_RESOURCE
+		subject.EventData["status"] = enums.PROCESSING
+		k.notifyAll(subject)
+		result, getErr := k.GetDeployment(resource.Name, resource.Namespace)
+		if getErr != nil {
+			log.Println("Failed to get latest version of Deployment: ", getErr)
+			subject.Log = "Failed to get latest version of Deployment: " + getErr.Error()
+			subject.EventData["log"] = subject.Log
+			subject.EventData["footmark"] = enums.POST_AGENT_JOB
+			subject.EventData["status"] = enums.DEPLOYMENT_FAILED
+			k.notifyAll(subject)
+			return getErr
+		}
+		current := result.DeepCopy()
+		if err := k.setContainerImages(resource, &result.Spec.Template.Spec, subject); err != nil {
+			subject.Log = err.Error()
+			return err
+		}
+		if result.Labels == nil {
+			result.Labels = make(map[string]string)
+		}
+		result.Labels["company"] = resource.Pipeline.MetaData.CompanyId
+		result.Labels["klovercloud_ci"] = "enabled"
+		result.Labels["process_id"] = resource.ProcessId
+		result.Labels["claim"] = strconv.Itoa(resource.Claim)
+		result.Spec.Template.Labels["klovercloud_ci"] = "enabled"
+		result.Spec.Template.Labels["claim"] = strconv.Itoa(resource.Claim)
+		result.Spec.Template.Labels["process_id"] = resource.ProcessId
+		result.Spec.Template.Labels["company"] = resource.Pipeline.MetaData.CompanyId
+		deploy, updateErr := k.PatchDeploymentObject(resource.RolloutRestart, current, result)
+		if updateErr != nil {
+			subject.Log = updateErr.Error()
+			return updateErr
+		}
+		failedSelector = labels.FormatLabels(deploy.Spec.Template.Labels)
+		return nil
+	})
+	if err == nil {
+		subject.Log = "Waiting for deployment rollout ..."
+		subject.EventData["log"] = subject.Log
+		subject.EventData["footmark"] = enums.POST_AGENT_JOB
+		k.notifyAll(subject)
+		err = k.WaitForDeploymentRollout(resource, failedSelector)
+		if err == nil {
+			err = k.verify(resource, failedSelector)
+		}
+	}
+	if err != nil {
+		if resource.RollbackPolicy != nil && resource.RollbackPolicy.Enabled && failedSelector != "" {
+			return k.rollbackDeployment(resource, snapshot, err)
+		}
+		return err
+	}
+
+	subject.Log = "Updated Successfully"
+	subject.EventData["log"] = subject.Log
+	subject.EventData["footmark"] = enums.POST_AGENT_JOB
+	subject.EventData["status"] = enums.SUCCESSFUL
+	k.notifyAll(subject)
+	return nil
+}
+
+// rollbackDeployment restores deployment spec from snapshot taken before the failed update and waits for the restored rollout.
+func (k k8sService) rollbackDeployment(resource v1.Resource, snapshot *appsV1.Deployment, cause error) error {
+	subject := newRollbackSubject(resource, "Rolling back deployment "+resource.Name+" to previous revision. Reason: "+cause.Error())
+	k.notifyAll(subject)
+	var restored *appsV1.Deployment
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current, err := k.GetDeployment(resource.Name, resource.Namespace)
+		if err != nil {
+			return err
+		}
+		mod := current.DeepCopy()
+		mod.Labels = snapshot.Labels
+		mod.Spec = *snapshot.Spec.DeepCopy()
+		restored, err = k.PatchDeploymentObject(false, current, mod)
+		return err
+	})
+	if err == nil {
+		err = k.waitForRollout(resource, labels.FormatLabels(restored.Spec.Template.Labels), rollbackTimeout(resource), func() (bool, string, error) {
+			deployment, err := k.GetDeployment(resource.Name, resource.Namespace)
+			if err != nil {
+				return false, "", err
+			}
+			return deploymentRolloutStatus(deployment)
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("%s, rollback failed: %s", cause.Error(), err.Error())
+	}
+	subject.Log = "Deployment " + resource.Name + " rolled back to previous revision"
+	subject.EventData["log"] = subject.Log
+	k.notifyAll(subject)
+	return rolledBackError{err: cause}
+}
+
+// rollbackStatefulSet restores statefulSet spec from snapshot taken before the failed update and waits for the restored rollout.
+// Pods created from the failed revision are deleted as statefulSet controller does not replace pods that never became ready.
+func (k k8sService) rollbackStatefulSet(resource v1.Resource, snapshot *appsV1.StatefulSet, failedSelector string, cause error) error {
+	subject := newRollbackSubject(resource, "Rolling back statefulSet "+resource.Name+" to previous revision. Reason: "+cause.Error())
+	k.notifyAll(subject)
+	var restored *appsV1.StatefulSet
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current, err := k.GetStatefulSet(resource.Name, resource.Namespace)
+		if err != nil {
+			return err
+		}
+		mod := current.DeepCopy()
+		mod.Labels = snapshot.Labels
+		mod.Spec = *snapshot.Spec.DeepCopy()
+		restored, err = k.PatchStatefulSetObject(false, current, mod)
+		return err
+	})
+	if err == nil {
+		err = k.kcs.CoreV1().Pods(resource.Namespace).DeleteCollection(context.Background(), metaV1.DeleteOptions{}, metaV1.ListOptions{LabelSelector: failedSelector})
+	}
+	if err == nil {
+		err = k.waitForRollout(resource, labels.FormatLabels(restored.Spec.Template.Labels), rollbackTimeout(resource), func() (bool, string, error) {
+			statefulSet, err := k.GetStatefulSet(resource.Name, resource.Namespace)
+			if err != nil {
+				return false, "", err
+			}
+			return statefulSetRolloutStatus(statefulSet)
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("%s, rollback failed: %s", cause.Error(), err.Error())
+	}
+	subject.Log = "StatefulSet " + resource.Name + " rolled back to previous revision"
+	subject.EventData["log"] = subject.Log
+	k.notifyAll(subject)
+	return rolledBackError{err: cause}
+}
+
+func newRollbackSubject(resource v1.Resource, message string) v1.Subject {
+	subject := v1.Subject{Step: resource.Step, Log: message, Name: resource.Name, Namespace: resource.Namespace, ProcessId: resource.ProcessId, Pipeline: resource.Pipeline}
+	subject.EventData = make(map[string]interface{})
+	subject.EventData["footmark"] = enums.ROLLBACK_RESOURCE
+	subject.EventData["log"] = message
+	subject.EventData["reason"] = "n/a"
+	subject.EventData["status"] = enums.PROCESSING
+	subject.EventData["step"] = resource.Step
+	subject.EventData["process_id"] = resource.ProcessId
+	subject.EventData["company_id"] = resource.Pipeline.MetaData.CompanyId
+	subject.EventData["claim"] = strconv.Itoa(resource.Claim)
+	return subject
+}
+
+func rollbackTimeout(resource v1.Resource) int64 {
+	if resource.RollbackPolicy != nil && resource.RollbackPolicy.Timeout > 0 {
+		return resource.RollbackPolicy.Timeout
+	}
+	return config.RolloutTimeout
+}
+
+// rolledBackError wraps the error that caused a workload to be rolled back.
+type rolledBackError struct {
+	err error
+}
+
+func (r rolledBackError) Error() string {
+	return r.err.Error()
+}
+
+func (r rolledBackError) Unwrap() error {
+	return r.err
+}
+
+func (k k8sService) PatchDeploymentObject(rolloutRestart bool, cur, mod *appsV1.Deployment) (*appsV1.Deployment, error) {
+	if rolloutRestart {
+		if mod.Spec.Template.ObjectMeta.Annotations == nil {
+			mod.Spec.Template.ObjectMeta.Annotations = make(map[string]string)
+		}
+		mod.Spec.Template.ObjectMeta.Annotations["kubectl.kubernetes.io/restartedAt"] = time.Now().Format(time.RFC3339)
+		mod.Annotations["kubectl.kubernetes.io/restartedAt"] = time.Now().Format(time.RFC3339)
+	}
+	patch, err := createTwoWayMergePatch(cur, mod, appsV1.Deployment{})
+	if err != nil {
+		return nil, err
+	}
+	if len(patch) == 0 || string(patch) == "{}" {
+		return cur, nil
+	}
+	out, err := k.kcs.AppsV1().Deployments(cur.Namespace).Patch(context.TODO(), cur.Name, types.StrategicMergePatchType, patch, metaV1.PatchOptions{})
+	return out, err
+}
+
+func (k k8sService) PatchStatefulSetObject(rolloutRestart bool, cur, mod *appsV1.StatefulSet) (*appsV1.StatefulSet, error) {
+	if rolloutRestart {
+		if mod.Spec.Template.ObjectMeta.Annotations == nil {
+			mod.Spec.Template.ObjectMeta.Annotations = make(map[string]string)
+		}
+		mod.Spec.Template.ObjectMeta.Annotations["kubectl.kubernetes.io/restartedAt"] = time.Now().Format(time.RFC3339)
+		mod.Annotations["kubectl.kubernetes.io/restartedAt"] = time.Now().Format(time.RFC3339)
+	}
+	patch, err := createTwoWayMergePatch(cur, mod, appsV1.StatefulSet{})
+	if err != nil {
+		return nil, err
+	}
+	if len(patch) == 0 || string(patch) == "{}" {
+		return cur, nil
+	}
+	out, err := k.kcs.AppsV1().StatefulSets(cur.Namespace).Patch(context.TODO(), cur.Name, types.StrategicMergePatchType, patch, metaV1.PatchOptions{})
+	return out, err
+}
+
+func (k k8sService) UpdatePod(resource v1.Resource) error {
+	subject := v1.Subject{Step: resource.Step, Log: "Initiating  Pod ...", Name: resource.Name, Namespace: resource.Namespace, ProcessId: resource.ProcessId, Pipeline: resource.Pipeline}
+	subject.EventData = make(map[string]interface{})
+	subject.EventData["footmark"] = enums.UPDATE_RESOURCE
+	subject.EventData["log"] = subject.Log
+	subject.EventData["reason"] = "n/a"
+	subject.EventData["status"] = enums.INITIALIZING
+	subject.EventData["step"] = resource.Step
+	subject.EventData["process_id"] = resource.ProcessId
+	subject.EventData["company_id"] = resource.Pipeline.MetaData.CompanyId
+	subject.EventData["claim"] = strconv.Itoa(resource.Claim)
+	k.notifyAll(subject)
+	var pod *coreV1.Pod
+	var updatedAt time.Time
+	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		subject.Log = "Applying Pod ..."
+		subject.EventData["log"] = subject.Log
+		subject.EventData["footmark"] = enums.UPDATE_RESOURCE
+		subject.EventData["status"] = enums.PROCESSING
+		k.notifyAll(subject)
+		result, getErr := k.GetPod(resource.Name, resource.Namespace)
+		if getErr != nil {
+			log.Println("Failed to get latest version of Pod: ", getErr)
+			subject.Log = "Failed to get latest version of Pod: " + getErr.Error()
+			subject.EventData["log"] = subject.Log
+			subject.EventData["footmark"] = enums.POST_AGENT_JOB
+			subject.EventData["status"] = enums.DEPLOYMENT_FAILED
+			k.notifyAll(subject)
+			return getErr
+		}
+		if err := k.setContainerImages(resource, &result.Spec, subject); err != nil {
+			subject.Log = err.Error()
+			return err
+		}
+		if result.Labels == nil {
+			result.Labels = make(map[string]string)
+		}
+		result.Labels["company"] = resource.Pipeline.MetaData.CompanyId
+		result.Labels["klovercloud_ci"] = "enabled"
+		result.Labels["process_id"] = resource.ProcessId
+		result.Labels["claim"] = strconv.Itoa(resource.Claim)
+		updatedAt = time.Now().Truncate(time.Second)
+		if resource.RolloutRestart {
+			var err error
+			pod, err = k.recreatePod(resource, result, subject)
+			return err
+		}
+		var updateErr error
+		pod, updateErr = k.kcs.CoreV1().Pods(resource.Namespace).Update(context.TODO(), result, metaV1.UpdateOptions{})
+		if updateErr != nil && k8sErrors.IsInvalid(updateErr) {
+			subject.Log = "Pod has immutable field changes, recreating pod ..."
+			subject.EventData["log"] = subject.Log
+			k.notifyAll(subject)
+			pod, updateErr = k.recreatePod(resource, result, subject)
+		}
+		return updateErr
+	})
+	if retryErr == nil {
+		subject.Log = "Waiting until pod is ready ..."
+		subject.EventData["log"] = subject.Log
+		subject.EventData["footmark"] = enums.POST_AGENT_JOB
+		k.notifyAll(subject)
+		retryErr = k.waitForRollout(resource, "", config.RolloutTimeout, func() (bool, string, error) {
+			current, err := k.GetPod(pod.Name, pod.Namespace)
+			if err != nil {
+				return false, "", err
+			}
+			return podRolloutStatus(current, updatedAt)
+		})
+	}
+	if retryErr == nil {
+		retryErr = k.verify(resource, labels.FormatLabels(pod.Labels))
+	}
+	if retryErr != nil {
+		log.Println("Update failed:", retryErr)
+		return retryErr
+	}
+	subject.Log = "Updated Successfully"
+	subject.EventData["log"] = subject.Log
+	subject.EventData["footmark"] = enums.POST_AGENT_JOB
+	subject.EventData["status"] = enums.SUCCESSFUL
+	k.notifyAll(subject)
+	return nil
+}
+
+// recreatePod deletes the pod, waits until it is gone and creates it again from the modified object.
+func (k k8sService) recreatePod(resource v1.Resource, mod *coreV1.Pod, subject v1.Subject) (*coreV1.Pod, error) {
+	err := k.kcs.CoreV1().Pods(mod.Namespace).Delete(context.Background(), mod.Name, metaV1.DeleteOptions{})
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		return nil, err
+	}
+	subject.Log = "Waiting for pod " + mod.Name + " to terminate ..."
+	subject.EventData["log"] = subject.Log
+	k.notifyAll(subject)
+	err = k.waitForRollout(resource, "", config.RolloutTimeout, func() (bool, string, error) {
+		_, err := k.GetPod(mod.Name, mod.Namespace)
+		if k8sErrors.IsNotFound(err) {
+			return true, "Pod " + mod.Name + " terminated", nil
+		}
+		return false, "Waiting for pod " + mod.Name + " to terminate ...", err
+	})
+	if err != nil {
+		return nil, err
+	}
+	pod := mod.DeepCopy()
+	pod.ResourceVersion = ""
+	pod.UID = ""
+	pod.CreationTimestamp = metaV1.Time{}
+	pod.DeletionTimestamp = nil
+	pod.ManagedFields = nil
+	pod.Spec.NodeName = ""
+	pod.Spec.EphemeralContainers = nil
+	pod.Status = coreV1.PodStatus{}
+	return k.kcs.CoreV1().Pods(mod.Namespace).Create(context.Background(), pod, metaV1.CreateOptions{FieldManager: config.FieldManager})
+}
+
+// isSameImage compares image of a container spec with the image reported in container status.
+func isSameImage(specImage, statusImage string) bool {
+	normalize := func(image string) string {
+		image = strings.TrimPrefix(image, "docker.io/")
+		image = strings.TrimPrefix(image, "library/")
+		if !strings.Contains(image[strings.LastIndex(image, "/")+1:], ":") && !strings.Contains(image, "@") {
+			image = image + ":latest"
+		}
+		return image
+	}
+	return normalize(specImage) == normalize(statusImage)
+}
+
+// podRolloutStatus returns readiness of the pod. Containers started before updatedAt are considered outdated unless they already run the spec image.
+func podRolloutStatus(pod *coreV1.Pod, updatedAt time.Time) (bool, string, error) {
+	if err := checkPodHealth(pod); err != nil {
+		return false, "", err
+	}
+	if pod.Status.Phase == coreV1.PodSucceeded {
+		return true, "Pod " + pod.Name + " completed", nil
+	}
+	specImages := make(map[string]string)
+	for _, each := range pod.Spec.Containers {
+		specImages[each.Name] = each.Image
+	}
+	ready := 0
+	for _, each := range pod.Status.ContainerStatuses {
+		if !each.Ready || each.State.Running == nil {
+			continue
+		}
+		if !each.State.Running.StartedAt.Time.Before(updatedAt) || isSameImage(specImages[each.Name], each.Image) {
+			ready++
+		}
+	}
+	if pod.Status.Phase == coreV1.PodRunning && ready == len(pod.Spec.Containers) {
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == coreV1.PodReady && condition.Status == coreV1.ConditionTrue {
+				return true, "Pod " + pod.Name + " is ready", nil
+			}
+		}
+	}
+	return false, fmt.Sprintf("Waiting for pod %s: %d of %d updated containers are ready ...", pod.Name, ready, len(pod.Spec.Containers)), nil
+}
+
+func (k k8sService) UpdateStatefulSet(resource v1.Resource) error {
+	subject := v1.Subject{Step: resource.Step, Log: "Initiating  Deployment ...", Name: resource.Name, Namespace: resource.Namespace, ProcessId: resource.ProcessId, Pipeline: resource.Pipeline}
+	subject.EventData = make(map[string]interface{})
+	subject.EventData["footmark"] = enums.UPDATE_RESOURCE
+	subject.EventData["log"] = subject.Log
+	subject.EventData["reason"] = "n/a"
+	subject.EventData["status"] = enums.INITIALIZING
+	subject.EventData["step"] = resource.Step
+	subject.EventData["process_id"] = resource.ProcessId
+	subject.EventData["company_id"] = resource.Pipeline.MetaData.CompanyId
+	subject.EventData["claim"] = strconv.Itoa(resource.Claim)
+	k.notifyAll(subject)
+	// snapshot is the pre-job state rollback restores, so it is taken before anything is changed.
+	snapshot, err := k.GetStatefulSet(resource.Name, resource.Namespace)
+	if err != nil {
+		subject.Log = "Failed to get latest version of StatefulSet: " + err.Error()
+		subject.EventData["log"] = subject.Log
+		subject.EventData["footmark"] = enums.POST_AGENT_JOB
+		subject.EventData["status"] = enums.DEPLOYMENT_FAILED
+		k.notifyAll(subject)
+		return err
+	}
+	if resource.Replica > 0 {
+		subject.EventData["status"] = enums.PROCESSING
+		if err := k.applyReplicas(resource, subject); err != nil {
+			return err
+		}
+	}
+	failedSelector := ""
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		subject.Log = "Applying StatefulSet ..."
+		subject.EventData["log"] = subject.Log
+		subject.EventData["footmark"] = enums.UPDATE_RESOURCE
+		subject.EventData["status"] = enums.PROCESSING
+		k.notifyAll(subject)
+		result, getErr := k.GetStatefulSet(resource.Name, resource.Namespace)
+		if getErr != nil {
+			log.Println("Failed to get latest version of StatefulSet: ", getErr)
+			subject.Log = "Failed to get latest version of StatefulSet: " + getErr.Error()
+			subject.EventData["log"] = subject.Log
+			subject.EventData["footmark"] = enums.POST_AGENT_JOB
+			subject.EventData["status"] = enums.DEPLOYMENT_FAILED
+			k.notifyAll(subject)
+			return getErr
+		}
+		current := result.DeepCopy()
+		if err := k.setContainerImages(resource, &result.Spec.Template.Spec, subject); err != nil {
+			subject.Log = err.Error()
+			return err
+		}
+		if result.Labels == nil {
+			result.Labels = make(map[string]string)
+		}
+		result.Labels["company"] = resource.Pipeline.MetaData.CompanyId
+		result.Labels["klovercloud_ci"] = "enabled"
+		result.Labels["process_id"] = resource.ProcessId
+		result.Labels["claim"] = strconv.Itoa(resource.Claim)
+		result.Labels["company"] = resource.Pipeline.MetaData.CompanyId
+		result.Spec.Template.Labels["klovercloud_ci"] = "enabled"
+		result.Spec.Template.Labels["company"] = resource.Pipeline.MetaData.CompanyId
+		result.Spec.Template.Labels["claim"] = strconv.Itoa(resource.Claim)
+		result.Spec.Template.Labels["process_id"] = resource.ProcessId
+		statefulSet, updateErr := k.PatchStatefulSetObject(resource.RolloutRestart, current, result)
+		if updateErr != nil {
+			subject.Log = updateErr.Error()
+			return updateErr
+		}
+		failedSelector = labels.FormatLabels(statefulSet.Spec.Template.Labels)
+		return nil
+	})
+	if err == nil {
+		subject.Log = "Waiting for statefulSet rollout ..."
+		subject.EventData["log"] = subject.Log
+		subject.EventData["footmark"] = enums.POST_AGENT_JOB
+		subject.EventData["status"] = enums.PROCESSING
+		k.notifyAll(subject)
+		err = k.WaitForStatefulSetRollout(resource, failedSelector)
+		if err == nil {
+			err = k.verify(resource, failedSelector)
+		}
+	}
+	if err != nil {
+		if resource.RollbackPolicy != nil && resource.RollbackPolicy.Enabled && failedSelector != "" {
+			return k.rollbackStatefulSet(resource, snapshot, failedSelector, err)
+		}
+		return err
+	}
+	subject.Log = "Updated Successfully"
+	subject.EventData["log"] = subject.Log
+	subject.EventData["footmark"] = enums.POST_AGENT_JOB
+	subject.EventData["status"] = enums.SUCCESSFUL
+	k.notifyAll(subject)
+	return nil
+}
+
+func (k k8sService) UpdateDaemonSet(resource v1.Resource) error {
+	subject := v1.Subject{Step: resource.Step, Log: "Initiating  DaemonSet ...", Name: resource.Name, Namespace: resource.Namespace, ProcessId: resource.ProcessId, Pipeline: resource.Pipeline}
+	subject.EventData = make(map[string]interface{})
+	subject.EventData["footmark"] = enums.UPDATE_RESOURCE
+	subject.EventData["log"] = subject.Log
+	subject.EventData["reason"] = "n/a"
+	subject.EventData["status"] = enums.INITIALIZING
+	subject.EventData["step"] = resource.Step
+	subject.EventData["process_id"] = resource.ProcessId
+	subject.EventData["company_id"] = resource.Pipeline.MetaData.CompanyId
+	subject.EventData["claim"] = strconv.Itoa(resource.Claim)
+	k.notifyAll(subject)
+	// snapshot is the pre-job state rollback restores, so it is taken before anything is changed.
+	snapshot, err := k.GetDaemonSet(resource.Name, resource.Namespace)
+	if err != nil {
+		subject.Log = "Failed to get latest version of DaemonSet: " + err.Error()
+		subject.EventData["log"] = subject.Log
+		subject.EventData["footmark"] = enums.POST_AGENT_JOB
+		subject.EventData["status"] = enums.DEPLOYMENT_FAILED
+		k.notifyAll(subject)
+		return err
+	}
+	failedSelector := ""
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		subject.Log = "Applying DaemonSet ..."
+		subject.EventData["log"] = subject.Log
+		subject.EventData["footmark"] = enums.UPDATE_RESOURCE
+		subject.EventData["status"] = enums.PROCESSING
+		k.notifyAll(subject)
+		result, getErr := k.GetDaemonSet(resource.Name, resource.Namespace)
+		if getErr != nil {
+			log.Println("Failed to get latest version of DaemonSet: ", getErr)
+			subject.Log = "Failed to get latest version of DaemonSet: " + getErr.Error()
+			subject.EventData["log"] = subject.Log
+			subject.EventData["footmark"] = enums.POST_AGENT_JOB
+			subject.EventData["status"] = enums.DEPLOYMENT_FAILED
+			k.notifyAll(subject)
+			return getErr
+		}
+		current := result.DeepCopy()
+		if err := k.setContainerImages(resource, &result.Spec.Template.Spec, subject); err != nil {
+			subject.Log = err.Error()
+			return err
+		}
+		if result.Labels == nil {
+			result.Labels = make(map[string]string)
+		}
+		result.Labels["process_id"] = resource.ProcessId
+		result.Labels["claim"] = strconv.Itoa(resource.Claim)
+		result.Labels["company"] = resource.Pipeline.MetaData.CompanyId
+		result.Labels["klovercloud_ci"] = "enabled"
+		result.Spec.Template.Labels["klovercloud_ci"] = "enabled"
+		result.Spec.Template.Labels["company"] = resource.Pipeline.MetaData.CompanyId
+		result.Spec.Template.Labels["claim"] = strconv.Itoa(resource.Claim)
+		result.Spec.Template.Labels["process_id"] = resource.ProcessId
+		daemonSet, updateErr := k.PatchDaemonSetObject(resource.RolloutRestart, current, result)
+		if updateErr != nil {
+			subject.Log = updateErr.Error()
+			return updateErr
+		}
+		failedSelector = labels.FormatLabels(daemonSet.Spec.Template.Labels)
+		return nil
+	})
+	if err == nil {
+		subject.Log = "Waiting for daemonSet rollout ..."
+		subject.EventData["log"] = subject.Log
+		subject.EventData["footmark"] = enums.POST_AGENT_JOB
+		k.notifyAll(subject)
+		err = k.WaitForDaemonSetRollout(resource, failedSelector)
+		if err == nil {
+			err = k.verify(resource, failedSelector)
+		}
+	}
+	if err != nil {
+		log.Println("Update failed:", err)
+		if resource.RollbackPolicy != nil && resource.RollbackPolicy.Enabled && failedSelector != "" {
+			return k.rollbackDaemonSet(resource, snapshot, err)
+		}
+		return err
+	}
+	subject.Log = "Updated Successfully"
+	subject.EventData["log"] = subject.Log
+	subject.EventData["footmark"] = enums.POST_AGENT_JOB
+	subject.EventData["status"] = enums.SUCCESSFUL
+	k.notifyAll(subject)
+	return nil
+}
+
+func (k k8sService) PatchDaemonSetObject(rolloutRestart bool, cur, mod *appsV1.DaemonSet) (*appsV1.DaemonSet, error) {
+	if rolloutRestart {
+		if mod.Spec.Template.ObjectMeta.Annotations == nil {
+			mod.Spec.Template.ObjectMeta.Annotations = make(map[string]string)
+		}
+		if mod.Annotations == nil {
+			mod.Annotations = make(map[string]string)
+		}
+		mod.Spec.Template.ObjectMeta.Annotations["kubectl.kubernetes.io/restartedAt"] = time.Now().Format(time.RFC3339)
+		mod.Annotations["kubectl.kubernetes.io/restartedAt"] = time.Now().Format(time.RFC3339)
+	}
+	patch, err := createTwoWayMergePatch(cur, mod, appsV1.DaemonSet{})
+	if err != nil {
+		return nil, err
+	}
+	if len(patch) == 0 || string(patch) == "{}" {
+		return cur, nil
+	}
+	out, err := k.kcs.AppsV1().DaemonSets(cur.Namespace).Patch(context.TODO(), cur.Name, types.StrategicMergePatchType, patch, metaV1.PatchOptions{})
+	return out, err
+}
+
+// rollbackDaemonSet restores daemonSet spec from snapshot taken before the failed update and waits for the restored rollout.
+func (k k8sService) rollbackDaemonSet(resource v1.Resource, snapshot *appsV1.DaemonSet, cause error) error {
+	subject := newRollbackSubject(resource, "Rolling back daemonSet "+resource.Name+" to previous revision. Reason: "+cause.Error())
+	k.notifyAll(subject)
+	var restored *appsV1.DaemonSet
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current, err := k.GetDaemonSet(resource.Name, resource.Namespace)
+		if err != nil {
+			return err
+		}
+		mod := current.DeepCopy()
+		mod.Labels = snapshot.Labels
+		mod.Spec = *snapshot.Spec.DeepCopy()
+		restored, err = k.PatchDaemonSetObject(false, current, mod)
+		return err
+	})
+	if err == nil {
+		err = k.waitForRollout(resource, labels.FormatLabels(restored.Spec.Template.Labels), rollbackTimeout(resource), func() (bool, string, error) {
+			daemonSet, err := k.GetDaemonSet(resource.Name, resource.Namespace)
+			if err != nil {
+				return false, "", err
+			}
+			return daemonSetRolloutStatus(daemonSet)
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("%s, rollback failed: %s", cause.Error(), err.Error())
+	}
+	subject.Log = "DaemonSet " + resource.Name + " rolled back to previous revision"
+	subject.EventData["log"] = subject.Log
+	k.notifyAll(subject)
+	return rolledBackError{err: cause}
+}
+
+// setContainerImages sets resource images to the containers of pod spec. Positional images are matched to containers by index
+// and images without a matching container are ignored. Container images are matched by container name, init containers included,
+// and every referenced container must exist.
+func (k k8sService) setContainerImages(resource v1.Resource, podSpec *coreV1.PodSpec, subject v1.Subject) error {
+	for i, each := range resource.Images {
+		if i > len(podSpec.Containers)-1 {
+			subject.Log = "[WARNING]index out of bound! ignoring container for " + each
+			subject.EventData["log"] = subject.Log
+			subject.EventData["footmark"] = enums.UPDATE_RESOURCE
+			k.notifyAll(subject)
+		} else {
+			podSpec.Containers[i].Image = each
+		}
+	}
+	for name, image := range resource.ContainerImages {
+		if index := containerIndex(podSpec.Containers, name); index >= 0 {
+			podSpec.Containers[index].Image = image
+		} else if index := containerIndex(podSpec.InitContainers, name); index >= 0 {
+			podSpec.InitContainers[index].Image = image
+		} else if index := ephemeralContainerIndex(podSpec.EphemeralContainers, name); index >= 0 {
+			if podSpec.EphemeralContainers[index].Image != image {
+				return fmt.Errorf("ephemeral container %s of %s can not be modified", name, resource.Name)
+			}
+		} else {
+			return fmt.Errorf("container %s not found in %s %s", name, resource.Type, resource.Name)
+		}
+	}
+	return nil
+}
+
+func containerIndex(containers []coreV1.Container, name string) int {
+	for i, each := range containers {
+		if each.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func ephemeralContainerIndex(containers []coreV1.EphemeralContainer, name string) int {
+	for i, each := range containers {
+		if each.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (k k8sService) UpdateJob(resource v1.Resource) error {
+	subject := v1.Subject{Step: resource.Step, Log: "Initiating  Job ...", Name: resource.Name, Namespace: resource.Namespace, ProcessId: resource.ProcessId, Pipeline: resource.Pipeline}
+	subject.EventData = make(map[string]interface{})
+	subject.EventData["footmark"] = enums.UPDATE_RESOURCE
+	subject.EventData["log"] = subject.Log
+	subject.EventData["reason"] = "n/a"
+	subject.EventData["status"] = enums.INITIALIZING
+	subject.EventData["step"] = resource.Step
+	subject.EventData["process_id"] = resource.ProcessId
+	subject.EventData["company_id"] = resource.Pipeline.MetaData.CompanyId
+	subject.EventData["claim"] = strconv.Itoa(resource.Claim)
+	k.notifyAll(subject)
+	result, getErr := k.kcs.BatchV1().Jobs(resource.Namespace).Get(context.Background(), resource.Name, metaV1.GetOptions{})
+	if getErr != nil {
+		log.Println("Failed to get latest version of Job: ", getErr)
+		subject.Log = "Failed to get latest version of Job: " + getErr.Error()
+		subject.EventData["log"] = subject.Log
+		subject.EventData["footmark"] = enums.POST_AGENT_JOB
+		subject.EventData["status"] = enums.DEPLOYMENT_FAILED
+		k.notifyAll(subject)
+		return getErr
+	}
+	subject.Log = "Applying Job ..."
+	subject.EventData["log"] = subject.Log
+	subject.EventData["status"] = enums.PROCESSING
+	k.notifyAll(subject)
+	mod := result.DeepCopy()
+	if err := k.setContainerImages(resource, &mod.Spec.Template.Spec, subject); err != nil {
+		return err
+	}
+	if mod.Labels == nil {
+		mod.Labels = make(map[string]string)
+	}
+	if mod.Spec.Template.Labels == nil {
+		mod.Spec.Template.Labels = make(map[string]string)
+	}
+	mod.Labels["company"] = resource.Pipeline.MetaData.CompanyId
+	mod.Labels["klovercloud_ci"] = "enabled"
+	mod.Labels["process_id"] = resource.ProcessId
+	mod.Labels["claim"] = strconv.Itoa(resource.Claim)
+	mod.Spec.Template.Labels["klovercloud_ci"] = "enabled"
+	mod.Spec.Template.Labels["company"] = resource.Pipeline.MetaData.CompanyId
+	mod.Spec.Template.Labels["claim"] = strconv.Itoa(resource.Claim)
+	mod.Spec.Template.Labels["process_id"] = resource.ProcessId
+	// job template is immutable, job is deleted and created again to run it with the new template.
+	if mod.Spec.ManualSelector == nil || !*mod.Spec.ManualSelector {
+		mod.Spec.Selector = nil
+		delete(mod.Spec.Template.Labels, "controller-uid")
+		delete(mod.Spec.Template.Labels, "job-name")
+	}
+	mod.ResourceVersion = ""
+	mod.UID = ""
+	mod.CreationTimestamp = metaV1.Time{}
+	mod.ManagedFields = nil
+	mod.Status = batchV1.JobStatus{}
+	propagationPolicy := metaV1.DeletePropagationBackground
+	err := k.kcs.BatchV1().Jobs(resource.Namespace).Delete(context.Background(), resource.Name, metaV1.DeleteOptions{PropagationPolicy: &propagationPolicy})
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		return err
+	}
+	err = k.waitForRollout(resource, "", config.RolloutTimeout, func() (bool, string, error) {
+		_, err := k.kcs.BatchV1().Jobs(resource.Namespace).Get(context.Background(), resource.Name, metaV1.GetOptions{})
+		if k8sErrors.IsNotFound(err) {
+			return true, "Previous run of job " + resource.Name + " deleted", nil
+		}
+		return false, "Waiting for previous run of job " + resource.Name + " to be deleted ...", err
+	})
+	if err != nil {
+		return err
+	}
+	job, err := k.kcs.BatchV1().Jobs(resource.Namespace).Create(context.Background(), mod, metaV1.CreateOptions{FieldManager: config.FieldManager})
+	if err != nil {
+		return err
+	}
+	err = k.waitForJobCompletion(resource, job)
+	if err != nil {
+		return err
+	}
+	subject.Log = "Job Completed Successfully"
+	subject.EventData["log"] = subject.Log
+	subject.EventData["footmark"] = enums.POST_AGENT_JOB
+	subject.EventData["status"] = enums.SUCCESSFUL
+	k.notifyAll(subject)
+	return nil
+}
+
+func (k k8sService) UpdateCronJob(resource v1.Resource) error {
+	subject := v1.Subject{Step: resource.Step, Log: "Initiating  CronJob ...", Name: resource.Name, Namespace: resource.Namespace, ProcessId: resource.ProcessId, Pipeline: resource.Pipeline}
+	subject.EventData = make(map[string]interface{})
+	subject.EventData["footmark"] = enums.UPDATE_RESOURCE
+	subject.EventData["log"] = subject.Log
+	subject.EventData["reason"] = "n/a"
+	subject.EventData["status"] = enums.INITIALIZING
+	subject.EventData["step"] = resource.Step
+	subject.EventData["process_id"] = resource.ProcessId
+	subject.EventData["company_id"] = resource.Pipeline.MetaData.CompanyId
+	subject.EventData["claim"] = strconv.Itoa(resource.Claim)
+	k.notifyAll(subject)
+	var cronJob *batchV1beta1.CronJob
+	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		subject.Log = "Applying CronJob ..."
+		subject.EventData["log"] = subject.Log
+		subject.EventData["status"] = enums.PROCESSING
+		k.notifyAll(subject)
+		result, getErr := k.kcs.BatchV1beta1().CronJobs(resource.Namespace).Get(context.Background(), resource.Name, metaV1.GetOptions{})
+		if getErr != nil {
+			log.Println("Failed to get latest version of CronJob: ", getErr)
+			subject.Log = "Failed to get latest version of CronJob: " + getErr.Error()
+			subject.EventData["log"] = subject.Log
+			subject.EventData["footmark"] = enums.POST_AGENT_JOB
+			subject.EventData["status"] = enums.DEPLOYMENT_FAILED
+			k.notifyAll(subject)
+			return getErr
+		}
+		if err := k.setContainerImages(resource, &result.Spec.JobTemplate.Spec.Template.Spec, subject); err != nil {
+			return err
+		}
+		if result.Labels == nil {
+			result.Labels = make(map[string]string)
+		}
+		if result.Spec.JobTemplate.Spec.Template.Labels == nil {
+			result.Spec.JobTemplate.Spec.Template.Labels = make(map[string]string)
+		}
+		result.Labels["company"] = resource.Pipeline.MetaData.CompanyId
+		result.Labels["klovercloud_ci"] = "enabled"
+		result.Labels["process_id"] = resource.ProcessId
+		result.Labels["claim"] = strconv.Itoa(resource.Claim)
+		result.Spec.JobTemplate.Spec.Template.Labels["klovercloud_ci"] = "enabled"
+		result.Spec.JobTemplate.Spec.Template.Labels["company"] = resource.Pipeline.MetaData.CompanyId
+		result.Spec.JobTemplate.Spec.Template.Labels["claim"] = strconv.Itoa(resource.Claim)
+		result.Spec.JobTemplate.Spec.Template.Labels["process_id"] = resource.ProcessId
+		var updateErr error
+		cronJob, updateErr = k.kcs.BatchV1beta1().CronJobs(resource.Namespace).Update(context.Background(), result, metaV1.UpdateOptions{FieldManager: config.FieldManager})
+		return updateErr
+	})
+	if retryErr != nil {
+		return retryErr
+	}
+	if resource.Trigger {
+		subject.Log = "Triggering CronJob " + cronJob.Name + " ..."
+		subject.EventData["log"] = subject.Log
+		k.notifyAll(subject)
+		name := cronJob.Name + "-manual-" + strconv.FormatInt(time.Now().Unix(), 10)
+		if len(name) > 63 {
+			name = name[len(name)-63:]
+		}
+		job := &batchV1.Job{
+			ObjectMeta: metaV1.ObjectMeta{
+				Name:            name,
+				Namespace:       cronJob.Namespace,
+				Labels:          cronJob.Spec.JobTemplate.Labels,
+				Annotations:     map[string]string{"cronjob.kubernetes.io/instantiate": "manual"},
+				OwnerReferences: []metaV1.OwnerReference{*metaV1.NewControllerRef(cronJob, batchV1beta1.SchemeGroupVersion.WithKind("CronJob"))},
 			},
-			UpdateFunc: func(oldObj, newObj interface{}) {
-				oldK8sObj := oldObj.(*coreV1.Event)
-				newK8sObj := newObj.(*coreV1.Event)
-				obj := KubeObject{
-					OldK8sObj: oldK8sObj,
-					NewK8sObj: newK8sObj,
+			Spec: *cronJob.Spec.JobTemplate.Spec.DeepCopy(),
+		}
+		created, err := k.kcs.BatchV1().Jobs(cronJob.Namespace).Create(context.Background(), job, metaV1.CreateOptions{FieldManager: config.FieldManager})
+		if err != nil {
+			return err
+		}
+		err = k.waitForJobCompletion(resource, created)
+		if err != nil {
+			return err
+		}
+	}
+	subject.Log = "Updated Successfully"
+	subject.EventData["log"] = subject.Log
+	subject.EventData["footmark"] = enums.POST_AGENT_JOB
+	subject.EventData["status"] = enums.SUCCESSFUL
+	k.notifyAll(subject)
+	return nil
+}
+
+// waitForJobCompletion waits until job completes or fails, then reports exit status and logs of job pods to the observers.
+func (k k8sService) waitForJobCompletion(resource v1.Resource, job *batchV1.Job) error {
+	selector, err := metaV1.LabelSelectorAsSelector(job.Spec.Selector)
+	if err != nil {
+		return err
+	}
+	timeout := config.RolloutTimeout
+	if job.Spec.ActiveDeadlineSeconds != nil && *job.Spec.ActiveDeadlineSeconds > timeout {
+		timeout = *job.Spec.ActiveDeadlineSeconds
+	}
+	waitErr := k.waitForRollout(resource, "", timeout, func() (bool, string, error) {
+		current, err := k.kcs.BatchV1().Jobs(job.Namespace).Get(context.Background(), job.Name, metaV1.GetOptions{})
+		if err != nil {
+			return false, "", err
+		}
+		done, message, err := jobCompletionStatus(current)
+		if done || err != nil {
+			return done, message, err
+		}
+		podList, err := k.kcs.CoreV1().Pods(job.Namespace).List(context.Background(), metaV1.ListOptions{LabelSelector: selector.String()})
+		if err != nil {
+			return false, "", err
+		}
+		for _, pod := range podList.Items {
+			for _, each := range pod.Status.ContainerStatuses {
+				if each.State.Waiting == nil {
+					continue
 				}
-				if newK8sObj.InvolvedObject.Kind == "Pod" {
-					pod, err := k.kcs.CoreV1().Pods(newK8sObj.InvolvedObject.Namespace).Get(context.TODO(), newK8sObj.InvolvedObject.Name, metaV1.GetOptions{})
-					if err != nil {
-						log.Println(err.Error())
-					} else {
-						if _, ok := pod.Labels["klovercloud_ci"]; ok {
-							k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-								Body: obj,
-								Header: v1.MessageHeader{
-									Offset:  0,
-									Command: enums.UPDATE,
-									Extras:  extrasMap,
-								},
-							})
-							log.Println("action:", "add", "type", newK8sObj.Type, "kind:", newK8sObj.InvolvedObject.Kind, " reason:", newK8sObj.Reason, " age:", newK8sObj.CreationTimestamp, " from:", newK8sObj.InvolvedObject.Name, "", "msg:", newK8sObj.Message)
-						}
-					}
+				switch each.State.Waiting.Reason {
+				case "ImagePullBackOff", "ErrImagePull", "InvalidImageName", "CreateContainerConfigError":
+					return false, "", errors.New("Pod " + pod.Name + " has error: " + each.State.Waiting.Reason + "." + " [Message]:" + each.State.Waiting.Message)
 				}
-			},
-			DeleteFunc: func(obj interface{}) {
-				eventObj := obj.(*coreV1.Event)
-				if eventObj.InvolvedObject.Kind == "Pod" {
-					pod, err := k.kcs.CoreV1().Pods(eventObj.InvolvedObject.Namespace).Get(context.TODO(), eventObj.InvolvedObject.Name, metaV1.GetOptions{})
-					if err != nil {
-						log.Println(err.Error())
-					} else {
-						if _, ok := pod.Labels["klovercloud_ci"]; ok {
-							k.kubeEventPublisher.Publish(v1.KubeEventMessage{
-								Body: eventObj,
-								Header: v1.MessageHeader{
-									Offset:  0,
-									Command: enums.DELETE,
-									Extras:  extrasMap,
-								},
-							})
-							log.Println("action:", "add", "type", eventObj.Type, "kind:", eventObj.InvolvedObject.Kind, " reason:", eventObj.Reason, " age:", eventObj.CreationTimestamp, " from:", eventObj.InvolvedObject.Name, "", "msg:", eventObj.Message)
-						}
-					}
+			}
+		}
+		return false, message, nil
+	})
+	k.notifyJobPodLogs(resource, job.Namespace, selector.String())
+	return waitErr
+}
+
+// notifyJobPodLogs reports exit code and tail of logs of every container of the job pods.
+func (k k8sService) notifyJobPodLogs(resource v1.Resource, namespace, selector string) {
+	podList, err := k.kcs.CoreV1().Pods(namespace).List(context.Background(), metaV1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+	tailLines := int64(100)
+	for _, pod := range podList.Items {
+		for _, each := range pod.Status.ContainerStatuses {
+			subject := v1.Subject{Step: resource.Step, Name: resource.Name, Namespace: resource.Namespace, ProcessId: resource.ProcessId, Pipeline: resource.Pipeline}
+			subject.EventData = make(map[string]interface{})
+			subject.EventData["footmark"] = enums.POST_AGENT_JOB
+			subject.EventData["reason"] = "n/a"
+			subject.EventData["status"] = enums.PROCESSING
+			subject.EventData["step"] = resource.Step
+			subject.EventData["process_id"] = resource.ProcessId
+			subject.EventData["company_id"] = resource.Pipeline.MetaData.CompanyId
+			subject.EventData["claim"] = strconv.Itoa(resource.Claim)
+			subject.EventData["pod"] = pod.Name
+			subject.EventData["container"] = each.Name
+			exitStatus := "running"
+			if each.State.Terminated != nil {
+				subject.EventData["exit_code"] = each.State.Terminated.ExitCode
+				exitStatus = "exit code " + strconv.Itoa(int(each.State.Terminated.ExitCode)) + " (" + each.State.Terminated.Reason + ")"
+			}
+			logs, err := k.kcs.CoreV1().Pods(namespace).GetLogs(pod.Name, &coreV1.PodLogOptions{Container: each.Name, TailLines: &tailLines}).DoRaw(context.Background())
+			if err != nil {
+				logs = []byte(err.Error())
+			}
+			subject.Log = "[" + pod.Name + "/" + each.Name + "] " + exitStatus + "\n" + string(logs)
+			subject.EventData["log"] = subject.Log
+			k.notifyAll(subject)
+		}
+	}
+}
+
+// jobCompletionStatus returns completion status of the job from its conditions.
+func jobCompletionStatus(job *batchV1.Job) (bool, string, error) {
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != coreV1.ConditionTrue {
+			continue
+		}
+		if condition.Type == batchV1.JobComplete {
+			return true, fmt.Sprintf("Job %s completed: %d succeeded", job.Name, job.Status.Succeeded), nil
+		}
+		if condition.Type == batchV1.JobFailed {
+			return false, "", fmt.Errorf("job %s failed: %s %s", job.Name, condition.Reason, condition.Message)
+		}
+	}
+	return false, fmt.Sprintf("Waiting for job %s to complete: %d active, %d succeeded, %d failed ...", job.Name, job.Status.Active, job.Status.Succeeded, job.Status.Failed), nil
+}
+
+func (k k8sService) UpdateArgoRollout(resource v1.Resource) error {
+	subject := v1.Subject{Step: resource.Step, Log: "Initiating  Rollout ...", Name: resource.Name, Namespace: resource.Namespace, ProcessId: resource.ProcessId, Pipeline: resource.Pipeline}
+	subject.EventData = make(map[string]interface{})
+	subject.EventData["footmark"] = enums.UPDATE_RESOURCE
+	subject.EventData["log"] = subject.Log
+	subject.EventData["reason"] = "n/a"
+	subject.EventData["status"] = enums.INITIALIZING
+	subject.EventData["step"] = resource.Step
+	subject.EventData["process_id"] = resource.ProcessId
+	subject.EventData["company_id"] = resource.Pipeline.MetaData.CompanyId
+	subject.EventData["claim"] = strconv.Itoa(resource.Claim)
+	k.notifyAll(subject)
+	rollouts := k.dynamicClient.Resource(argoRolloutResource).Namespace(resource.Namespace)
+	selector := ""
+	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		subject.Log = "Applying Rollout ..."
+		subject.EventData["log"] = subject.Log
+		subject.EventData["status"] = enums.PROCESSING
+		k.notifyAll(subject)
+		result, getErr := rollouts.Get(context.Background(), resource.Name, metaV1.GetOptions{})
+		if getErr != nil {
+			log.Println("Failed to get latest version of Rollout: ", getErr)
+			subject.Log = "Failed to get latest version of Rollout: " + getErr.Error()
+			subject.EventData["log"] = subject.Log
+			subject.EventData["footmark"] = enums.POST_AGENT_JOB
+			subject.EventData["status"] = enums.DEPLOYMENT_FAILED
+			k.notifyAll(subject)
+			return getErr
+		}
+		templateObj, found, err := unstructured.NestedMap(result.Object, "spec", "template")
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("rollout %s has no pod template", resource.Name)
+		}
+		template := coreV1.PodTemplateSpec{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(templateObj, &template); err != nil {
+			return err
+		}
+		if err := k.setContainerImages(resource, &template.Spec, subject); err != nil {
+			return err
+		}
+		if template.Labels == nil {
+			template.Labels = make(map[string]string)
+		}
+		template.Labels["klovercloud_ci"] = "enabled"
+		template.Labels["company"] = resource.Pipeline.MetaData.CompanyId
+		template.Labels["claim"] = strconv.Itoa(resource.Claim)
+		template.Labels["process_id"] = resource.ProcessId
+		templateObj, err = runtime.DefaultUnstructuredConverter.ToUnstructured(&template)
+		if err != nil {
+			return err
+		}
+		if err := unstructured.SetNestedField(result.Object, templateObj, "spec", "template"); err != nil {
+			return err
+		}
+		objLabels := result.GetLabels()
+		if objLabels == nil {
+			objLabels = make(map[string]string)
+		}
+		objLabels["company"] = resource.Pipeline.MetaData.CompanyId
+		objLabels["klovercloud_ci"] = "enabled"
+		objLabels["process_id"] = resource.ProcessId
+		objLabels["claim"] = strconv.Itoa(resource.Claim)
+		result.SetLabels(objLabels)
+		_, updateErr := rollouts.Update(context.Background(), result, metaV1.UpdateOptions{FieldManager: config.FieldManager})
+		selector = labels.FormatLabels(template.Labels)
+		return updateErr
+	})
+	if retryErr != nil {
+		return retryErr
+	}
+	subject.Log = "Waiting for rollout ..."
+	subject.EventData["log"] = subject.Log
+	subject.EventData["footmark"] = enums.POST_AGENT_JOB
+	k.notifyAll(subject)
+	err := k.waitForRollout(resource, selector, config.RolloutTimeout, func() (bool, string, error) {
+		rollout, err := rollouts.Get(context.Background(), resource.Name, metaV1.GetOptions{})
+		if err != nil {
+			return false, "", err
+		}
+		return argoRolloutStatus(rollout)
+	})
+	if err != nil {
+		return err
+	}
+	subject.Log = "Updated Successfully"
+	subject.EventData["log"] = subject.Log
+	subject.EventData["footmark"] = enums.POST_AGENT_JOB
+	subject.EventData["status"] = enums.SUCCESSFUL
+	k.notifyAll(subject)
+	return nil
+}
 
-				}
-			},
-		},
-	)
+var argoRolloutResource = schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "rollouts"}
+
+// argoRolloutStatus returns rollout status from the phase reported by argo rollouts controller.
+// A paused rollout is considered done as it waits for promotion.
+func argoRolloutStatus(rollout *unstructured.Unstructured) (bool, string, error) {
+	observedGeneration, _, _ := unstructured.NestedFieldNoCopy(rollout.Object, "status", "observedGeneration")
+	if fmt.Sprint(observedGeneration) != strconv.FormatInt(rollout.GetGeneration(), 10) {
+		return false, "Waiting for rollout " + rollout.GetName() + " spec update to be observed ...", nil
+	}
+	phase, _, _ := unstructured.NestedString(rollout.Object, "status", "phase")
+	message, _, _ := unstructured.NestedString(rollout.Object, "status", "message")
+	switch phase {
+	case "Healthy":
+		return true, "Rollout " + rollout.GetName() + " is healthy", nil
+	case "Paused":
+		return true, "Rollout " + rollout.GetName() + " is paused, awaiting promotion. " + message, nil
+	case "Degraded":
+		return false, "", fmt.Errorf("rollout %s is degraded: %s", rollout.GetName(), message)
+	}
+	return false, "Waiting for rollout " + rollout.GetName() + " to become healthy: " + phase + " " + message, nil
+}
+
+// strategyAborts holds abort signals of running progressive deliveries, keyed by process id and step.
+var strategyAborts = struct {
+	sync.Mutex
+	channels map[string]chan struct{}
+}{channels: make(map[string]chan struct{})}
+
+var errStrategyAborted = errors.New("progressive delivery aborted")
+
+func strategyAbortKey(processId, step string) string {
+	return processId + "/" + step
+}
+
+// registerStrategyAbort registers abort signal of the resource and returns it. Progressive delivery must hold on to the
+// returned signal, as it stays registered only until unregisterStrategyAbort.
+func registerStrategyAbort(resource v1.Resource) chan struct{} {
+	strategyAborts.Lock()
+	defer strategyAborts.Unlock()
+	signal := make(chan struct{})
+	strategyAborts.channels[strategyAbortKey(resource.ProcessId, resource.Step)] = signal
+	return signal
+}
+
+// unregisterStrategyAbort unregisters signal, unless a later run of the same process step has registered its own since.
+func unregisterStrategyAbort(resource v1.Resource, signal chan struct{}) {
+	strategyAborts.Lock()
+	defer strategyAborts.Unlock()
+	key := strategyAbortKey(resource.ProcessId, resource.Step)
+	if strategyAborts.channels[key] == signal {
+		delete(strategyAborts.channels, key)
+	}
+}
+
+// strategyAbortSignal returns abort signal of the resource, nil if no progressive delivery is running for it.
+func strategyAbortSignal(resource v1.Resource) <-chan struct{} {
+	strategyAborts.Lock()
+	defer strategyAborts.Unlock()
+	return strategyAborts.channels[strategyAbortKey(resource.ProcessId, resource.Step)]
+}
+
+func isStrategyAborted(abort <-chan struct{}) bool {
+	select {
+	case <-abort:
+		return true
+	default:
+		return false
+	}
+}
+
+func (k k8sService) AbortStrategy(processId, step string) error {
+	strategyAborts.Lock()
+	defer strategyAborts.Unlock()
+	key := strategyAbortKey(processId, step)
+	signal, ok := strategyAborts.channels[key]
+	if !ok {
+		return errors.New("no running progressive delivery found for process " + processId + " step " + step)
+	}
+	// signal is only closed under the lock, so it can not be closed in between.
+	select {
+	case <-signal:
+	default:
+		close(signal)
+	}
+	return nil
 }
 
-func (k k8sService) Apply(data unstructured.Unstructured) error {
-	_, err := k.Deploy(&data)
+// BlueGreenDeployment deploys the resource into the idle slot, switches service to it once ready and retires the previously
+// active slot. On first run, the service selects the original deployment, which is cloned into the blue slot and scaled
+// down first, so the service never selects pods of the new slot before the switch.
+func (k k8sService) BlueGreenDeployment(resource v1.Resource) error {
+	strategy := resource.Strategy.BlueGreen
+	if strategy == nil || strategy.Service == "" {
+		return errors.New("blue green strategy requires a service")
+	}
+	subject := newStrategySubject(resource, "Initiating blue green deployment ...", "init")
+	k.notifyAll(subject)
+	abort := registerStrategyAbort(resource)
+	defer unregisterStrategyAbort(resource, abort)
+	svc, err := k.kcs.CoreV1().Services(resource.Namespace).Get(context.Background(), strategy.Service, metaV1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	activeSlot := svc.Spec.Selector[slotLabel]
+	if activeSlot == "" {
+		if svc, err = k.pinServiceToSlot(resource, svc, "blue", abort); err != nil {
+			return err
+		}
+		activeSlot = "blue"
+	}
+	newSlot := "green"
+	if activeSlot == "green" {
+		newSlot = "blue"
+	}
+	activeName := resource.Name + "-" + activeSlot
+	active, err := k.GetDeployment(activeName, resource.Namespace)
+	if err != nil {
+		return err
+	}
+	slotResource := resource
+	slotResource.Name = resource.Name + "-" + newSlot
+	subject.Log = "Deploying " + newSlot + " slot " + slotResource.Name + " ..."
+	subject.EventData["log"] = subject.Log
+	subject.EventData["phase"] = "deploy"
+	k.notifyAll(subject)
+	desired := k.newVariantDeployment(resource, active, slotResource.Name, slotLabel, newSlot)
+	err = k.setContainerImages(resource, &desired.Spec.Template.Spec, subject)
+	if err == nil {
+		_, err = k.createOrPatchDeployment(desired)
+	}
+	if err == nil {
+		err = k.waitForDeploymentRollout(slotResource, labels.FormatLabels(desired.Spec.Template.Labels), abort)
+	}
+	if err == nil && isStrategyAborted(abort) {
+		err = errStrategyAborted
+	}
+	if err != nil {
+		subject.Log = "Blue green deployment failed, scaling down " + newSlot + " slot. Reason: " + err.Error()
+		subject.EventData["log"] = subject.Log
+		subject.EventData["phase"] = "abort"
+		k.notifyAll(subject)
+		if scaleErr := k.scaleDeployment(resource.Namespace, slotResource.Name, 0); scaleErr != nil {
+			return fmt.Errorf("%s, scale down failed: %s", err.Error(), scaleErr.Error())
+		}
+		return rolledBackError{err: err}
+	}
+
+	subject.Log = "Switching service " + svc.Name + " to " + newSlot + " slot ..."
+	subject.EventData["log"] = subject.Log
+	subject.EventData["phase"] = "switch"
+	k.notifyAll(subject)
+	previousSelector := svc.Spec.Selector
+	if err := k.patchServiceSelector(svc, newSlot); err != nil {
+		return err
+	}
+	err = k.verify(slotResource, labels.FormatLabels(desired.Spec.Template.Labels))
+	if err == nil && isStrategyAborted(abort) {
+		err = errStrategyAborted
+	}
+	if err != nil {
+		subject.Log = "Switching service " + svc.Name + " back to " + activeName + ". Reason: " + err.Error()
+		subject.EventData["log"] = subject.Log
+		subject.EventData["phase"] = "abort"
+		k.notifyAll(subject)
+		restoreErr := k.restoreServiceSelector(svc.Namespace, svc.Name, previousSelector)
+		if restoreErr == nil {
+			restoreErr = k.scaleDeployment(resource.Namespace, slotResource.Name, 0)
+		}
+		if restoreErr != nil {
+			return fmt.Errorf("%s, switch back failed: %s", err.Error(), restoreErr.Error())
+		}
+		return rolledBackError{err: err}
+	}
+	delay := time.Duration(strategy.ScaleDownDelayMinutes) * time.Minute
+	if delay <= 0 {
+		subject.Log = "Service " + svc.Name + " switched to " + newSlot + " slot, scaling down " + activeName + " ..."
+		subject.EventData["log"] = subject.Log
+		subject.EventData["phase"] = "scale_down"
+		k.notifyAll(subject)
+		return k.scaleDeployment(resource.Namespace, activeName, 0)
+	}
+	subject.Log = "Service " + svc.Name + " switched to " + newSlot + " slot, " + activeName + " will be scaled down in " + delay.String()
+	subject.EventData["log"] = subject.Log
+	subject.EventData["phase"] = "switched"
+	k.notifyAll(subject)
+	return k.retireDeployment(resource.Namespace, activeName, time.Now().Add(delay))
+}
+
+// pinServiceToSlot clones the deployment the service selects into slot and, once the clone is rolled out, pins service
+// selector to slot and scales the original deployment down. Pods of the original carry no slot label, so the service
+// stops selecting them.
+func (k k8sService) pinServiceToSlot(resource v1.Resource, svc *coreV1.Service, slot string, abort <-chan struct{}) (*coreV1.Service, error) {
+	original, err := k.GetDeployment(resource.Name, resource.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	slotResource := resource
+	slotResource.Name = resource.Name + "-" + slot
+	subject := newStrategySubject(resource, "Service "+svc.Name+" selects no slot, cloning "+resource.Name+" into "+slot+" slot "+slotResource.Name+" ...", "pin")
+	k.notifyAll(subject)
+	clone := k.newVariantDeployment(resource, original, slotResource.Name, slotLabel, slot)
+	if _, err := k.createOrPatchDeployment(clone); err != nil {
+		return nil, err
+	}
+	if err := k.waitForDeploymentRollout(slotResource, labels.FormatLabels(clone.Spec.Template.Labels), abort); err != nil {
+		return nil, err
+	}
+	if isStrategyAborted(abort) {
+		return nil, errStrategyAborted
+	}
+	if err := k.patchServiceSelector(svc, slot); err != nil {
+		return nil, err
+	}
+	subject.Log = "Service " + svc.Name + " pinned to " + slot + " slot, scaling down " + resource.Name + " ..."
+	subject.EventData["log"] = subject.Log
+	k.notifyAll(subject)
+	if err := k.scaleDeployment(resource.Namespace, resource.Name, 0); err != nil {
+		return nil, err
+	}
+	return k.kcs.CoreV1().Services(svc.Namespace).Get(context.Background(), svc.Name, metaV1.GetOptions{})
+}
+
+const (
+	// retiredLabel marks deployments retired by a blue green switch, waiting to be scaled down.
+	retiredLabel = "klovercloud_ci_retired"
+	// scaleDownAtAnnotation holds the time a retired deployment is scaled down at, in RFC3339.
+	scaleDownAtAnnotation = "klovercloud.com/scale-down-at"
+)
+
+// retireDeployment marks the deployment to be scaled down at the given time by ScaleDownRetired. Marks are kept on the
+// deployment, so scale down survives agent restarts.
+func (k k8sService) retireDeployment(namespace, name string, at time.Time) error {
+	patch, err := json.Marshal(map[string]interface{}{"metadata": map[string]interface{}{
+		"labels":      map[string]string{retiredLabel: "true"},
+		"annotations": map[string]string{scaleDownAtAnnotation: at.UTC().Format(time.RFC3339)},
+	}})
 	if err != nil {
-		log.Println(err.Error())
 		return err
 	}
+	_, err = k.kcs.AppsV1().Deployments(namespace).Patch(context.Background(), name, types.MergePatchType, patch, metaV1.PatchOptions{FieldManager: config.FieldManager})
+	return err
+}
+
+// ScaleDownRetired scales down deployments retired by blue green switches whose scale down time has passed.
+func (k k8sService) ScaleDownRetired() error {
+	deployments, err := k.kcs.AppsV1().Deployments(metaV1.NamespaceAll).List(context.Background(), metaV1.ListOptions{LabelSelector: retiredLabel})
+	if err != nil {
+		return err
+	}
+	for _, each := range deployments.Items {
+		at, err := time.Parse(time.RFC3339, each.Annotations[scaleDownAtAnnotation])
+		if err == nil && time.Now().Before(at) {
+			continue
+		}
+		log.Println("Scaling down retired deployment", each.Namespace, each.Name)
+		if err := k.scaleDeployment(each.Namespace, each.Name, 0); err != nil {
+			log.Println("Failed to scale down retired deployment:", err.Error())
+			continue
+		}
+		patch, _ := json.Marshal(map[string]interface{}{"metadata": map[string]interface{}{
+			"labels":      map[string]interface{}{retiredLabel: nil},
+			"annotations": map[string]interface{}{scaleDownAtAnnotation: nil},
+		}})
+		if _, err := k.kcs.AppsV1().Deployments(each.Namespace).Patch(context.Background(), each.Name, types.MergePatchType, patch, metaV1.PatchOptions{FieldManager: config.FieldManager}); err != nil {
+			log.Println("Failed to unmark retired deployment:", err.Error())
+		}
+	}
 	return nil
 }
 
-func (k k8sService) Deploy(data *unstructured.Unstructured) (bool, error) {
-	for {
-		version := data.GetAPIVersion()
-		kind := data.GetKind()
-		gv, err := schema.ParseGroupVersion(version)
+// CanaryDeployment shifts replicas from stable deployment to a canary deployment step by step and promotes the canary by
+// updating stable. If a HorizontalPodAutoscaler targets stable, it keeps owning stable replicas and canary runs alongside.
+func (k k8sService) CanaryDeployment(resource v1.Resource) error {
+	strategy := resource.Strategy.Canary
+	if strategy == nil || len(strategy.Steps) == 0 {
+		return errors.New("canary strategy requires at least one step")
+	}
+	subject := newStrategySubject(resource, "Initiating canary deployment ...", "init")
+	k.notifyAll(subject)
+	abort := registerStrategyAbort(resource)
+	defer unregisterStrategyAbort(resource, abort)
+	stable, err := k.GetDeployment(resource.Name, resource.Namespace)
+	if err != nil {
+		return err
+	}
+	stableResource := resource
+	stableResource.Type = enums.DEPLOYMENT
+	total, hpa, err := k.canaryTotal(stableResource, stable)
+	if err != nil {
+		return err
+	}
+	if hpa != "" {
+		subject.Log = "HorizontalPodAutoscaler " + hpa + " targets " + resource.Name + ", canary replicas are added alongside stable ..."
+		subject.EventData["log"] = subject.Log
+		k.notifyAll(subject)
+	}
+	canaryResource := resource
+	canaryResource.Name = resource.Name + "-canary"
+	canary := k.newVariantDeployment(resource, stable, canaryResource.Name, trackLabel, "canary")
+	if err := k.setContainerImages(resource, &canary.Spec.Template.Spec, subject); err != nil {
+		return err
+	}
+	zero := int32(0)
+	canary.Spec.Replicas = &zero
+	if _, err := k.createOrPatchDeployment(canary); err != nil {
+		return err
+	}
+	canarySelector := labels.FormatLabels(canary.Spec.Template.Labels)
+	for i, step := range strategy.Steps {
+		canaryReplicas := int32(math.Ceil(float64(total) * float64(step.Weight) / 100))
+		if canaryReplicas > total {
+			canaryReplicas = total
+		}
+		if canaryReplicas < 1 && step.Weight > 0 {
+			canaryReplicas = 1
+		}
+		subject.Log = fmt.Sprintf("Canary step %d: weight %d%%, scaling canary to %d of %d replicas ...", i+1, step.Weight, canaryReplicas, total)
+		subject.EventData["log"] = subject.Log
+		subject.EventData["phase"] = "step"
+		subject.EventData["canary_step"] = i + 1
+		subject.EventData["canary_weight"] = step.Weight
+		k.notifyAll(subject)
+		err = k.scaleDeployment(resource.Namespace, canaryResource.Name, canaryReplicas)
+		if err == nil {
+			err = k.waitForDeploymentRollout(canaryResource, canarySelector, abort)
+		}
+		if err == nil && hpa == "" {
+			err = k.scaleDeployment(resource.Namespace, resource.Name, total-canaryReplicas)
+		}
+		if err == nil {
+			err = k.pauseCanaryStep(resource, canarySelector, time.Duration(step.PauseSeconds)*time.Second, abort)
+		}
+		if err != nil {
+			return k.abortCanary(resource, canaryResource.Name, total, hpa == "", err)
+		}
+	}
+	subject.Log = "Promoting canary to " + resource.Name + " ..."
+	subject.EventData["log"] = subject.Log
+	subject.EventData["phase"] = "promote"
+	k.notifyAll(subject)
+	// stable is updated first and only then scaled back up, so replicas that come back run the new version.
+	if err := k.UpdateDeployment(resource); err != nil {
+		return k.abortCanary(resource, canaryResource.Name, total, hpa == "", err)
+	}
+	if hpa == "" {
+		err = k.scaleDeployment(resource.Namespace, resource.Name, total)
+		if err == nil {
+			stable, err = k.GetDeployment(resource.Name, resource.Namespace)
+		}
+		if err == nil {
+			err = k.waitForDeploymentRollout(resource, labels.FormatLabels(stable.Spec.Template.Labels), abort)
+		}
 		if err != nil {
-			gv = schema.GroupVersion{Version: version}
+			return k.abortCanary(resource, canaryResource.Name, total, true, err)
 		}
+	}
+	subject.Log = "Canary promoted, deleting " + canaryResource.Name
+	subject.EventData["log"] = subject.Log
+	subject.EventData["phase"] = "promoted"
+	k.notifyAll(subject)
+	if err := k.scaleDeployment(resource.Namespace, canaryResource.Name, 0); err != nil {
+		return err
+	}
+	return k.kcs.AppsV1().Deployments(resource.Namespace).Delete(context.Background(), canaryResource.Name, metaV1.DeleteOptions{})
+}
 
-		apiResourceList, err := k.discoveryClient.ServerResourcesForGroupVersion(version)
+// canaryTotal returns replicas stable and canary share, and name of the HorizontalPodAutoscaler targeting stable if any.
+// Requested replicas are bounded by the autoscaler the way applyReplicas does.
+func (k k8sService) canaryTotal(resource v1.Resource, stable *appsV1.Deployment) (int32, string, error) {
+	if resource.Replica > 0 {
+		diff, err := k.replicaDiff(resource, stable.Spec.Replicas)
 		if err != nil {
-			return false, err
+			return 0, "", err
 		}
-		apiResources := apiResourceList.APIResources
-		var resource *metaV1.APIResource
-		for _, apiResource := range apiResources {
-			if apiResource.Kind == kind && !strings.Contains(apiResource.Name, "/") {
-				resource = &apiResource
-				break
-			}
+		return diff.Target, diff.HorizontalPodAutoscaler, nil
+	}
+	total := int32(1)
+	if stable.Spec.Replicas != nil {
+		total = *stable.Spec.Replicas
+	}
+	hpa, err := k.findHorizontalPodAutoscaler(resource)
+	if err != nil || hpa == nil {
+		return total, "", err
+	}
+	if hpa.Spec.MinReplicas != nil && total < *hpa.Spec.MinReplicas {
+		total = *hpa.Spec.MinReplicas
+	}
+	if total > hpa.Spec.MaxReplicas {
+		total = hpa.Spec.MaxReplicas
+	}
+	return total, hpa.Name, nil
+}
+
+// pauseCanaryStep holds canary at current step, failing if canary pods become unhealthy or delivery gets aborted.
+func (k k8sService) pauseCanaryStep(resource v1.Resource, canarySelector string, pause time.Duration, abort <-chan struct{}) error {
+	deadline := time.After(pause)
+	ticker := time.NewTicker(time.Second * 5)
+	defer ticker.Stop()
+	for {
+		if err := k.checkPodsHealth(resource.Namespace, canarySelector); err != nil {
+			return err
 		}
-		if resource == nil {
-			return false, fmt.Errorf("unknown resource kind: %s", kind)
+		select {
+		case <-abort:
+			return errStrategyAborted
+		case <-deadline:
+			return nil
+		case <-ticker.C:
 		}
+	}
+}
+
+// abortCanary restores stable replicas, unless an autoscaler owns them, and deletes the canary deployment.
+func (k k8sService) abortCanary(resource v1.Resource, canaryName string, total int32, scaleStable bool, cause error) error {
+	subject := newStrategySubject(resource, "Canary deployment failed, restoring "+resource.Name+". Reason: "+cause.Error(), "abort")
+	k.notifyAll(subject)
+	var err error
+	if scaleStable {
+		err = k.scaleDeployment(resource.Namespace, resource.Name, total)
+	}
+	if err == nil {
+		err = k.kcs.AppsV1().Deployments(resource.Namespace).Delete(context.Background(), canaryName, metaV1.DeleteOptions{})
+	}
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		return fmt.Errorf("%s, abort failed: %s", cause.Error(), err.Error())
+	}
+	return rolledBackError{err: cause}
+}
+
+const (
+	slotLabel  = "klovercloud_ci_slot"
+	trackLabel = "klovercloud_ci_track"
+)
+
+// newVariantDeployment returns a parallel deployment of source named name, whose selector and pods are distinguished by variantLabel.
+// Containers keep images of source.
+func (k k8sService) newVariantDeployment(resource v1.Resource, source *appsV1.Deployment, name, variantLabel, variant string) *appsV1.Deployment {
+	deployment := &appsV1.Deployment{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:        name,
+			Namespace:   source.Namespace,
+			Labels:      make(map[string]string),
+			Annotations: make(map[string]string),
+		},
+		Spec: *source.Spec.DeepCopy(),
+	}
+	for key, value := range source.Labels {
+		deployment.Labels[key] = value
+	}
+	deployment.Labels[variantLabel] = variant
+	deployment.Labels["company"] = resource.Pipeline.MetaData.CompanyId
+	deployment.Labels["klovercloud_ci"] = "enabled"
+	deployment.Labels["process_id"] = resource.ProcessId
+	deployment.Labels["claim"] = strconv.Itoa(resource.Claim)
+	if deployment.Spec.Selector == nil {
+		deployment.Spec.Selector = &metaV1.LabelSelector{}
+	}
+	if deployment.Spec.Selector.MatchLabels == nil {
+		deployment.Spec.Selector.MatchLabels = make(map[string]string)
+	}
+	deployment.Spec.Selector.MatchLabels[variantLabel] = variant
+	if deployment.Spec.Template.Labels == nil {
+		deployment.Spec.Template.Labels = make(map[string]string)
+	}
+	deployment.Spec.Template.Labels[variantLabel] = variant
+	deployment.Spec.Template.Labels["klovercloud_ci"] = "enabled"
+	deployment.Spec.Template.Labels["company"] = resource.Pipeline.MetaData.CompanyId
+	deployment.Spec.Template.Labels["claim"] = strconv.Itoa(resource.Claim)
+	deployment.Spec.Template.Labels["process_id"] = resource.ProcessId
+	delete(deployment.Labels, retiredLabel)
+	return deployment
+}
+
+// createOrPatchDeployment creates the deployment or patches the existing one, keeping its immutable selector.
+func (k k8sService) createOrPatchDeployment(deployment *appsV1.Deployment) (*appsV1.Deployment, error) {
+	existing, err := k.GetDeployment(deployment.Name, deployment.Namespace)
+	if k8sErrors.IsNotFound(err) {
+		return k.kcs.AppsV1().Deployments(deployment.Namespace).Create(context.Background(), deployment, metaV1.CreateOptions{FieldManager: config.FieldManager})
+	}
+	if err != nil {
+		return nil, err
+	}
+	mod := existing.DeepCopy()
+	mod.Labels = deployment.Labels
+	// a slot deployed again is no longer retired.
+	delete(mod.Annotations, scaleDownAtAnnotation)
+	mod.Spec.Replicas = deployment.Spec.Replicas
+	mod.Spec.Template = deployment.Spec.Template
+	return k.PatchDeploymentObject(false, existing, mod)
+}
+
+// scaleDeployment sets replicas of the deployment through scale subresource.
+func (k k8sService) scaleDeployment(namespace, name string, replicas int32) error {
+	return k.scaleWorkload(enums.DEPLOYMENT, namespace, name, replicas)
+}
 
-		groupVersionResource := schema.GroupVersionResource{Group: gv.Group, Version: gv.Version, Resource: resource.Name}
-		namespace := "default"
-		if strings.Compare(data.GetNamespace(), "_all") == 0 {
-			namespace = data.GetNamespace()
+// scaleWorkload sets replicas of a deployment, statefulSet or replicaset through scale subresource.
+func (k k8sService) scaleWorkload(resourceType enums.RESOURCE_TYPE, namespace, name string, replicas int32) error {
+	var getScale func(ctx context.Context, name string, options metaV1.GetOptions) (*autoscalingV1.Scale, error)
+	var updateScale func(ctx context.Context, name string, scale *autoscalingV1.Scale, opts metaV1.UpdateOptions) (*autoscalingV1.Scale, error)
+	switch resourceType {
+	case enums.DEPLOYMENT:
+		getScale = k.kcs.AppsV1().Deployments(namespace).GetScale
+		updateScale = k.kcs.AppsV1().Deployments(namespace).UpdateScale
+	case enums.STATEFULSET:
+		getScale = k.kcs.AppsV1().StatefulSets(namespace).GetScale
+		updateScale = k.kcs.AppsV1().StatefulSets(namespace).UpdateScale
+	case enums.REPLICASET:
+		getScale = k.kcs.AppsV1().ReplicaSets(namespace).GetScale
+		updateScale = k.kcs.AppsV1().ReplicaSets(namespace).UpdateScale
+	default:
+		return fmt.Errorf("scaling is not supported for resource type: %s", resourceType)
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		scale, err := getScale(context.Background(), name, metaV1.GetOptions{})
+		if err != nil {
+			return err
 		}
+		if scale.Spec.Replicas == replicas {
+			return nil
+		}
+		scale.Spec.Replicas = replicas
+		_, err = updateScale(context.Background(), name, scale, metaV1.UpdateOptions{})
+		return err
+	})
+}
 
-		if resource.Namespaced {
-			_, err = k.dynamicClient.Resource(groupVersionResource).Namespace(namespace).Create(context.Background(), data, metaV1.CreateOptions{})
+// applyReplicas applies requested replica count of the resource. If a HorizontalPodAutoscaler targets the workload,
+// its bounds are adjusted instead, so that the agent and the autoscaler do not fight over replicas.
+func (k k8sService) applyReplicas(resource v1.Resource, subject v1.Subject) error {
+	hpa, err := k.findHorizontalPodAutoscaler(resource)
+	if err != nil {
+		return err
+	}
+	if hpa != nil {
+		if resource.Replica < 1 {
+			return errors.New("can not scale " + resource.Name + " to zero while HorizontalPodAutoscaler " + hpa.Name + " targets it")
+		}
+		subject.Log = fmt.Sprintf("HorizontalPodAutoscaler %s targets %s, setting min replicas to %d ...", hpa.Name, resource.Name, resource.Replica)
+		subject.EventData["log"] = subject.Log
+		k.notifyAll(subject)
+		return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			current, err := k.kcs.AutoscalingV1().HorizontalPodAutoscalers(hpa.Namespace).Get(context.Background(), hpa.Name, metaV1.GetOptions{})
 			if err != nil {
-				_, err = k.dynamicClient.Resource(groupVersionResource).Namespace(namespace).Update(context.Background(), data, metaV1.UpdateOptions{})
+				return err
 			}
-		} else {
-			_, err = k.dynamicClient.Resource(groupVersionResource).Create(context.Background(), data, metaV1.CreateOptions{})
-			if err != nil {
-				_, err = k.dynamicClient.Resource(groupVersionResource).Namespace(namespace).Update(context.Background(), data, metaV1.UpdateOptions{})
+			minReplicas := resource.Replica
+			current.Spec.MinReplicas = &minReplicas
+			if current.Spec.MaxReplicas < resource.Replica {
+				current.Spec.MaxReplicas = resource.Replica
 			}
+			_, err = k.kcs.AutoscalingV1().HorizontalPodAutoscalers(hpa.Namespace).Update(context.Background(), current, metaV1.UpdateOptions{})
+			return err
+		})
+	}
+	subject.Log = fmt.Sprintf("Scaling %s to %d replicas ...", resource.Name, resource.Replica)
+	subject.EventData["log"] = subject.Log
+	k.notifyAll(subject)
+	return k.scaleWorkload(resource.Type, resource.Namespace, resource.Name, resource.Replica)
+}
+
+// replicaDiff returns replicas the workload would have once requested replica count of the resource is applied. If a
+// HorizontalPodAutoscaler targets the workload, applyReplicas raises its bounds instead, so the autoscaler keeps current
+// replicas within them.
+func (k k8sService) replicaDiff(resource v1.Resource, current *int32) (*v1.ReplicaDiff, error) {
+	hpa, err := k.findHorizontalPodAutoscaler(resource)
+	if err != nil {
+		return nil, err
+	}
+	return replicaDiffOf(resource, current, hpa)
+}
+
+// replicaDiffOf returns replica diff of the resource, for the HorizontalPodAutoscaler targeting it, if hpa is not nil.
+func replicaDiffOf(resource v1.Resource, current *int32, hpa *autoscalingV1.HorizontalPodAutoscaler) (*v1.ReplicaDiff, error) {
+	diff := &v1.ReplicaDiff{Current: 1, Target: resource.Replica}
+	if current != nil {
+		diff.Current = *current
+	}
+	if hpa == nil {
+		return diff, nil
+	}
+	if resource.Replica < 1 {
+		return nil, errors.New("can not scale " + resource.Name + " to zero while HorizontalPodAutoscaler " + hpa.Name + " targets it")
+	}
+	diff.HorizontalPodAutoscaler = hpa.Name
+	maxReplicas := hpa.Spec.MaxReplicas
+	if maxReplicas < resource.Replica {
+		maxReplicas = resource.Replica
+	}
+	diff.Target = diff.Current
+	if diff.Target < resource.Replica {
+		diff.Target = resource.Replica
+	} else if diff.Target > maxReplicas {
+		diff.Target = maxReplicas
+	}
+	return diff, nil
+}
+
+// findHorizontalPodAutoscaler returns HorizontalPodAutoscaler targeting the resource, nil if there is none.
+func (k k8sService) findHorizontalPodAutoscaler(resource v1.Resource) (*autoscalingV1.HorizontalPodAutoscaler, error) {
+	kind := ""
+	switch resource.Type {
+	case enums.DEPLOYMENT:
+		kind = "Deployment"
+	case enums.STATEFULSET:
+		kind = "StatefulSet"
+	case enums.REPLICASET:
+		kind = "ReplicaSet"
+	default:
+		return nil, nil
+	}
+	hpaList, err := k.kcs.AutoscalingV1().HorizontalPodAutoscalers(resource.Namespace).List(context.Background(), metaV1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i, each := range hpaList.Items {
+		if each.Spec.ScaleTargetRef.Kind == kind && each.Spec.ScaleTargetRef.Name == resource.Name {
+			return &hpaList.Items[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// DiffScale returns replica change scaling the resource would make, without scaling anything.
+func (k k8sService) DiffScale(resource v1.Resource) v1.ResourceDiff {
+	diff := v1.ResourceDiff{ApiVersion: "apps/v1", Name: resource.Name, Namespace: resource.Namespace}
+	var current *int32
+	switch resource.Type {
+	case enums.DEPLOYMENT:
+		deployment, err := k.GetDeployment(resource.Name, resource.Namespace)
+		if err != nil {
+			diff.Error = err.Error()
+			return diff
 		}
+		diff.Kind, current = "Deployment", deployment.Spec.Replicas
+	case enums.STATEFULSET:
+		statefulSet, err := k.GetStatefulSet(resource.Name, resource.Namespace)
 		if err != nil {
-			return false, err
+			diff.Error = err.Error()
+			return diff
+		}
+		diff.Kind, current = "StatefulSet", statefulSet.Spec.Replicas
+	case enums.REPLICASET:
+		replicaSet, err := k.kcs.AppsV1().ReplicaSets(resource.Namespace).Get(context.Background(), resource.Name, metaV1.GetOptions{})
+		if err != nil {
+			diff.Error = err.Error()
+			return diff
+		}
+		diff.Kind, current = "ReplicaSet", replicaSet.Spec.Replicas
+	default:
+		diff.Error = fmt.Sprintf("scaling is not supported for resource type: %s", resource.Type)
+		return diff
+	}
+	replicas, err := k.replicaDiff(resource, current)
+	if err != nil {
+		diff.Error = err.Error()
+		return diff
+	}
+	diff.Replicas = replicas
+	diff.Operation = enums.OBJECT_UNCHANGED
+	if replicas.Target != replicas.Current {
+		diff.Operation = enums.OBJECT_UPDATED
+		diff.Patch = map[string]interface{}{"spec": map[string]interface{}{"replicas": replicas.Target}}
+	}
+	return diff
+}
+
+func (k k8sService) ScaleWorkload(resource v1.Resource) error {
+	subject := v1.Subject{Step: resource.Step, Log: "Initiating  Scaling ...", Name: resource.Name, Namespace: resource.Namespace, ProcessId: resource.ProcessId, Pipeline: resource.Pipeline}
+	subject.EventData = make(map[string]interface{})
+	subject.EventData["footmark"] = enums.UPDATE_RESOURCE
+	subject.EventData["log"] = subject.Log
+	subject.EventData["reason"] = "n/a"
+	subject.EventData["status"] = enums.INITIALIZING
+	subject.EventData["step"] = resource.Step
+	subject.EventData["process_id"] = resource.ProcessId
+	subject.EventData["company_id"] = resource.Pipeline.MetaData.CompanyId
+	subject.EventData["claim"] = strconv.Itoa(resource.Claim)
+	k.notifyAll(subject)
+	subject.EventData["status"] = enums.PROCESSING
+	if err := k.applyReplicas(resource, subject); err != nil {
+		return err
+	}
+	subject.Log = "Waiting for scaling to complete ..."
+	subject.EventData["log"] = subject.Log
+	subject.EventData["footmark"] = enums.POST_AGENT_JOB
+	k.notifyAll(subject)
+	var err error
+	switch resource.Type {
+	case enums.DEPLOYMENT:
+		var deploy *appsV1.Deployment
+		if deploy, err = k.GetDeployment(resource.Name, resource.Namespace); err == nil {
+			err = k.WaitForDeploymentRollout(resource, labels.FormatLabels(deploy.Spec.Template.Labels))
+		}
+	case enums.STATEFULSET:
+		var statefulSet *appsV1.StatefulSet
+		if statefulSet, err = k.GetStatefulSet(resource.Name, resource.Namespace); err == nil {
+			err = k.WaitForStatefulSetRollout(resource, labels.FormatLabels(statefulSet.Spec.Template.Labels))
 		}
-		return true, nil
+	case enums.REPLICASET:
+		var replicaSet *appsV1.ReplicaSet
+		if replicaSet, err = k.kcs.AppsV1().ReplicaSets(resource.Namespace).Get(context.Background(), resource.Name, metaV1.GetOptions{}); err == nil {
+			err = k.waitForReplicaSetRollout(resource, labels.FormatLabels(replicaSet.Spec.Template.Labels))
+		}
+	}
+	if err != nil {
+		return err
+	}
+	subject.Log = "Scaled Successfully"
+	subject.EventData["log"] = subject.Log
+	subject.EventData["status"] = enums.SUCCESSFUL
+	k.notifyAll(subject)
+	return nil
+}
+
+func (k k8sService) patchServiceSelector(svc *coreV1.Service, slot string) error {
+	patch, err := json.Marshal(map[string]interface{}{"spec": map[string]interface{}{"selector": map[string]string{slotLabel: slot}}})
+	if err != nil {
+		return err
 	}
+	_, err = k.kcs.CoreV1().Services(svc.Namespace).Patch(context.Background(), svc.Name, types.MergePatchType, patch, metaV1.PatchOptions{})
+	return err
+}
+
+func (k k8sService) restoreServiceSelector(namespace, name string, selector map[string]string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		svc, err := k.kcs.CoreV1().Services(namespace).Get(context.Background(), name, metaV1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		svc.Spec.Selector = selector
+		_, err = k.kcs.CoreV1().Services(namespace).Update(context.Background(), svc, metaV1.UpdateOptions{})
+		return err
+	})
+}
+
+func newStrategySubject(resource v1.Resource, message, phase string) v1.Subject {
+	subject := v1.Subject{Step: resource.Step, Log: message, Name: resource.Name, Namespace: resource.Namespace, ProcessId: resource.ProcessId, Pipeline: resource.Pipeline}
+	subject.EventData = make(map[string]interface{})
+	subject.EventData["footmark"] = enums.PROGRESSIVE_DELIVERY
+	subject.EventData["log"] = message
+	subject.EventData["reason"] = "n/a"
+	subject.EventData["status"] = enums.PROCESSING
+	subject.EventData["strategy"] = resource.Strategy.Type
+	subject.EventData["phase"] = phase
+	subject.EventData["step"] = resource.Step
+	subject.EventData["process_id"] = resource.ProcessId
+	subject.EventData["company_id"] = resource.Pipeline.MetaData.CompanyId
+	subject.EventData["claim"] = strconv.Itoa(resource.Claim)
+	return subject
 }
 
-func (k k8sService) UpdateDeployment(resource v1.Resource) error {
-	subject := v1.Subject{resource.Step, "Initiating  Deployment ...", resource.Name, resource.Namespace, resource.ProcessId, nil, nil, resource.Pipeline}
+// verify runs post deploy verification of the resource, if any is requested. selector matches pods of the resource.
+func (k k8sService) verify(resource v1.Resource, selector string) error {
+	if resource.Verification == nil {
+		return nil
+	}
+	subject := v1.Subject{Step: resource.Step, Log: "Verifying " + resource.Name + " ...", Name: resource.Name, Namespace: resource.Namespace, ProcessId: resource.ProcessId, Pipeline: resource.Pipeline}
 	subject.EventData = make(map[string]interface{})
-	subject.EventData["footmark"] = enums.UPDATE_RESOURCE
+	subject.EventData["footmark"] = enums.VERIFY_RESOURCE
 	subject.EventData["log"] = subject.Log
 	subject.EventData["reason"] = "n/a"
-	subject.EventData["status"] = enums.INITIALIZING
+	subject.EventData["status"] = enums.PROCESSING
 	subject.EventData["step"] = resource.Step
 	subject.EventData["process_id"] = resource.ProcessId
 	subject.EventData["company_id"] = resource.Pipeline.MetaData.CompanyId
 	subject.EventData["claim"] = strconv.Itoa(resource.Claim)
-	go k.notifyAll(subject)
-	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		subject.Log = "Applying Deployment ..."
+	k.notifyAll(subject)
+	var err error
+	if resource.Verification.Readiness != nil {
+		err = k.verifyReadiness(resource, selector, subject)
+	}
+	if err == nil && resource.Verification.Http != nil {
+		err = k.verifyHttp(resource, subject)
+	}
+	if err == nil && resource.Verification.Job != nil {
+		err = k.verifyJob(resource, subject)
+	}
+	if err != nil {
+		subject.Log = "Verification failed: " + err.Error()
 		subject.EventData["log"] = subject.Log
-		subject.EventData["footmark"] = enums.UPDATE_RESOURCE
-		subject.EventData["status"] = enums.PROCESSING
-		go k.notifyAll(subject)
-		result, getErr := k.GetDeployment(resource.Name, resource.Namespace)
-		if getErr != nil {
-			log.Println("Failed to get latest version of Deployment: ", getErr)
-			subject.Log = "Failed to get latest version of Deployment: " + getErr.Error()
-			subject.EventData["log"] = subject.Log
-			subject.EventData["footmark"] = enums.POST_AGENT_JOB
-			subject.EventData["status"] = enums.DEPLOYMENT_FAILED
-			go k.notifyAll(subject)
-			return getErr
+		subject.EventData["reason"] = err.Error()
+		k.notifyAll(subject)
+		return errors.New("verification failed: " + err.Error())
+	}
+	subject.Log = "Verified Successfully"
+	subject.EventData["log"] = subject.Log
+	k.notifyAll(subject)
+	return nil
+}
+
+// verifyReadiness checks that every pod matching the selector stays ready, without restarts, for the whole window.
+func (k k8sService) verifyReadiness(resource v1.Resource, selector string, subject v1.Subject) error {
+	window := time.Duration(resource.Verification.Readiness.WindowSeconds) * time.Second
+	subject.Log = "Checking pod readiness for " + window.String() + " ..."
+	subject.EventData["log"] = subject.Log
+	k.notifyAll(subject)
+	restarts := make(map[string]int32)
+	deadline := time.After(window)
+	ticker := time.NewTicker(time.Second * 3)
+	defer ticker.Stop()
+	for {
+		pods, err := k.listPods(resource.Namespace, selector)
+		if err != nil {
+			return err
 		}
-		for i, each := range resource.Images {
-			if i > len(result.Spec.Template.Spec.Containers)-1 {
-				subject.Log = "[WARNING]index out of bound! ignoring container for " + each
-				subject.EventData["log"] = subject.Log
-				subject.EventData["footmark"] = enums.UPDATE_RESOURCE
-				go k.notifyAll(subject)
-			} else {
-				result.Spec.Template.Spec.Containers[i].Image = each
+		if len(pods) == 0 {
+			return errors.New("no pod found with selector " + selector)
+		}
+		for i, pod := range pods {
+			if err := checkPodHealth(&pods[i]); err != nil {
+				return err
+			}
+			if pod.DeletionTimestamp != nil {
+				continue
+			}
+			if !isPodReady(&pod) {
+				return errors.New("Pod " + pod.Name + " is not ready")
+			}
+			for _, each := range pod.Status.ContainerStatuses {
+				key := pod.Name + "/" + each.Name
+				if count, ok := restarts[key]; ok && each.RestartCount > count {
+					return errors.New("Pod " + pod.Name + " container " + each.Name + " restarted")
+				}
+				restarts[key] = each.RestartCount
 			}
 		}
-		listOptions := metaV1.ListOptions{LabelSelector: labels.FormatLabels(result.Spec.Template.Labels)}
-		podList, err := k.kcs.CoreV1().Pods(resource.Namespace).List(context.TODO(), listOptions)
-		if err != nil {
-			subject.Log = "[WARNING]Failed to list Existing Pods!"
-			subject.EventData["log"] = err.Error()
-			subject.EventData["footmark"] = enums.POST_AGENT_JOB
-			go k.notifyAll(subject)
+		select {
+		case <-deadline:
+			return nil
+		case <-ticker.C:
 		}
-		existingPodName := make(map[string]bool)
+	}
+}
 
-		for _, each := range podList.Items {
-			existingPodName[each.Name] = true
-		}
-		prev, _ := k.GetDeployment(resource.Name, resource.Namespace)
-		if result.Labels == nil {
-			result.Labels = make(map[string]string)
+func isPodReady(pod *coreV1.Pod) bool {
+	for _, each := range pod.Status.Conditions {
+		if each.Type == coreV1.PodReady {
+			return each.Status == coreV1.ConditionTrue
 		}
-		result.Labels["company"] = resource.Pipeline.MetaData.CompanyId
-		result.Labels["klovercloud_ci"] = "enabled"
-		result.Labels["process_id"] = resource.ProcessId
-		result.Labels["claim"] = strconv.Itoa(resource.Claim)
-		result.Spec.Template.Labels["klovercloud_ci"] = "enabled"
-		result.Spec.Template.Labels["claim"] = strconv.Itoa(resource.Claim)
-		result.Spec.Template.Labels["process_id"] = resource.ProcessId
-		result.Spec.Template.Labels["company"] = resource.Pipeline.MetaData.CompanyId
-		deploy, updateErr := k.PatchDeploymentObject(resource.RolloutRestart, prev, result)
-		if updateErr != nil {
-			subject.Log = updateErr.Error()
+	}
+	return false
+}
+
+// verifyHttp probes the service through api server proxy until it responds with a 2xx status or retries are exhausted.
+func (k k8sService) verifyHttp(resource v1.Resource, subject v1.Subject) error {
+	probe := resource.Verification.Http
+	scheme := probe.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	retries := probe.Retries
+	if retries < 1 {
+		retries = 1
+	}
+	interval := time.Duration(probe.IntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = time.Second * 5
+	}
+	var err error
+	for attempt := 1; attempt <= retries; attempt++ {
+		subject.Log = fmt.Sprintf("Probing %s://%s:%s%s (attempt %d of %d) ...", scheme, probe.Service, probe.Port, probe.Path, attempt, retries)
+		subject.EventData["log"] = subject.Log
+		k.notifyAll(subject)
+		_, err = k.kcs.CoreV1().Services(resource.Namespace).ProxyGet(scheme, probe.Service, probe.Port, probe.Path, nil).DoRaw(context.Background())
+		if err == nil {
+			return nil
 		}
-		if updateErr == nil && *deploy.Spec.Replicas > 0 {
-			subject.Log = "Waiting until pod is ready!"
-			subject.EventData["log"] = subject.Log
-			subject.EventData["footmark"] = enums.POST_AGENT_JOB
-			go k.notifyAll(subject)
-			err := k.WaitForPodBySelectorUntilRunning(resource.Pipeline.MetaData.CompanyId, resource.Step, deploy.Name, deploy.Namespace, resource.ProcessId, labels.FormatLabels(deploy.Spec.Template.Labels),*prev.Spec.Replicas, existingPodName, resource.Claim)
-			if err != nil {
-				return err
-			}
+		if attempt < retries {
+			time.Sleep(interval)
 		}
-		return updateErr
-	})
-	if retryErr != nil {
-		return retryErr
 	}
+	return err
+}
 
-	subject.Log = "Updated Successfully"
+// verifyJob runs a smoke test job and waits for its completion. The job is deleted afterwards.
+func (k k8sService) verifyJob(resource v1.Resource, subject v1.Subject) error {
+	smokeTest := resource.Verification.Job
+	var backoffLimit int32
+	job := &batchV1.Job{
+		ObjectMeta: metaV1.ObjectMeta{
+			GenerateName: resource.Name + "-verify-",
+			Namespace:    resource.Namespace,
+			Labels: map[string]string{
+				"company":        resource.Pipeline.MetaData.CompanyId,
+				"klovercloud_ci": "enabled",
+				"process_id":     resource.ProcessId,
+				"claim":          strconv.Itoa(resource.Claim),
+			},
+		},
+		Spec: batchV1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: coreV1.PodTemplateSpec{
+				Spec: coreV1.PodSpec{
+					RestartPolicy: coreV1.RestartPolicyNever,
+					Containers: []coreV1.Container{{
+						Name:    "verify",
+						Image:   smokeTest.Image,
+						Command: smokeTest.Command,
+						Args:    smokeTest.Args,
+					}},
+				},
+			},
+		},
+	}
+	for key, value := range smokeTest.Env {
+		job.Spec.Template.Spec.Containers[0].Env = append(job.Spec.Template.Spec.Containers[0].Env, coreV1.EnvVar{Name: key, Value: value})
+	}
+	if smokeTest.Timeout > 0 {
+		job.Spec.ActiveDeadlineSeconds = &smokeTest.Timeout
+	}
+	job, err := k.kcs.BatchV1().Jobs(resource.Namespace).Create(context.Background(), job, metaV1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+	subject.Log = "Running smoke test job " + job.Name + " ..."
 	subject.EventData["log"] = subject.Log
-	subject.EventData["footmark"] = enums.POST_AGENT_JOB
-	subject.EventData["status"] = enums.SUCCESSFUL
-	go k.notifyAll(subject)
-	return nil
+	k.notifyAll(subject)
+	err = k.waitForJobCompletion(resource, job)
+	propagation := metaV1.DeletePropagationBackground
+	if deleteErr := k.kcs.BatchV1().Jobs(job.Namespace).Delete(context.Background(), job.Name, metaV1.DeleteOptions{PropagationPolicy: &propagation}); deleteErr != nil {
+		log.Println(deleteErr.Error())
+	}
+	return err
 }
 
-func (k k8sService) PatchDeploymentObject(rolloutRestart bool, cur, mod *appsV1.Deployment) (*appsV1.Deployment, error) {
-	curJson, err := json.Marshal(cur)
+// pruneProtectionAnnotation set "disabled" on a live object to keep it from being pruned.
+const pruneProtectionAnnotation = "ci.klovercloud.com/prune"
+
+// inventoryObject reference of an object applied by a pipeline step.
+type inventoryObject struct {
+	ApiVersion string `json:"api_version"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace"`
+	Name       string `json:"name"`
+}
+
+func (i inventoryObject) key() string {
+	gv, _ := schema.ParseGroupVersion(i.ApiVersion)
+	return gv.Group + "/" + i.Kind + "/" + i.Namespace + "/" + i.Name
+}
+
+// inventoryName returns name of the inventory configMap of the pipeline step.
+func inventoryName(resource v1.Resource) string {
+	hash := sha1.Sum([]byte(resource.Pipeline.MetaData.CompanyId + "/" + resource.Pipeline.Name + "/" + resource.Step))
+	return "klovercloud-ci-inventory-" + hex.EncodeToString(hash[:])[:16]
+}
+
+func (k k8sService) getInventory(resource v1.Resource) ([]inventoryObject, error) {
+	configMap, err := k.kcs.CoreV1().ConfigMaps(config.InventoryNamespace).Get(context.Background(), inventoryName(resource), metaV1.GetOptions{})
+	if k8sErrors.IsNotFound(err) {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
-	if rolloutRestart {
-		if mod.Spec.Template.ObjectMeta.Annotations == nil {
-			mod.Spec.Template.ObjectMeta.Annotations = make(map[string]string)
-		}
-		mod.Spec.Template.ObjectMeta.Annotations["kubectl.kubernetes.io/restartedAt"] = time.Now().Format(time.RFC3339)
-		mod.Annotations["kubectl.kubernetes.io/restartedAt"] = time.Now().Format(time.RFC3339)
+	var inventory []inventoryObject
+	if err := json.Unmarshal([]byte(configMap.Data["inventory"]), &inventory); err != nil {
+		return nil, err
 	}
-	modJson, err := json.Marshal(mod)
+	return inventory, nil
+}
+
+func (k k8sService) saveInventory(resource v1.Resource, inventory []inventoryObject) error {
+	data, err := json.Marshal(inventory)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	configMap := &coreV1.ConfigMap{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      inventoryName(resource),
+			Namespace: config.InventoryNamespace,
+			Labels:    ciLabels(resource),
+			Annotations: map[string]string{
+				"ci.klovercloud.com/pipeline": resource.Pipeline.Name,
+				"ci.klovercloud.com/step":     resource.Step,
+			},
+		},
+		Data: map[string]string{"inventory": string(data)},
 	}
-	patch, err := strategicpatch.CreateTwoWayMergePatch(curJson, modJson, appsV1.Deployment{})
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current, err := k.kcs.CoreV1().ConfigMaps(configMap.Namespace).Get(context.Background(), configMap.Name, metaV1.GetOptions{})
+		if k8sErrors.IsNotFound(err) {
+			_, err = k.kcs.CoreV1().ConfigMaps(configMap.Namespace).Create(context.Background(), configMap, metaV1.CreateOptions{FieldManager: config.FieldManager})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		current.Labels = configMap.Labels
+		current.Annotations = mergeLabels(current.Annotations, configMap.Annotations)
+		current.Data = configMap.Data
+		_, err = k.kcs.CoreV1().ConfigMaps(current.Namespace).Update(context.Background(), current, metaV1.UpdateOptions{FieldManager: config.FieldManager})
+		return err
+	})
+}
+
+// staleObjects returns objects of the inventory that are not among applied descriptors anymore.
+func (k k8sService) staleObjects(resource v1.Resource, applied []unstructured.Unstructured) ([]inventoryObject, []inventoryObject, error) {
+	previous, err := k.getInventory(resource)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	if len(patch) == 0 || string(patch) == "{}" {
-		return cur, nil
+	current := make([]inventoryObject, 0, len(applied))
+	keys := make(map[string]bool)
+	for _, each := range applied {
+		object := inventoryObject{ApiVersion: each.GetAPIVersion(), Kind: each.GetKind(), Namespace: each.GetNamespace(), Name: each.GetName()}
+		current = append(current, object)
+		keys[object.key()] = true
 	}
-	out, err := k.kcs.AppsV1().Deployments(cur.Namespace).Patch(context.TODO(), cur.Name, types.StrategicMergePatchType, patch, metaV1.PatchOptions{})
-	return out, err
+	var stale []inventoryObject
+	for _, each := range previous {
+		if !keys[each.key()] {
+			stale = append(stale, each)
+		}
+	}
+	return current, stale, nil
 }
 
-func (k k8sService) PatchStatefulSetObject(rolloutRestart bool, cur, mod *appsV1.StatefulSet) (*appsV1.StatefulSet, error) {
-	curJson, err := json.Marshal(cur)
+func (k k8sService) Prune(resource v1.Resource, applied []unstructured.Unstructured) error {
+	current, stale, err := k.staleObjects(resource, applied)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if rolloutRestart {
-		if mod.Spec.Template.ObjectMeta.Annotations == nil {
-			mod.Spec.Template.ObjectMeta.Annotations = make(map[string]string)
+	subject := v1.Subject{Step: resource.Step, Log: fmt.Sprintf("Pruning %d objects ...", len(stale)), Name: resource.Name, Namespace: resource.Namespace, ProcessId: resource.ProcessId, Pipeline: resource.Pipeline}
+	subject.EventData = make(map[string]interface{})
+	subject.EventData["footmark"] = enums.PRUNE_RESOURCE
+	subject.EventData["log"] = subject.Log
+	subject.EventData["reason"] = "n/a"
+	subject.EventData["status"] = enums.PROCESSING
+	subject.EventData["step"] = resource.Step
+	subject.EventData["process_id"] = resource.ProcessId
+	subject.EventData["company_id"] = resource.Pipeline.MetaData.CompanyId
+	subject.EventData["claim"] = strconv.Itoa(resource.Claim)
+	if len(stale) > 0 {
+		k.notifyAll(subject)
+	}
+	var failed []inventoryObject
+	for _, each := range stale {
+		message, err := k.pruneObject(each)
+		if err != nil {
+			failed = append(failed, each)
+			message = "Failed to prune " + each.Kind + " " + each.Name + ": " + err.Error()
 		}
-		mod.Spec.Template.ObjectMeta.Annotations["kubectl.kubernetes.io/restartedAt"] = time.Now().Format(time.RFC3339)
-		mod.Annotations["kubectl.kubernetes.io/restartedAt"] = time.Now().Format(time.RFC3339)
+		subject.Log = message
+		subject.EventData["log"] = subject.Log
+		k.notifyAll(subject)
 	}
-	modJson, err := json.Marshal(mod)
+	// objects that failed to be pruned stay in inventory to be retried on next run.
+	if err := k.saveInventory(resource, append(current, failed...)); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to prune %d of %d objects", len(failed), len(stale))
+	}
+	return nil
+}
+
+// pruneObject deletes the object unless it is protected, returning progress message.
+func (k k8sService) pruneObject(object inventoryObject) (string, error) {
+	if object.Kind == "Namespace" {
+		return "Namespace " + object.Name + " is never pruned, skipping", nil
+	}
+	resourceInterface, err := k.resourceInterfaceFor(object.ApiVersion, object.Kind, object.Namespace)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	live, err := resourceInterface.Get(context.Background(), object.Name, metaV1.GetOptions{})
+	if k8sErrors.IsNotFound(err) {
+		return object.Kind + " " + object.Name + " already deleted", nil
 	}
-	patch, err := strategicpatch.CreateTwoWayMergePatch(curJson, modJson, appsV1.StatefulSet{})
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	if len(patch) == 0 || string(patch) == "{}" {
-		return cur, nil
+	if live.GetAnnotations()[pruneProtectionAnnotation] == "disabled" {
+		return object.Kind + " " + object.Name + " is protected from pruning, skipping", nil
 	}
-	out, err := k.kcs.AppsV1().StatefulSets(cur.Namespace).Patch(context.TODO(), cur.Name, types.StrategicMergePatchType, patch, metaV1.PatchOptions{})
-	return out, err
+	propagation := metaV1.DeletePropagationBackground
+	err = resourceInterface.Delete(context.Background(), object.Name, metaV1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		return "", err
+	}
+	return "Pruned " + object.Kind + " " + object.Name, nil
 }
 
-func (k k8sService) UpdatePod(resource v1.Resource) error {
-	subject := v1.Subject{resource.Step, "", resource.Name, resource.Namespace, resource.ProcessId, map[string]interface{}{"footmark": enums.UPDATE_RESOURCE, "log": "Initiating  deployment ...", "reason": "n/a"}, nil, resource.Pipeline}
-	subject.EventData["status"] = enums.INITIALIZING
-	go k.notifyAll(subject)
-	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		result, getErr := k.GetPod(resource.Name, resource.Namespace)
-		if getErr != nil {
-			log.Println("Failed to get latest version of Pod: ", getErr)
-			subject.Log = "Failed to get latest version of Pod: " + getErr.Error()
-			subject.EventData["log"] = subject.Log
-			subject.EventData["footmark"] = enums.POST_AGENT_JOB
-			subject.EventData["status"] = enums.DEPLOYMENT_FAILED
-			go k.notifyAll(subject)
-			return getErr
+func (k k8sService) PrunePreview(resource v1.Resource, applied []unstructured.Unstructured) ([]v1.ResourceDiff, error) {
+	_, stale, err := k.staleObjects(resource, applied)
+	if err != nil {
+		return nil, err
+	}
+	diffs := []v1.ResourceDiff{}
+	for _, each := range stale {
+		diff := v1.ResourceDiff{ApiVersion: each.ApiVersion, Kind: each.Kind, Name: each.Name, Namespace: each.Namespace, Operation: enums.OBJECT_PRUNED}
+		if each.Kind == "Namespace" {
+			continue
 		}
-
-		for i, each := range resource.Images {
-			result.Spec.Containers[i].Image = each
+		resourceInterface, err := k.resourceInterfaceFor(each.ApiVersion, each.Kind, each.Namespace)
+		if err != nil {
+			diff.Error = err.Error()
+			diffs = append(diffs, diff)
+			continue
 		}
-
-		_, updateErr := k.kcs.CoreV1().Pods(resource.Namespace).Update(context.TODO(), result, metaV1.UpdateOptions{})
-		k.kcs.CoreV1().Pods(resource.Namespace).Watch(context.TODO(), metaV1.ListOptions{})
-
-		return updateErr
-	})
-	if retryErr != nil {
-		log.Println("Update failed: %v", retryErr)
-		return retryErr
+		live, err := resourceInterface.Get(context.Background(), each.Name, metaV1.GetOptions{})
+		if k8sErrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			diff.Error = err.Error()
+		} else if live.GetAnnotations()[pruneProtectionAnnotation] == "disabled" {
+			continue
+		}
+		diffs = append(diffs, diff)
 	}
-	return nil
+	return diffs, nil
 }
 
-func (k k8sService) UpdateStatefulSet(resource v1.Resource) error {
-	subject := v1.Subject{resource.Step, "Initiating  Deployment ...", resource.Name, resource.Namespace, resource.ProcessId, nil, nil, resource.Pipeline}
+// teardownTarget object to be deleted by a teardown.
+type teardownTarget struct {
+	object            inventoryObject
+	resourceInterface dynamic.ResourceInterface
+	deleted           bool
+}
+
+func (k k8sService) Teardown(resource v1.Resource) error {
+	subject := v1.Subject{Step: resource.Step, Log: "Initiating  Teardown ...", Name: resource.Name, Namespace: resource.Namespace, ProcessId: resource.ProcessId, Pipeline: resource.Pipeline}
 	subject.EventData = make(map[string]interface{})
-	subject.EventData["footmark"] = enums.UPDATE_RESOURCE
+	subject.EventData["footmark"] = enums.TEARDOWN_RESOURCE
 	subject.EventData["log"] = subject.Log
 	subject.EventData["reason"] = "n/a"
 	subject.EventData["status"] = enums.INITIALIZING
@@ -1437,116 +2683,341 @@ func (k k8sService) UpdateStatefulSet(resource v1.Resource) error {
 	subject.EventData["process_id"] = resource.ProcessId
 	subject.EventData["company_id"] = resource.Pipeline.MetaData.CompanyId
 	subject.EventData["claim"] = strconv.Itoa(resource.Claim)
-	go k.notifyAll(subject)
-	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		subject.Log = "Applying StatefulSet ..."
-		subject.EventData["log"] = subject.Log
-		subject.EventData["footmark"] = enums.UPDATE_RESOURCE
-		subject.EventData["status"] = enums.PROCESSING
-		go k.notifyAll(subject)
-		result, getErr := k.GetStatefulSet(resource.Name, resource.Namespace)
-		if getErr != nil {
-			log.Println("Failed to get latest version of StatefulSet: ", getErr)
-			subject.Log = "Failed to get latest version of StatefulSet: " + getErr.Error()
-			subject.EventData["log"] = subject.Log
-			subject.EventData["footmark"] = enums.POST_AGENT_JOB
-			subject.EventData["status"] = enums.DEPLOYMENT_FAILED
-			go k.notifyAll(subject)
-			return getErr
+	k.notifyAll(subject)
+	subject.EventData["status"] = enums.PROCESSING
+	propagation, err := teardownPropagation(resource)
+	if err != nil {
+		return err
+	}
+	targets, err := k.teardownTargets(resource)
+	if err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		return errors.New("nothing to tear down, neither descriptors nor selector matched any object")
+	}
+	timeout := config.RolloutTimeout
+	if resource.Teardown != nil && resource.Teardown.Timeout > 0 {
+		timeout = resource.Teardown.Timeout
+	}
+	failed := 0
+	for i, each := range targets {
+		subject.Log = fmt.Sprintf("Deleting %s %s (%d of %d) ...", each.object.Kind, objectPath(each.object), i+1, len(targets))
+		err := each.resourceInterface.Delete(context.Background(), each.object.Name, metaV1.DeleteOptions{PropagationPolicy: &propagation})
+		if k8sErrors.IsNotFound(err) {
+			targets[i].deleted = true
+			subject.Log = each.object.Kind + " " + objectPath(each.object) + " already deleted"
+		} else if err != nil {
+			targets[i].deleted = true
+			failed++
+			subject.Log = "Failed to delete " + each.object.Kind + " " + objectPath(each.object) + ": " + err.Error()
 		}
-		for i, each := range resource.Images {
-			if i > len(result.Spec.Template.Spec.Containers)-1 {
-				subject.Log = "[WARNING]index out of bound! ignoring container for " + each
+		subject.EventData["log"] = subject.Log
+		k.notifyAll(subject)
+	}
+	err = k.waitForRollout(resource, "", timeout, func() (bool, string, error) {
+		var remaining []string
+		for i, each := range targets {
+			if each.deleted {
+				continue
+			}
+			_, err := each.resourceInterface.Get(context.Background(), each.object.Name, metaV1.GetOptions{})
+			if k8sErrors.IsNotFound(err) {
+				targets[i].deleted = true
+				subject.Log = "Deleted " + each.object.Kind + " " + objectPath(each.object)
 				subject.EventData["log"] = subject.Log
-				subject.EventData["footmark"] = enums.UPDATE_RESOURCE
-				go k.notifyAll(subject)
-			} else {
-				result.Spec.Template.Spec.Containers[i].Image = each
+				k.notifyAll(subject)
+				continue
+			}
+			if err != nil {
+				return false, "", err
 			}
+			remaining = append(remaining, each.object.Kind+" "+objectPath(each.object))
+		}
+		if len(remaining) > 0 {
+			return false, fmt.Sprintf("Waiting for finalizers of %d objects: %s", len(remaining), strings.Join(remaining, ", ")), nil
 		}
+		return true, "All objects deleted", nil
+	})
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to delete %d of %d objects", failed, len(targets))
+	}
+	err = k.kcs.CoreV1().ConfigMaps(config.InventoryNamespace).Delete(context.Background(), inventoryName(resource), metaV1.DeleteOptions{})
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		log.Println(err.Error())
+	}
+	subject.Log = "Teardown completed"
+	subject.EventData["log"] = subject.Log
+	subject.EventData["status"] = enums.SUCCESSFUL
+	k.notifyAll(subject)
+	return nil
+}
 
-		listOptions := metaV1.ListOptions{LabelSelector: labels.FormatLabels(result.Spec.Template.Labels)}
-		podList, err := k.kcs.CoreV1().Pods(resource.Namespace).List(context.TODO(), listOptions)
-		if err != nil {
-			subject.Log = "[WARNING]Failed to list Existing Pods!"
-			subject.EventData["log"] = err.Error()
-			subject.EventData["footmark"] = enums.POST_AGENT_JOB
-			subject.EventData["status"] = enums.PROCESSING
-			go k.notifyAll(subject)
+// teardownPropagation returns propagation policy of the teardown, foreground unless one is requested.
+func teardownPropagation(resource v1.Resource) (metaV1.DeletionPropagation, error) {
+	if resource.Teardown == nil || resource.Teardown.PropagationPolicy == "" {
+		return metaV1.DeletePropagationForeground, nil
+	}
+	propagation := metaV1.DeletionPropagation(resource.Teardown.PropagationPolicy)
+	switch propagation {
+	case metaV1.DeletePropagationForeground, metaV1.DeletePropagationBackground, metaV1.DeletePropagationOrphan:
+		return propagation, nil
+	}
+	return "", fmt.Errorf("unsupported propagation policy: %s", resource.Teardown.PropagationPolicy)
+}
+
+// TeardownPreview returns objects a teardown of the resource would delete, without deleting anything.
+func (k k8sService) TeardownPreview(resource v1.Resource) ([]v1.ResourceDiff, error) {
+	if _, err := teardownPropagation(resource); err != nil {
+		return nil, err
+	}
+	targets, err := k.teardownTargets(resource)
+	if err != nil {
+		return nil, err
+	}
+	diffs := []v1.ResourceDiff{}
+	for _, each := range targets {
+		diff := v1.ResourceDiff{ApiVersion: each.object.ApiVersion, Kind: each.object.Kind, Name: each.object.Name, Namespace: each.object.Namespace, Operation: enums.OBJECT_DELETED}
+		_, err := each.resourceInterface.Get(context.Background(), each.object.Name, metaV1.GetOptions{})
+		if k8sErrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			diff.Error = err.Error()
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs, nil
+}
+
+// teardownTargets returns objects of descriptors and objects matching teardown selector, in reverse apply order. Selector is
+// only matched in the namespace of the resource, unless cluster scope is requested.
+func (k k8sService) teardownTargets(resource v1.Resource) ([]teardownTarget, error) {
+	var targets []teardownTarget
+	keys := make(map[string]bool)
+	add := func(object inventoryObject, resourceInterface dynamic.ResourceInterface) {
+		if object.Kind == "Namespace" && isProtectedNamespace(object.Name) || object.Namespace != "" && isProtectedNamespace(object.Namespace) {
+			return
 		}
-		existingPodRevisions := make(map[string]bool)
-		for _, each := range podList.Items {
-			existingPodRevisions[each.GetResourceVersion()] = true
+		if !keys[object.key()] {
+			keys[object.key()] = true
+			targets = append(targets, teardownTarget{object: object, resourceInterface: resourceInterface})
 		}
-		prev, _ := k.GetStatefulSet(resource.Name, resource.Namespace)
-
-		if result.Labels == nil {
-			result.Labels = make(map[string]string)
+	}
+	if resource.Descriptors != nil {
+		for _, each := range *resource.Descriptors {
+			groupVersionResource, namespaced, err := k.groupVersionResourceOf(each.GetAPIVersion(), each.GetKind())
+			if err != nil {
+				return nil, err
+			}
+			object := inventoryObject{ApiVersion: each.GetAPIVersion(), Kind: each.GetKind(), Name: each.GetName()}
+			if !namespaced {
+				add(object, k.dynamicClient.Resource(groupVersionResource))
+				continue
+			}
+			object.Namespace = each.GetNamespace()
+			if object.Namespace == "" {
+				object.Namespace = resource.Namespace
+			}
+			add(object, k.dynamicClient.Resource(groupVersionResource).Namespace(object.Namespace))
 		}
-		result.Labels["company"] = resource.Pipeline.MetaData.CompanyId
-		result.Labels["klovercloud_ci"] = "enabled"
-		result.Labels["process_id"] = resource.ProcessId
-		result.Labels["claim"] = strconv.Itoa(resource.Claim)
-		result.Labels["company"] = resource.Pipeline.MetaData.CompanyId
-		result.Spec.Template.Labels["klovercloud_ci"] = "enabled"
-		result.Spec.Template.Labels["company"] = resource.Pipeline.MetaData.CompanyId
-		result.Spec.Template.Labels["claim"] = strconv.Itoa(resource.Claim)
-		result.Spec.Template.Labels["process_id"] = resource.ProcessId
-		statefulSet, updateErr := k.PatchStatefulSetObject(resource.RolloutRestart, prev, result)
-		if updateErr != nil {
-			subject.Log = updateErr.Error()
+	}
+	if resource.Teardown != nil && len(resource.Teardown.Selector) > 0 {
+		selector := make(map[string]string)
+		for key, value := range resource.Teardown.Selector {
+			selector[key] = value
 		}
-		if updateErr == nil && *statefulSet.Spec.Replicas > 0 {
-			subject.Log = "Waiting until pod is ready!"
-			subject.EventData["log"] = subject.Log
-			subject.EventData["footmark"] = enums.POST_AGENT_JOB
-			subject.EventData["status"] = enums.PROCESSING
-			go k.notifyAll(subject)
-			err := k.WaitForPodBySelectorAndRevisionUntilRunning(resource.Pipeline.MetaData.CompanyId, resource.Step, statefulSet.Name, statefulSet.Namespace, resource.ProcessId, labels.FormatLabels(statefulSet.Spec.Template.Labels),*prev.Spec.Replicas, existingPodRevisions, resource.Claim)
+		// objects of other companies must never match.
+		selector["company"] = resource.Pipeline.MetaData.CompanyId
+		apiResourceLists, err := k.discoveryClient.ServerPreferredResources()
+		if err != nil && len(apiResourceLists) == 0 {
+			return nil, err
+		}
+		for _, apiResourceList := range apiResourceLists {
+			gv, err := schema.ParseGroupVersion(apiResourceList.GroupVersion)
 			if err != nil {
-				return err
+				continue
+			}
+			for _, apiResource := range apiResourceList.APIResources {
+				if strings.Contains(apiResource.Name, "/") || apiResource.Name == "events" || !hasVerbs(apiResource.Verbs, "list", "delete") {
+					continue
+				}
+				if !apiResource.Namespaced && !resource.Teardown.ClusterScope {
+					continue
+				}
+				var resourceInterface dynamic.ResourceInterface = k.dynamicClient.Resource(gv.WithResource(apiResource.Name))
+				if apiResource.Namespaced && !resource.Teardown.ClusterScope {
+					resourceInterface = k.dynamicClient.Resource(gv.WithResource(apiResource.Name)).Namespace(resource.Namespace)
+				}
+				list, err := resourceInterface.List(context.Background(), metaV1.ListOptions{LabelSelector: labels.FormatLabels(selector)})
+				if err != nil {
+					log.Println(err.Error())
+					continue
+				}
+				for _, item := range list.Items {
+					// owned objects are deleted by garbage collector along with their owners.
+					if len(item.GetOwnerReferences()) > 0 {
+						continue
+					}
+					object := inventoryObject{ApiVersion: apiResourceList.GroupVersion, Kind: apiResource.Kind, Namespace: item.GetNamespace(), Name: item.GetName()}
+					if apiResource.Namespaced {
+						add(object, k.dynamicClient.Resource(gv.WithResource(apiResource.Name)).Namespace(item.GetNamespace()))
+					} else {
+						add(object, resourceInterface)
+					}
+				}
 			}
 		}
-		return updateErr
+	}
+	sort.SliceStable(targets, func(i, j int) bool {
+		return kindPriority(targets[i].object.Kind) > kindPriority(targets[j].object.Kind)
 	})
-	if retryErr != nil {
-		return retryErr
+	return targets, nil
+}
+
+func hasVerbs(verbs metaV1.Verbs, required ...string) bool {
+	for _, each := range required {
+		found := false
+		for _, verb := range verbs {
+			if verb == each {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
 	}
-	subject.Log = "Updated Successfully"
-	subject.EventData["log"] = subject.Log
-	subject.EventData["footmark"] = enums.POST_AGENT_JOB
-	subject.EventData["status"] = enums.SUCCESSFUL
-	go k.notifyAll(subject)
-	return nil
+	return true
 }
 
-func (k k8sService) UpdateDaemonSet(resource v1.Resource) error {
-	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		result, getErr := k.GetDaemonSet(resource.Name, resource.Namespace)
-		if getErr != nil {
-			log.Println("Failed to get latest version of Deployment: %v", getErr)
-			return getErr
+func objectPath(object inventoryObject) string {
+	if object.Namespace == "" {
+		return object.Name
+	}
+	return object.Namespace + "/" + object.Name
+}
+
+// clusterFactsTTL is how long cluster facts are cached, they change rarely compared to how often jobs are templated.
+const clusterFactsTTL = time.Minute
+
+// clusterFacts cluster facts shared by every k8s service of the agent, refreshed once expired.
+var clusterFacts struct {
+	sync.Mutex
+	facts   map[string]string
+	expires time.Time
+}
+
+// ClusterFacts returns cluster name, node count and kubernetes version, from cache unless it has expired.
+// Returned map is a copy, so callers can modify it freely.
+func (k k8sService) ClusterFacts() (map[string]string, error) {
+	clusterFacts.Lock()
+	defer clusterFacts.Unlock()
+	if clusterFacts.facts == nil || time.Now().After(clusterFacts.expires) {
+		facts, err := k.clusterFacts()
+		if err != nil {
+			return nil, err
 		}
-		for i, each := range resource.Images {
-			result.Spec.Template.Spec.Containers[i].Image = each
+		clusterFacts.facts = facts
+		clusterFacts.expires = time.Now().Add(clusterFactsTTL)
+	}
+	facts := make(map[string]string, len(clusterFacts.facts))
+	for key, value := range clusterFacts.facts {
+		facts[key] = value
+	}
+	return facts, nil
+}
+
+func (k k8sService) clusterFacts() (map[string]string, error) {
+	// nodes are only counted, api server may answer from its watch cache.
+	nodeList, err := k.kcs.CoreV1().Nodes().List(context.Background(), metaV1.ListOptions{ResourceVersion: "0"})
+	if err != nil {
+		return nil, err
+	}
+	serverVersion, err := k.discoveryClient.ServerVersion()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"CLUSTER_NAME":       config.ClusterName,
+		"NODE_COUNT":         strconv.Itoa(len(nodeList.Items)),
+		"KUBERNETES_VERSION": serverVersion.GitVersion,
+	}, nil
+}
+
+// releaseHistoryLimit number of revisions kept per release.
+const releaseHistoryLimit = 10
+
+func releaseSecretName(name string, revision int) string {
+	return "klovercloud-ci.release.v1." + name + ".v" + strconv.Itoa(revision)
+}
+
+func (k k8sService) GetReleases(namespace, name string) ([]v1.Release, error) {
+	secretList, err := k.kcs.CoreV1().Secrets(namespace).List(context.Background(), metaV1.ListOptions{LabelSelector: labels.FormatLabels(map[string]string{"owner": "klovercloud_ci", "release": name})})
+	if err != nil {
+		return nil, err
+	}
+	var releases []v1.Release
+	for _, each := range secretList.Items {
+		gzipReader, err := gzip.NewReader(bytes.NewReader(each.Data["release"]))
+		if err != nil {
+			return nil, err
 		}
-		if result.Labels == nil {
-			result.Labels = make(map[string]string)
+		release := v1.Release{}
+		err = json.NewDecoder(gzipReader).Decode(&release)
+		gzipReader.Close()
+		if err != nil {
+			return nil, err
 		}
-		result.Labels["process_id"] = resource.ProcessId
-		result.Labels["claim"] = strconv.Itoa(resource.Claim)
-		result.Labels["company"] = resource.Pipeline.MetaData.CompanyId
-		result.Labels["klovercloud_ci"] = "enabled"
-		result.Spec.Template.Labels["klovercloud_ci"] = "enabled"
-		result.Spec.Template.Labels["company"] = resource.Pipeline.MetaData.CompanyId
-		result.Spec.Template.Labels["claim"] = strconv.Itoa(resource.Claim)
-		result.Spec.Template.Labels["process_id"] = resource.ProcessId
-		_, updateErr := k.kcs.AppsV1().DaemonSets(resource.Namespace).Update(context.TODO(), result, metaV1.UpdateOptions{})
-		return updateErr
+		releases = append(releases, release)
+	}
+	sort.Slice(releases, func(i, j int) bool {
+		return releases[i].Revision < releases[j].Revision
 	})
-	if retryErr != nil {
-		log.Println("Update failed: %v", retryErr)
-		return retryErr
+	return releases, nil
+}
+
+// SaveRelease stores the release revision in a secret, deleting revisions beyond history limit.
+func (k k8sService) SaveRelease(release v1.Release) error {
+	var data bytes.Buffer
+	gzipWriter := gzip.NewWriter(&data)
+	if err := json.NewEncoder(gzipWriter).Encode(release); err != nil {
+		return err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return err
+	}
+	secret := &coreV1.Secret{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      releaseSecretName(release.Name, release.Revision),
+			Namespace: release.Namespace,
+			Labels: map[string]string{
+				"owner":    "klovercloud_ci",
+				"release":  release.Name,
+				"revision": strconv.Itoa(release.Revision),
+				"status":   string(release.Status),
+			},
+		},
+		Type: "klovercloud.com/release.v1",
+		Data: map[string][]byte{"release": data.Bytes()},
+	}
+	_, err := k.kcs.CoreV1().Secrets(release.Namespace).Create(context.Background(), secret, metaV1.CreateOptions{})
+	if k8sErrors.IsAlreadyExists(err) {
+		_, err = k.kcs.CoreV1().Secrets(release.Namespace).Update(context.Background(), secret, metaV1.UpdateOptions{})
+	}
+	if err != nil {
+		return err
+	}
+	for revision := release.Revision - releaseHistoryLimit; revision > 0; revision-- {
+		err := k.kcs.CoreV1().Secrets(release.Namespace).Delete(context.Background(), releaseSecretName(release.Name, revision), metaV1.DeleteOptions{})
+		if k8sErrors.IsNotFound(err) {
+			break
+		}
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -1567,182 +3038,423 @@ func (k k8sService) GetDaemonSet(name, namespace string) (*appsV1.DaemonSet, err
 	return k.kcs.AppsV1().DaemonSets(namespace).Get(context.Background(), name, metaV1.GetOptions{})
 }
 
-func (k k8sService) isPodRunning(podName, namespace string) error {
-		pod, err := k.kcs.CoreV1().Pods(namespace).Get(context.Background(), podName, metaV1.GetOptions{})
+// checkPodHealth returns error if any container of the pod is stuck in a state it can not recover from.
+func checkPodHealth(pod *coreV1.Pod) error {
+	statuses := append([]coreV1.ContainerStatus{}, pod.Status.InitContainerStatuses...)
+	statuses = append(statuses, pod.Status.ContainerStatuses...)
+	for _, each := range statuses {
+		if each.State.Waiting != nil {
+			switch each.State.Waiting.Reason {
+			case "ContainerCreating", "PodInitializing", "":
+				continue
+			case "ImagePullBackOff", "CrashLoopBackOff", "ErrImagePull", "CreateContainerConfigError", "InvalidImageName", "CreateContainerError":
+				return errors.New("Pod " + pod.Name + " has error: " + each.State.Waiting.Reason + "." + " [Message]:" + each.State.Waiting.Message)
+			default:
+				return errors.New("Pod " + pod.Name + " has error:" + each.State.Waiting.Reason + " [Message]:" + each.State.Waiting.Message)
+			}
+		} else if each.State.Terminated != nil {
+			switch each.State.Terminated.Reason {
+			case "Completed":
+				continue
+			default:
+				return errors.New("Pod " + pod.Name + " has error: " + each.State.Terminated.Reason + "." + " [Message]:" + each.State.Terminated.Message)
+			}
+		}
+	}
+	if pod.Status.Phase == coreV1.PodFailed {
+		return errors.New("Pod " + pod.Name + " has error: " + string(pod.Status.Phase) + " [Message]:" + pod.Status.Reason)
+	}
+	return nil
+}
+
+// checkPodsHealth checks health of every pod matching the selector.
+func (k k8sService) checkPodsHealth(namespace, selector string) error {
+	pods, err := k.listPods(namespace, selector)
+	if err != nil {
+		return err
+	}
+	for i := range pods {
+		if err := checkPodHealth(&pods[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WaitForDeploymentRollout waits until every replica of the deployment is updated and available.
+func (k k8sService) WaitForDeploymentRollout(resource v1.Resource, selector string) error {
+	return k.waitForDeploymentRollout(resource, selector, strategyAbortSignal(resource))
+}
+
+// waitForDeploymentRollout waits for the deployment rollout, failing as soon as abort is closed.
+func (k k8sService) waitForDeploymentRollout(resource v1.Resource, selector string, abort <-chan struct{}) error {
+	generation := int64(0)
+	return k.waitForAbortableRollout(resource, selector, config.RolloutTimeout, abort, func() (bool, string, error) {
+		deployment, err := k.cachedDeployment(resource.Name, resource.Namespace, generation)
 		if err != nil {
-			return  err
+			return false, "", err
 		}
-		if pod.Status.Phase == coreV1.PodSucceeded {
-			return nil
+		generation = deployment.Generation
+		return deploymentRolloutStatus(deployment)
+	})
+}
+
+// WaitForStatefulSetRollout waits until every replica of the statefulSet is ready at the update revision.
+func (k k8sService) WaitForStatefulSetRollout(resource v1.Resource, selector string) error {
+	generation := int64(0)
+	return k.waitForRollout(resource, selector, config.RolloutTimeout, func() (bool, string, error) {
+		statefulSet, err := k.cachedStatefulSet(resource.Name, resource.Namespace, generation)
+		if err != nil {
+			return false, "", err
 		}
+		generation = statefulSet.Generation
+		return statefulSetRolloutStatus(statefulSet)
+	})
+}
 
-		completedCount := 0
-		for _, each := range pod.Status.ContainerStatuses {
-			if each.State.Waiting != nil {
-				if each.State.Waiting.Reason == "ImagePullBackOff" {
-					return  errors.New("Pod has error: ImagePullBackOff."+" [Message]:"+each.State.Waiting.Message)
-				} else if each.State.Waiting.Reason == "CrashLoopBackOff" {
-					return errors.New("Pod has error: CrashLoopBackOff."+" [Message]:"+each.State.Waiting.Message)
-				} else if each.State.Waiting.Reason == "ErrImagePull" {
-					return errors.New("Pod has error: ErrImagePull."+" [Message]:"+each.State.Waiting.Message)
-				} else if each.State.Waiting.Reason == "CreateContainerConfigError" {
-					return errors.New("Pod has error: CreateContainerConfigError."+" [Message]:"+each.State.Waiting.Message)
-				} else if each.State.Waiting.Reason == "InvalidImageName" {
-					return errors.New("Pod has error: InvalidImageName."+" [Message]:"+each.State.Waiting.Message)
-				} else if each.State.Waiting.Reason == "CreateContainerError" {
-					return errors.New("Pod has error: CreateContainerError."+" [Message]:"+each.State.Waiting.Message)
-				}else if each.State.Waiting.Reason=="ContainerCreating" {
-					time.Sleep(time.Second*5)
-					return k.isPodRunning(podName,namespace)
-				}else{
-					return errors.New("Pod has error:"+each.State.Waiting.Reason+" [Message]:"+each.State.Waiting.Message)
-				}
-			} else if each.State.Terminated != nil {
-				if each.State.Terminated.Reason == "OOMKilled" {
-					return errors.New("Pod has error: OOMKilled."+" [Message]:"+each.State.Terminated.Message)
-				} else if each.State.Terminated.Reason == "Error" {
-					return errors.New("Pod has error: Error."+" [Message]:"+each.State.Terminated.Message)
-				} else if each.State.Terminated.Reason == "Completed" {
-					completedCount++
-				} else if each.State.Terminated.Reason == "ContainerCannotRun" {
-					return errors.New("Pod has error: ContainerCannotRun."+" [Message]:"+each.State.Terminated.Message)
-				} else if each.State.Terminated.Reason == "ContainerCannotRun" {
-					return errors.New("Pod has error: InvalidImageName."+" [Message]:"+each.State.Terminated.Message)
-				} else if each.State.Terminated.Reason == "DeadlineExceeded" {
-					return errors.New("Pod has error: DeadlineExceeded."+" [Message]:"+each.State.Terminated.Message)
-				}else{
-					return errors.New("Pod has error:"+each.State.Terminated.Reason+" [Message]:"+each.State.Terminated.Message)
-				}
-			}
+// WaitForDaemonSetRollout waits until every scheduled pod of the daemonSet is updated and available.
+func (k k8sService) WaitForDaemonSetRollout(resource v1.Resource, selector string) error {
+	generation := int64(0)
+	return k.waitForRollout(resource, selector, config.RolloutTimeout, func() (bool, string, error) {
+		daemonSet, err := k.cachedDaemonSet(resource.Name, resource.Namespace, generation)
+		if err != nil {
+			return false, "", err
 		}
-		if completedCount == len(pod.Status.ContainerStatuses) {
-			return nil
+		generation = daemonSet.Generation
+		return daemonSetRolloutStatus(daemonSet)
+	})
+}
+
+// waitForReplicaSetRollout waits until every replica of the replicaSet is ready and available.
+func (k k8sService) waitForReplicaSetRollout(resource v1.Resource, selector string) error {
+	return k.waitForRollout(resource, selector, config.RolloutTimeout, func() (bool, string, error) {
+		replicaSet, err := k.kcs.AppsV1().ReplicaSets(resource.Namespace).Get(context.Background(), resource.Name, metaV1.GetOptions{})
+		if err != nil {
+			return false, "", err
 		}
+		return replicaSetRolloutStatus(replicaSet)
+	})
+}
 
-		switch pod.Status.Phase {
-		case coreV1.PodRunning:
-			return nil
-		case coreV1.PodFailed:
-			return errors.New("Pod has error: "+string(pod.Status.Phase)+" [Message]:"+pod.Status.Reason)
-		case coreV1.PodUnknown:
-			if len(pod.Status.Conditions) > 0 {
-				return errors.New(pod.Status.Conditions[len(pod.Status.Conditions)].Message)
-			}
-			return errors.New("Pod status is unknown: "+string(pod.Status.Phase)+" [Message]:"+pod.Status.Reason)
+// WatchRollout waits for the rollout of an already updated deployment, statefulSet or daemonSet.
+func (k k8sService) WatchRollout(resource v1.Resource) error {
+	switch resource.Type {
+	case enums.DEPLOYMENT:
+		deployment, err := k.GetDeployment(resource.Name, resource.Namespace)
+		if err != nil {
+			return err
 		}
-		return nil
+		return k.WaitForDeploymentRollout(resource, labels.FormatLabels(deployment.Spec.Template.Labels))
+	case enums.STATEFULSET:
+		statefulSet, err := k.GetStatefulSet(resource.Name, resource.Namespace)
+		if err != nil {
+			return err
+		}
+		return k.WaitForStatefulSetRollout(resource, labels.FormatLabels(statefulSet.Spec.Template.Labels))
+	case enums.DAEMONSET:
+		daemonSet, err := k.GetDaemonSet(resource.Name, resource.Namespace)
+		if err != nil {
+			return err
+		}
+		return k.WaitForDaemonSetRollout(resource, labels.FormatLabels(daemonSet.Spec.Template.Labels))
+	}
+	return errors.New("rollout of resource type " + string(resource.Type) + " can not be watched")
 }
 
-func (k k8sService) waitForPodRunning(namespace, podName string) error {
-	return  k.isPodRunning(podName, namespace)
+// RollbackToPreviousRevision restores the revision a workload ran before the job updated it, if the update reached the
+// workload. Used when the job can not be watched to its end, as snapshot taken before the update is gone.
+func (k k8sService) RollbackToPreviousRevision(resource v1.Resource, cause error) error {
+	switch resource.Type {
+	case enums.DEPLOYMENT:
+		deployment, err := k.GetDeployment(resource.Name, resource.Namespace)
+		if err != nil {
+			return err
+		}
+		if deployment.Spec.Template.Labels["process_id"] != resource.ProcessId {
+			return cause
+		}
+		template, err := k.previousDeploymentTemplate(deployment, resource.ProcessId)
+		if err != nil || template == nil {
+			return cause
+		}
+		snapshot := deployment.DeepCopy()
+		snapshot.Labels = previousRevisionLabels(deployment.Labels, *template)
+		snapshot.Spec.Template = *template
+		return k.rollbackDeployment(resource, snapshot, cause)
+	case enums.STATEFULSET:
+		statefulSet, err := k.GetStatefulSet(resource.Name, resource.Namespace)
+		if err != nil {
+			return err
+		}
+		if statefulSet.Spec.Template.Labels["process_id"] != resource.ProcessId {
+			return cause
+		}
+		template, err := k.previousControllerRevisionTemplate(resource.Namespace, statefulSet.UID, statefulSet.Spec.Selector, resource.ProcessId)
+		if err != nil || template == nil {
+			return cause
+		}
+		snapshot := statefulSet.DeepCopy()
+		snapshot.Labels = previousRevisionLabels(statefulSet.Labels, *template)
+		snapshot.Spec.Template = *template
+		return k.rollbackStatefulSet(resource, snapshot, labels.FormatLabels(statefulSet.Spec.Template.Labels), cause)
+	case enums.DAEMONSET:
+		daemonSet, err := k.GetDaemonSet(resource.Name, resource.Namespace)
+		if err != nil {
+			return err
+		}
+		if daemonSet.Spec.Template.Labels["process_id"] != resource.ProcessId {
+			return cause
+		}
+		template, err := k.previousControllerRevisionTemplate(resource.Namespace, daemonSet.UID, daemonSet.Spec.Selector, resource.ProcessId)
+		if err != nil || template == nil {
+			return cause
+		}
+		snapshot := daemonSet.DeepCopy()
+		snapshot.Labels = previousRevisionLabels(daemonSet.Labels, *template)
+		snapshot.Spec.Template = *template
+		return k.rollbackDaemonSet(resource, snapshot, cause)
+	}
+	return cause
 }
 
-func (k k8sService) ListNewPodsByRevision(retryCount int, namespace, selector string, replica int32,revision map[string]bool) (*coreV1.PodList, error) {
-	listOptions := metaV1.ListOptions{LabelSelector: selector}
-	podList, err := k.kcs.CoreV1().Pods(namespace).List(context.Background(), listOptions)
+// previousDeploymentTemplate returns pod template of the latest replicaSet of deployment not created by the job, nil if there is none.
+func (k k8sService) previousDeploymentTemplate(deployment *appsV1.Deployment, processId string) (*coreV1.PodTemplateSpec, error) {
+	selector, err := metaV1.LabelSelectorAsSelector(deployment.Spec.Selector)
 	if err != nil {
 		return nil, err
 	}
-	var newPods []string
-
-	for _, each := range podList.Items {
-		if _, ok := revision[each.GetResourceVersion()]; ok {
+	replicaSets, err := k.kcs.AppsV1().ReplicaSets(deployment.Namespace).List(context.Background(), metaV1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	var template *coreV1.PodTemplateSpec
+	latest := int64(-1)
+	for i, each := range replicaSets.Items {
+		if !metaV1.IsControlledBy(&replicaSets.Items[i], deployment) || each.Spec.Template.Labels["process_id"] == processId {
 			continue
 		}
-		newPods = append(newPods, each.Name)
-	}
-	if int32(len(newPods)) <replica && retryCount < 10 {
-		time.Sleep(time.Second * 2)
-		retryCount = retryCount + 1
-		return k.ListNewPodsByRevision(retryCount, namespace, selector,replica, revision)
+		revision, err := strconv.ParseInt(each.Annotations["deployment.kubernetes.io/revision"], 10, 64)
+		if err != nil || revision <= latest {
+			continue
+		}
+		latest = revision
+		template = each.Spec.Template.DeepCopy()
+		delete(template.Labels, appsV1.DefaultDeploymentUniqueLabelKey)
 	}
-	return podList, nil
+	return template, nil
 }
 
-func (k k8sService) ListNewPods(retryCount int, namespace, selector string,replica int32, existingPodMap map[string]bool) (*coreV1.PodList, error) {
-	listOptions := metaV1.ListOptions{LabelSelector: selector}
-	podList, err := k.kcs.CoreV1().Pods(namespace).List(context.Background(), listOptions)
+// previousControllerRevisionTemplate returns pod template of the latest controllerRevision owned by owner not created by the job,
+// nil if there is none. StatefulSet and daemonSet revisions both hold the pod template as a patch of the spec.
+func (k k8sService) previousControllerRevisionTemplate(namespace string, owner types.UID, labelSelector *metaV1.LabelSelector, processId string) (*coreV1.PodTemplateSpec, error) {
+	selector, err := metaV1.LabelSelectorAsSelector(labelSelector)
 	if err != nil {
 		return nil, err
 	}
-	var newPods coreV1.PodList
-
-	for _, each := range podList.Items {
-		if _, ok := existingPodMap[each.Name]; ok {
+	revisions, err := k.kcs.AppsV1().ControllerRevisions(namespace).List(context.Background(), metaV1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	var template *coreV1.PodTemplateSpec
+	latest := int64(-1)
+	for _, each := range revisions.Items {
+		controller := metaV1.GetControllerOf(&each)
+		if controller == nil || controller.UID != owner || each.Revision <= latest {
+			continue
+		}
+		data := struct {
+			Spec struct {
+				Template coreV1.PodTemplateSpec `json:"template"`
+			} `json:"spec"`
+		}{}
+		if err := json.Unmarshal(each.Data.Raw, &data); err != nil || data.Spec.Template.Labels["process_id"] == processId {
 			continue
 		}
-		newPods.Items = append(newPods.Items, each)
+		latest = each.Revision
+		template = data.Spec.Template.DeepCopy()
+	}
+	return template, nil
+}
+
+// previousRevisionLabels returns workload labels pointing back to the job that created template.
+func previousRevisionLabels(current map[string]string, template coreV1.PodTemplateSpec) map[string]string {
+	out := make(map[string]string)
+	for key, value := range current {
+		out[key] = value
 	}
-	if int32(len(newPods.Items)) <replica && retryCount < 20 {
-		time.Sleep(time.Second * 5)
-		retryCount = retryCount + 1
-		return k.ListNewPods(retryCount, namespace, selector,replica, existingPodMap)
+	for _, key := range []string{"process_id", "claim"} {
+		if value, ok := template.Labels[key]; ok {
+			out[key] = value
+		}
 	}
-	return podList, nil
+	return out
+}
+
+// waitForRollout polls rolloutStatus until rollout is done, failed or timed out. Progress is streamed to the observers.
+// Pods matching selector are checked on every poll so that unrecoverable pod errors fail the rollout early.
+func (k k8sService) waitForRollout(resource v1.Resource, selector string, timeoutSeconds int64, rolloutStatus func() (bool, string, error)) error {
+	return k.waitForAbortableRollout(resource, selector, timeoutSeconds, strategyAbortSignal(resource), rolloutStatus)
 }
 
-func (k k8sService) WaitForPodBySelectorAndRevisionUntilRunning(companyId, step, name, namespace, processId, selector string,replica int32, revisions map[string]bool, claim int) error {
-	subject := v1.Subject{step, "Listing Pods ...", name, namespace, processId, nil, nil, nil}
+// waitForAbortableRollout waits like waitForRollout, failing as soon as abort is closed.
+func (k k8sService) waitForAbortableRollout(resource v1.Resource, selector string, timeoutSeconds int64, abort <-chan struct{}, rolloutStatus func() (bool, string, error)) error {
+	subject := v1.Subject{Step: resource.Step, Name: resource.Name, Namespace: resource.Namespace, ProcessId: resource.ProcessId, Pipeline: resource.Pipeline}
 	subject.EventData = make(map[string]interface{})
-	subject.EventData["log"] = subject.Log
 	subject.EventData["footmark"] = enums.POST_AGENT_JOB
-	subject.EventData["status"] = enums.PROCESSING
 	subject.EventData["reason"] = "n/a"
-	subject.EventData["company_id"] = companyId
-	subject.EventData["process_id"] = processId
-	subject.EventData["claim"] = strconv.Itoa(claim)
-	go k.notifyAll(subject)
-	podList, err := k.ListNewPodsByRevision(0, namespace, selector,replica, revisions)
-	if err != nil {
-		return err
-	}
-	if len(podList.Items) == 0 {
-		return fmt.Errorf("No new pods in %s with selector %s", namespace, selector)
-	}
-	for _, pod := range podList.Items {
-		subject.Log = "Waiting until pods are ready ..."
-		subject.EventData["log"] = subject.Log
-		go k.notifyAll(subject)
-		if err := k.waitForPodRunning(namespace, pod.Name); err != nil {
-			log.Println(err.Error())
+	subject.EventData["status"] = enums.PROCESSING
+	subject.EventData["step"] = resource.Step
+	subject.EventData["process_id"] = resource.ProcessId
+	subject.EventData["company_id"] = resource.Pipeline.MetaData.CompanyId
+	subject.EventData["claim"] = strconv.Itoa(resource.Claim)
+	timeout := time.After(time.Second * time.Duration(timeoutSeconds))
+	ticker := time.NewTicker(time.Second * 3)
+	defer ticker.Stop()
+	lastMessage := ""
+	for {
+		done, message, err := rolloutStatus()
+		if err != nil {
 			return err
 		}
+		if message != lastMessage {
+			lastMessage = message
+			subject.Log = message
+			subject.EventData["log"] = message
+			k.notifyAll(subject)
+		}
+		if done {
+			return nil
+		}
+		if selector != "" {
+			if err := k.checkPodsHealth(resource.Namespace, selector); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-timeout:
+			return fmt.Errorf("rollout of %s timed out after %d seconds: %s", resource.Name, timeoutSeconds, lastMessage)
+		case <-abort:
+			return errStrategyAborted
+		case <-ticker.C:
+		}
 	}
-	return nil
 }
 
-func (k k8sService) WaitForPodBySelectorUntilRunning(companyId, step, name, namespace, processId, selector string,replica int32, existingPods map[string]bool, claim int) error {
-	subject := v1.Subject{step, "Listing Pods ...", name, namespace, processId, nil, nil, nil}
-	subject.EventData = make(map[string]interface{})
-	subject.EventData["log"] = subject.Log
-	subject.EventData["footmark"] = enums.POST_AGENT_JOB
-	subject.EventData["process_id"] = processId
-	subject.EventData["company_id"] = companyId
-	subject.EventData["reason"] = "n/a"
-	subject.EventData["claim"] = strconv.Itoa(claim)
-	go k.notifyAll(subject)
-	podList, err := k.ListNewPods(0, namespace, selector,replica, existingPods)
-	if err != nil {
-		return err
+// deploymentRolloutStatus returns rollout status of the deployment, following kubectl rollout status semantics.
+func deploymentRolloutStatus(deployment *appsV1.Deployment) (bool, string, error) {
+	if deployment.Generation > deployment.Status.ObservedGeneration {
+		return false, "Waiting for deployment " + deployment.Name + " spec update to be observed ...", nil
+	}
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == appsV1.DeploymentProgressing && condition.Reason == "ProgressDeadlineExceeded" {
+			return false, "", fmt.Errorf("deployment %s exceeded its progress deadline: %s", deployment.Name, condition.Message)
+		}
 	}
-	if len(podList.Items) == 0 {
-		return fmt.Errorf("No new pods in %s with selector %s", namespace, selector)
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
 	}
+	if deployment.Status.UpdatedReplicas < replicas {
+		return false, fmt.Sprintf("Waiting for deployment %s rollout to finish: %d out of %d new replicas have been updated ...", deployment.Name, deployment.Status.UpdatedReplicas, replicas), nil
+	}
+	if deployment.Status.Replicas > deployment.Status.UpdatedReplicas {
+		return false, fmt.Sprintf("Waiting for deployment %s rollout to finish: %d old replicas are pending termination ...", deployment.Name, deployment.Status.Replicas-deployment.Status.UpdatedReplicas), nil
+	}
+	if deployment.Status.AvailableReplicas < deployment.Status.UpdatedReplicas {
+		return false, fmt.Sprintf("Waiting for deployment %s rollout to finish: %d of %d updated replicas are available ...", deployment.Name, deployment.Status.AvailableReplicas, deployment.Status.UpdatedReplicas), nil
+	}
+	return true, "Deployment " + deployment.Name + " successfully rolled out", nil
+}
 
-	for _, pod := range podList.Items {
-		subject.Log = "Waiting until pods are ready ..."
-		subject.EventData["log"] = subject.Log
-		go k.notifyAll(subject)
-		if err := k.waitForPodRunning(namespace, pod.Name); err != nil {
-			return err
+// statefulSetRolloutStatus returns rollout status of the statefulSet, following kubectl rollout status semantics.
+func statefulSetRolloutStatus(statefulSet *appsV1.StatefulSet) (bool, string, error) {
+	if statefulSet.Spec.UpdateStrategy.Type != appsV1.RollingUpdateStatefulSetStrategyType {
+		return true, "StatefulSet " + statefulSet.Name + " uses " + string(statefulSet.Spec.UpdateStrategy.Type) + " update strategy, skipping rollout wait", nil
+	}
+	if statefulSet.Status.ObservedGeneration == 0 || statefulSet.Generation > statefulSet.Status.ObservedGeneration {
+		return false, "Waiting for statefulSet " + statefulSet.Name + " spec update to be observed ...", nil
+	}
+	replicas := int32(1)
+	if statefulSet.Spec.Replicas != nil {
+		replicas = *statefulSet.Spec.Replicas
+	}
+	if statefulSet.Status.ReadyReplicas < replicas {
+		return false, fmt.Sprintf("Waiting for statefulSet %s: %d of %d pods are ready ...", statefulSet.Name, statefulSet.Status.ReadyReplicas, replicas), nil
+	}
+	if statefulSet.Spec.UpdateStrategy.RollingUpdate != nil && statefulSet.Spec.UpdateStrategy.RollingUpdate.Partition != nil && *statefulSet.Spec.UpdateStrategy.RollingUpdate.Partition > 0 {
+		partition := *statefulSet.Spec.UpdateStrategy.RollingUpdate.Partition
+		if statefulSet.Status.UpdatedReplicas < replicas-partition {
+			return false, fmt.Sprintf("Waiting for partitioned roll out of statefulSet %s to finish: %d out of %d new pods have been updated ...", statefulSet.Name, statefulSet.Status.UpdatedReplicas, replicas-partition), nil
 		}
+		return true, fmt.Sprintf("Partitioned roll out of statefulSet %s complete: %d new pods have been updated", statefulSet.Name, statefulSet.Status.UpdatedReplicas), nil
+	}
+	if statefulSet.Status.UpdateRevision != statefulSet.Status.CurrentRevision {
+		return false, fmt.Sprintf("Waiting for statefulSet %s rolling update to complete %d pods at revision %s ...", statefulSet.Name, statefulSet.Status.UpdatedReplicas, statefulSet.Status.UpdateRevision), nil
 	}
+	return true, fmt.Sprintf("StatefulSet %s rolling update complete %d pods at revision %s", statefulSet.Name, statefulSet.Status.CurrentReplicas, statefulSet.Status.CurrentRevision), nil
+}
 
-	return nil
+// daemonSetRolloutStatus returns rollout status of the daemonSet, following kubectl rollout status semantics.
+func daemonSetRolloutStatus(daemonSet *appsV1.DaemonSet) (bool, string, error) {
+	if daemonSet.Spec.UpdateStrategy.Type != appsV1.RollingUpdateDaemonSetStrategyType {
+		return true, "DaemonSet " + daemonSet.Name + " uses " + string(daemonSet.Spec.UpdateStrategy.Type) + " update strategy, skipping rollout wait", nil
+	}
+	if daemonSet.Generation > daemonSet.Status.ObservedGeneration {
+		return false, "Waiting for daemonSet " + daemonSet.Name + " spec update to be observed ...", nil
+	}
+	if daemonSet.Status.UpdatedNumberScheduled < daemonSet.Status.DesiredNumberScheduled {
+		return false, fmt.Sprintf("Waiting for daemonSet %s rollout to finish: %d out of %d new pods have been updated ...", daemonSet.Name, daemonSet.Status.UpdatedNumberScheduled, daemonSet.Status.DesiredNumberScheduled), nil
+	}
+	if daemonSet.Status.NumberReady < daemonSet.Status.DesiredNumberScheduled {
+		return false, fmt.Sprintf("Waiting for daemonSet %s rollout to finish: %d of %d pods are ready ...", daemonSet.Name, daemonSet.Status.NumberReady, daemonSet.Status.DesiredNumberScheduled), nil
+	}
+	if daemonSet.Status.NumberAvailable < daemonSet.Status.DesiredNumberScheduled {
+		return false, fmt.Sprintf("Waiting for daemonSet %s rollout to finish: %d of %d updated pods are available ...", daemonSet.Name, daemonSet.Status.NumberAvailable, daemonSet.Status.DesiredNumberScheduled), nil
+	}
+	return true, "DaemonSet " + daemonSet.Name + " successfully rolled out", nil
+}
+
+// replicaSetRolloutStatus returns true once every desired replica of the replicaSet is ready and available.
+func replicaSetRolloutStatus(replicaSet *appsV1.ReplicaSet) (bool, string, error) {
+	if replicaSet.Generation > replicaSet.Status.ObservedGeneration {
+		return false, "Waiting for replicaSet " + replicaSet.Name + " spec update to be observed ...", nil
+	}
+	desired := int32(1)
+	if replicaSet.Spec.Replicas != nil {
+		desired = *replicaSet.Spec.Replicas
+	}
+	if replicaSet.Status.Replicas != desired {
+		return false, fmt.Sprintf("Waiting for replicaSet %s to finish scaling: %d of %d replicas exist ...", replicaSet.Name, replicaSet.Status.Replicas, desired), nil
+	}
+	if replicaSet.Status.ReadyReplicas < desired {
+		return false, fmt.Sprintf("Waiting for replicaSet %s to finish scaling: %d of %d replicas are ready ...", replicaSet.Name, replicaSet.Status.ReadyReplicas, desired), nil
+	}
+	if replicaSet.Status.AvailableReplicas < desired {
+		return false, fmt.Sprintf("Waiting for replicaSet %s to finish scaling: %d of %d replicas are available ...", replicaSet.Name, replicaSet.Status.AvailableReplicas, desired), nil
+	}
+	return true, "ReplicaSet " + replicaSet.Name + " successfully scaled", nil
 }
 
 func (k k8sService) notifyAll(subject v1.Subject) {
-	for _, observer := range k.observerList {
+	notifyObservers(k.observerList, subject)
+}
+
+// notifyObservers notifies observers of a copy of subject's event data, as notifiers keep modifying it for their next
+// notification while observers read it. Journal only queues progress, it is notified in place so progress of a job is
+// queued in the order it is made.
+func notifyObservers(observerList []service.Observer, subject v1.Subject) {
+	if subject.EventData != nil {
+		eventData := make(map[string]interface{}, len(subject.EventData))
+		for key, value := range subject.EventData {
+			eventData[key] = value
+		}
+		subject.EventData = eventData
+	}
+	for _, observer := range observerList {
+		if _, ok := observer.(service.Journal); ok {
+			observer.Listen(subject)
+			continue
+		}
 		go observer.Listen(subject)
 	}
 }
@@ -1755,5 +3467,6 @@ func NewK8sService(Kcs *kubernetes.Clientset, dynamicClient dynamic.Interface, d
 		discoveryClient:    discoveryClient,
 		observerList:       observerList,
 		kubeEventPublisher: kubeEventPublisher,
+		informers:          getCiInformers(dynamicClient),
 	}
 }
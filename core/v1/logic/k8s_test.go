@@ -0,0 +1,192 @@
+package logic
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	v1 "github.com/klovercloud-ci-cd/agent/core/v1"
+	"github.com/klovercloud-ci-cd/agent/core/v1/service"
+	appsV1 "k8s.io/api/apps/v1"
+	autoscalingV1 "k8s.io/api/autoscaling/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// eventDataReader observer reading every event data entry of the subjects it is notified of.
+type eventDataReader struct {
+	sync.Mutex
+	wg   *sync.WaitGroup
+	logs []string
+}
+
+func (e *eventDataReader) Listen(subject v1.Subject) {
+	defer e.wg.Done()
+	log := ""
+	for key, value := range subject.EventData {
+		if key == "log" {
+			log = value.(string)
+		}
+	}
+	e.Lock()
+	defer e.Unlock()
+	e.logs = append(e.logs, log)
+}
+
+// Run with -race, notifiers modify event data right after notifying.
+func TestNotifyObservers(t *testing.T) {
+	var wg sync.WaitGroup
+	reader := &eventDataReader{wg: &wg}
+	observers := []service.Observer{reader, reader}
+	subject := v1.Subject{EventData: map[string]interface{}{}}
+	for i := 0; i < 100; i++ {
+		wg.Add(len(observers))
+		subject.EventData["log"] = strings.Repeat("x", i)
+		subject.EventData["status"] = i
+		notifyObservers(observers, subject)
+	}
+	wg.Wait()
+	if len(reader.logs) != 200 {
+		t.Fatalf("Expected 200 notifications, got %d", len(reader.logs))
+	}
+	seen := make(map[int]int)
+	for _, each := range reader.logs {
+		seen[len(each)]++
+	}
+	for i := 0; i < 100; i++ {
+		if seen[i] != 2 {
+			t.Errorf("Expected log %d to be notified twice, got %d", i, seen[i])
+		}
+	}
+}
+
+func TestDeploymentRolloutStatus(t *testing.T) {
+	replicas := int32(3)
+	testData := []struct {
+		name    string
+		status  appsV1.DeploymentStatus
+		gen     int64
+		done    bool
+		message string
+		err     bool
+	}{
+		{
+			name:    "spec not observed",
+			gen:     2,
+			status:  appsV1.DeploymentStatus{ObservedGeneration: 1},
+			message: "spec update to be observed",
+		},
+		{
+			name: "progress deadline exceeded",
+			gen:  1,
+			status: appsV1.DeploymentStatus{ObservedGeneration: 1, Conditions: []appsV1.DeploymentCondition{
+				{Type: appsV1.DeploymentProgressing, Reason: "ProgressDeadlineExceeded", Message: "timed out"},
+			}},
+			err: true,
+		},
+		{
+			name:    "replicas not updated",
+			gen:     1,
+			status:  appsV1.DeploymentStatus{ObservedGeneration: 1, Replicas: 3, UpdatedReplicas: 1},
+			message: "1 out of 3 new replicas have been updated",
+		},
+		{
+			name:    "old replicas pending termination",
+			gen:     1,
+			status:  appsV1.DeploymentStatus{ObservedGeneration: 1, Replicas: 4, UpdatedReplicas: 3},
+			message: "1 old replicas are pending termination",
+		},
+		{
+			name:    "updated replicas not available",
+			gen:     1,
+			status:  appsV1.DeploymentStatus{ObservedGeneration: 1, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 2},
+			message: "2 of 3 updated replicas are available",
+		},
+		{
+			name:    "rolled out",
+			gen:     1,
+			status:  appsV1.DeploymentStatus{ObservedGeneration: 1, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 3},
+			done:    true,
+			message: "successfully rolled out",
+		},
+	}
+	for _, each := range testData {
+		deployment := &appsV1.Deployment{
+			ObjectMeta: metaV1.ObjectMeta{Name: "app", Generation: each.gen},
+			Spec:       appsV1.DeploymentSpec{Replicas: &replicas},
+			Status:     each.status,
+		}
+		done, message, err := deploymentRolloutStatus(deployment)
+		if (err != nil) != each.err {
+			t.Errorf("%s: unexpected error %v", each.name, err)
+			continue
+		}
+		if done != each.done {
+			t.Errorf("%s: expected done %v, got %v", each.name, each.done, done)
+		}
+		if !strings.Contains(message, each.message) {
+			t.Errorf("%s: expected message containing %q, got %q", each.name, each.message, message)
+		}
+	}
+}
+
+func TestTeardownPropagation(t *testing.T) {
+	testData := []struct {
+		teardown *v1.Teardown
+		expected metaV1.DeletionPropagation
+		err      bool
+	}{
+		{nil, metaV1.DeletePropagationForeground, false},
+		{&v1.Teardown{}, metaV1.DeletePropagationForeground, false},
+		{&v1.Teardown{PropagationPolicy: "Background"}, metaV1.DeletePropagationBackground, false},
+		{&v1.Teardown{PropagationPolicy: "Orphan"}, metaV1.DeletePropagationOrphan, false},
+		{&v1.Teardown{PropagationPolicy: "background"}, "", true},
+		{&v1.Teardown{PropagationPolicy: "Cascade"}, "", true},
+	}
+	for _, each := range testData {
+		propagation, err := teardownPropagation(v1.Resource{Teardown: each.teardown})
+		if (err != nil) != each.err {
+			t.Errorf("%+v: unexpected error %v", each.teardown, err)
+			continue
+		}
+		if propagation != each.expected {
+			t.Errorf("%+v: expected %s, got %s", each.teardown, each.expected, propagation)
+		}
+	}
+}
+
+func TestReplicaDiffOf(t *testing.T) {
+	int32Of := func(value int32) *int32 {
+		return &value
+	}
+	hpa := &autoscalingV1.HorizontalPodAutoscaler{
+		ObjectMeta: metaV1.ObjectMeta{Name: "app-hpa"},
+		Spec:       autoscalingV1.HorizontalPodAutoscalerSpec{MinReplicas: int32Of(2), MaxReplicas: 5},
+	}
+	testData := []struct {
+		name     string
+		replica  int32
+		current  *int32
+		hpa      *autoscalingV1.HorizontalPodAutoscaler
+		expected v1.ReplicaDiff
+		err      bool
+	}{
+		{"without hpa", 3, int32Of(1), nil, v1.ReplicaDiff{Current: 1, Target: 3}, false},
+		{"without current replicas", 3, nil, nil, v1.ReplicaDiff{Current: 1, Target: 3}, false},
+		{"without hpa to zero", 0, int32Of(2), nil, v1.ReplicaDiff{Current: 2, Target: 0}, false},
+		{"hpa keeps current within bounds", 3, int32Of(4), hpa, v1.ReplicaDiff{Current: 4, Target: 4, HorizontalPodAutoscaler: "app-hpa"}, false},
+		{"hpa raised to requested", 3, int32Of(2), hpa, v1.ReplicaDiff{Current: 2, Target: 3, HorizontalPodAutoscaler: "app-hpa"}, false},
+		{"hpa capped at max", 3, int32Of(8), hpa, v1.ReplicaDiff{Current: 8, Target: 5, HorizontalPodAutoscaler: "app-hpa"}, false},
+		{"hpa max raised to requested", 7, int32Of(8), hpa, v1.ReplicaDiff{Current: 8, Target: 7, HorizontalPodAutoscaler: "app-hpa"}, false},
+		{"hpa to zero", 0, int32Of(2), hpa, v1.ReplicaDiff{}, true},
+	}
+	for _, each := range testData {
+		diff, err := replicaDiffOf(v1.Resource{Name: "app", Replica: each.replica}, each.current, each.hpa)
+		if (err != nil) != each.err {
+			t.Errorf("%s: unexpected error %v", each.name, err)
+			continue
+		}
+		if err == nil && *diff != each.expected {
+			t.Errorf("%s: expected %+v, got %+v", each.name, each.expected, *diff)
+		}
+	}
+}
@@ -1,11 +1,10 @@
 package logic
 
 import (
+	"context"
 	"encoding/json"
-	"github.com/klovercloud-ci-cd/agent/config"
 	v1 "github.com/klovercloud-ci-cd/agent/core/v1"
 	"github.com/klovercloud-ci-cd/agent/core/v1/service"
-	"github.com/klovercloud-ci-cd/agent/enums"
 	"log"
 )
 
@@ -13,21 +12,25 @@ type kubeEventHttpPublisher struct {
 	httpPublisher service.HttpClient
 }
 
+// Publish queues message in the outbox, it is delivered asynchronously.
 func (k kubeEventHttpPublisher) Publish(message v1.KubeEventMessage) {
-	marshal, _ := json.Marshal(message)
-	if message.Header.Command==enums.UPDATE{
-		if message.Header.Extras["object"]=="pod"{
-			log.Println(string(marshal))
-		}
-	}
-	header := make(map[string]string)
-	header["token"] = config.Token
-	header["Content-Type"] = "application/json"
-	err := k.httpPublisher.Post(config.ApiServiceUrl+"/kube_events", header, marshal)
-	if err!=nil {
+	marshal, err := json.Marshal(message)
+	if err != nil {
 		log.Println(err.Error())
+		return
+	}
+	getKubeEventOutbox(k.httpPublisher).enqueue(marshal)
+}
+
+// Flush delivers queued messages before ctx is done, the rest is spilled to disk if spooling is enabled.
+func (k kubeEventHttpPublisher) Flush(ctx context.Context) error {
+	outboxMutex.Lock()
+	current := outbox
+	outboxMutex.Unlock()
+	if current == nil {
+		return nil
 	}
-	return
+	return current.flush(ctx)
 }
 
 func NewKubeEventHttpPublisher(httpPublisher service.HttpClient) service.KubeEventPublisher {
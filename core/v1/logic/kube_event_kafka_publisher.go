@@ -1,17 +1,253 @@
 package logic
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"github.com/Shopify/sarama"
+	"github.com/klovercloud-ci-cd/agent/config"
 	v1 "github.com/klovercloud-ci-cd/agent/core/v1"
 	"github.com/klovercloud-ci-cd/agent/core/v1/service"
+	"github.com/xdg/scram"
+	"io/ioutil"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// kafkaProducer is shared by every kafka publisher of the agent, it is created on first publish and closed by Flush.
+// Messages are handed to the producer under kafkaProducerMutex, so none is sent once the producer is closed.
+var (
+	kafkaProducer       sarama.AsyncProducer
+	kafkaProducerClosed bool
+	kafkaProducerMutex  sync.Mutex
+)
+
+// kafkaPublishTimeout is how long a message waits for the producer to take it. Producer stops taking messages once its
+// buffers are full, messages are dropped then so that informers are never blocked by unavailable brokers.
+const kafkaPublishTimeout = time.Millisecond * 100
+
+// kafkaDropped counts messages dropped as the producer did not take them in time.
+var kafkaDropped int64
+
+var kafkaCompressionCodecs = map[string]sarama.CompressionCodec{
+	"none":   sarama.CompressionNone,
+	"gzip":   sarama.CompressionGZIP,
+	"snappy": sarama.CompressionSnappy,
+	"lz4":    sarama.CompressionLZ4,
+	"zstd":   sarama.CompressionZSTD,
+}
+
 type kubeEventKafkaPublisher struct {
 }
 
+// Publish sends message to the kube event topic, keyed by object uid so that events of an object stay ordered.
+// Message is dropped if the producer does not take it within kafkaPublishTimeout or has been flushed.
 func (k kubeEventKafkaPublisher) Publish(message v1.KubeEventMessage) {
-	panic("implement me")
+	marshal, err := json.Marshal(message)
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+	producerMessage := &sarama.ProducerMessage{
+		Topic: config.KafkaTopic,
+		Value: sarama.ByteEncoder(marshal),
+	}
+	if key := kubeEventKey(message); key != "" {
+		producerMessage.Key = sarama.StringEncoder(key)
+	}
+	kafkaProducerMutex.Lock()
+	defer kafkaProducerMutex.Unlock()
+	producer, err := getKafkaProducer()
+	if err != nil {
+		log.Println("[ERROR] Failed to create kafka producer:", err.Error())
+		return
+	}
+	select {
+	case producer.Input() <- producerMessage:
+	case <-time.After(kafkaPublishTimeout):
+		log.Println("[ERROR] Kafka producer is full, dropped kube event, dropped so far:", atomic.AddInt64(&kafkaDropped, 1))
+	}
+}
+
+// Flush closes the producer, delivering buffered messages, unless ctx is done first. Messages published afterwards are dropped.
+func (k kubeEventKafkaPublisher) Flush(ctx context.Context) error {
+	kafkaProducerMutex.Lock()
+	producer := kafkaProducer
+	kafkaProducer = nil
+	kafkaProducerClosed = true
+	kafkaProducerMutex.Unlock()
+	if producer == nil {
+		return nil
+	}
+	closed := make(chan error, 1)
+	go func() {
+		closed <- producer.Close()
+	}()
+	select {
+	case err := <-closed:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// kubeEventKey returns uid of the message's object, new object for update messages.
+func kubeEventKey(message v1.KubeEventMessage) string {
+	if message.Header.Uid != "" {
+		return message.Header.Uid
+	}
+	body := message.Body
+	if object, ok := body.(KubeObject); ok {
+		body = object.NewK8sObj
+	}
+	accessor, err := meta.Accessor(body)
+	if err != nil {
+		return ""
+	}
+	return string(accessor.GetUID())
+}
+
+// getKafkaProducer returns the shared producer, creating it on first call. Callers must hold kafkaProducerMutex.
+func getKafkaProducer() (sarama.AsyncProducer, error) {
+	if kafkaProducerClosed {
+		return nil, errors.New("kafka producer is closed")
+	}
+	if kafkaProducer != nil {
+		return kafkaProducer, nil
+	}
+	if len(config.KafkaBrokers) == 0 {
+		return nil, errors.New("no kafka broker is configured")
+	}
+	producerConfig, err := kafkaProducerConfig()
+	if err != nil {
+		return nil, err
+	}
+	producer, err := sarama.NewAsyncProducer(config.KafkaBrokers, producerConfig)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for each := range producer.Errors() {
+			log.Println("[ERROR] Failed to publish kube event to kafka:", each.Err.Error())
+		}
+	}()
+	kafkaProducer = producer
+	return kafkaProducer, nil
+}
+
+// kafkaProducerConfig returns config of an idempotent producer, batching and compressing messages.
+func kafkaProducerConfig() (*sarama.Config, error) {
+	producerConfig := sarama.NewConfig()
+	version, err := sarama.ParseKafkaVersion(config.KafkaVersion)
+	if err != nil {
+		return nil, err
+	}
+	producerConfig.Version = version
+	if config.AgentName != "" {
+		producerConfig.ClientID = config.AgentName
+	}
+	producerConfig.Producer.Idempotent = true
+	producerConfig.Producer.RequiredAcks = sarama.WaitForAll
+	producerConfig.Producer.Retry.Max = 10
+	producerConfig.Producer.Retry.Backoff = time.Millisecond * 250
+	producerConfig.Net.MaxOpenRequests = 1
+	producerConfig.Producer.Partitioner = sarama.NewHashPartitioner
+	producerConfig.Producer.Return.Successes = false
+	producerConfig.Producer.Return.Errors = true
+	producerConfig.Producer.Flush.Frequency = time.Millisecond * 500
+	producerConfig.Producer.Flush.Messages = 100
+	compression, ok := kafkaCompressionCodecs[strings.ToLower(config.KafkaCompression)]
+	if !ok {
+		return nil, errors.New("unsupported kafka compression: " + config.KafkaCompression)
+	}
+	producerConfig.Producer.Compression = compression
+	if config.KafkaTlsEnabled {
+		tlsConfig, err := kafkaTlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		producerConfig.Net.TLS.Enable = true
+		producerConfig.Net.TLS.Config = tlsConfig
+	}
+	if config.KafkaSaslMechanism != "" {
+		producerConfig.Net.SASL.Enable = true
+		producerConfig.Net.SASL.User = config.KafkaSaslUser
+		producerConfig.Net.SASL.Password = config.KafkaSaslPassword
+		switch sarama.SASLMechanism(config.KafkaSaslMechanism) {
+		case sarama.SASLTypePlaintext:
+			producerConfig.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		case sarama.SASLTypeSCRAMSHA256:
+			producerConfig.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+			producerConfig.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &scramClient{HashGeneratorFcn: sha256.New}
+			}
+		case sarama.SASLTypeSCRAMSHA512:
+			producerConfig.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+			producerConfig.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &scramClient{HashGeneratorFcn: sha512.New}
+			}
+		default:
+			return nil, errors.New("unsupported kafka sasl mechanism: " + config.KafkaSaslMechanism)
+		}
+	}
+	return producerConfig, producerConfig.Validate()
+}
+
+func kafkaTlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.KafkaTlsInsecureSkipVerify}
+	if config.KafkaTlsCaFile != "" {
+		ca, err := ioutil.ReadFile(config.KafkaTlsCaFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.New("no certificate found in " + config.KafkaTlsCaFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if config.KafkaTlsCertFile != "" && config.KafkaTlsKeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(config.KafkaTlsCertFile, config.KafkaTlsKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
+}
+
+// scramClient sarama.SCRAMClient backed by xdg scram.
+type scramClient struct {
+	*scram.ClientConversation
+	scram.HashGeneratorFcn
+}
+
+func (s *scramClient) Begin(userName, password, authzID string) error {
+	client, err := s.HashGeneratorFcn.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	s.ClientConversation = client.NewConversation()
+	return nil
+}
+
+func (s *scramClient) Step(challenge string) (string, error) {
+	return s.ClientConversation.Step(challenge)
+}
+
+func (s *scramClient) Done() bool {
+	return s.ClientConversation.Done()
 }
 
+// NewKubeEventKafkaPublisher returns KubeEventPublisher type service
 func NewKubeEventKafkaPublisher() service.KubeEventPublisher {
 	return kubeEventKafkaPublisher{}
 }
@@ -0,0 +1,279 @@
+package logic
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/klovercloud-ci-cd/agent/config"
+	v1 "github.com/klovercloud-ci-cd/agent/core/v1"
+	"github.com/klovercloud-ci-cd/agent/enums"
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const testKafkaTopic = "kube_events"
+
+// keyRecorder records keys of messages dispatched by the producer.
+type keyRecorder struct {
+	sync.Mutex
+	keys []string
+}
+
+func (k *keyRecorder) OnSend(message *sarama.ProducerMessage) {
+	k.Lock()
+	defer k.Unlock()
+	if message.Key == nil {
+		k.keys = append(k.keys, "")
+		return
+	}
+	key, _ := message.Key.Encode()
+	k.keys = append(k.keys, string(key))
+}
+
+func (k *keyRecorder) recorded() []string {
+	k.Lock()
+	defer k.Unlock()
+	return append([]string{}, k.keys...)
+}
+
+// withKafkaConfig sets kafka config of a test, restoring the previous one once the test is done.
+func withKafkaConfig(t *testing.T) {
+	brokers, topic, version, compression := config.KafkaBrokers, config.KafkaTopic, config.KafkaVersion, config.KafkaCompression
+	mechanism, user, password := config.KafkaSaslMechanism, config.KafkaSaslUser, config.KafkaSaslPassword
+	tlsEnabled, caFile, certFile, keyFile := config.KafkaTlsEnabled, config.KafkaTlsCaFile, config.KafkaTlsCertFile, config.KafkaTlsKeyFile
+	t.Cleanup(func() {
+		config.KafkaBrokers, config.KafkaTopic, config.KafkaVersion, config.KafkaCompression = brokers, topic, version, compression
+		config.KafkaSaslMechanism, config.KafkaSaslUser, config.KafkaSaslPassword = mechanism, user, password
+		config.KafkaTlsEnabled, config.KafkaTlsCaFile, config.KafkaTlsCertFile, config.KafkaTlsKeyFile = tlsEnabled, caFile, certFile, keyFile
+	})
+	config.KafkaTopic = testKafkaTopic
+	config.KafkaVersion = "2.1.0"
+	config.KafkaCompression = "none"
+	config.KafkaSaslMechanism = ""
+	config.KafkaTlsEnabled = false
+	config.KafkaTlsCaFile = ""
+	config.KafkaTlsCertFile = ""
+	config.KafkaTlsKeyFile = ""
+}
+
+func newTestKafkaBroker(t *testing.T) *sarama.MockBroker {
+	broker := sarama.NewMockBroker(t, 1)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(testKafkaTopic, 0, broker.BrokerID()),
+		"InitProducerIDRequest": sarama.NewMockWrapper(&sarama.InitProducerIDResponse{ProducerID: 1}),
+		"ProduceRequest":        sarama.NewMockProduceResponse(t).SetVersion(3),
+	})
+	t.Cleanup(broker.Close)
+	return broker
+}
+
+func testPod(uid string) *coreV1.Pod {
+	return &coreV1.Pod{ObjectMeta: metaV1.ObjectMeta{Name: "pod", Namespace: "default", UID: types.UID(uid)}}
+}
+
+func TestKubeEventKafkaPublisher_Publish(t *testing.T) {
+	withKafkaConfig(t)
+	broker := newTestKafkaBroker(t)
+	config.KafkaBrokers = []string{broker.Addr()}
+	producerConfig, err := kafkaProducerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := &keyRecorder{}
+	producerConfig.Producer.Interceptors = []sarama.ProducerInterceptor{recorder}
+	producer, err := sarama.NewAsyncProducer(config.KafkaBrokers, producerConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kafkaProducerMutex.Lock()
+	kafkaProducer = producer
+	kafkaProducerClosed = false
+	kafkaProducerMutex.Unlock()
+
+	publisher := NewKubeEventKafkaPublisher()
+	publisher.Publish(v1.KubeEventMessage{Body: testPod("uid-added"), Header: v1.MessageHeader{Command: enums.ADD}})
+	publisher.Publish(v1.KubeEventMessage{Body: KubeObject{OldK8sObj: testPod("uid-old"), NewK8sObj: testPod("uid-new")}, Header: v1.MessageHeader{Command: enums.UPDATE}})
+	publisher.Publish(v1.KubeEventMessage{Body: testPod("uid-body"), Header: v1.MessageHeader{Command: enums.DELETE, Uid: "uid-header"}})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	if err := publisher.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// producer is closed, publishing afterwards must neither panic nor create a new producer.
+	publisher.Publish(v1.KubeEventMessage{Body: testPod("uid-late"), Header: v1.MessageHeader{Command: enums.ADD}})
+	kafkaProducerMutex.Lock()
+	reopened := kafkaProducer != nil
+	kafkaProducerMutex.Unlock()
+	if reopened {
+		t.Error("Expected no producer to be created after flush")
+	}
+
+	expected := []string{"uid-added", "uid-new", "uid-header"}
+	keys := recorder.recorded()
+	if strings.Join(keys, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected keys %v, got %v", expected, keys)
+	}
+	produced := 0
+	for _, each := range broker.History() {
+		request, ok := each.Request.(*sarama.ProduceRequest)
+		if !ok {
+			continue
+		}
+		produced++
+		if request.RequiredAcks != sarama.WaitForAll {
+			t.Errorf("Expected produce request to wait for all replicas, got acks %d", request.RequiredAcks)
+		}
+	}
+	if produced == 0 {
+		t.Error("Expected messages to be produced to broker")
+	}
+}
+
+func TestKafkaProducerConfig(t *testing.T) {
+	withKafkaConfig(t)
+	producerConfig, err := kafkaProducerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if producerConfig.Producer.RequiredAcks != sarama.WaitForAll {
+		t.Errorf("Expected RequiredAcks WaitForAll, got %d", producerConfig.Producer.RequiredAcks)
+	}
+	if producerConfig.Net.MaxOpenRequests != 1 {
+		t.Errorf("Expected MaxOpenRequests 1, got %d", producerConfig.Net.MaxOpenRequests)
+	}
+	if !producerConfig.Producer.Idempotent {
+		t.Error("Expected idempotent producer")
+	}
+}
+
+func TestKafkaProducerConfig_Compression(t *testing.T) {
+	withKafkaConfig(t)
+	testData := []struct {
+		compression string
+		codec       sarama.CompressionCodec
+	}{
+		{"none", sarama.CompressionNone},
+		{"gzip", sarama.CompressionGZIP},
+		{"snappy", sarama.CompressionSnappy},
+		{"LZ4", sarama.CompressionLZ4},
+		{"zstd", sarama.CompressionZSTD},
+	}
+	for _, each := range testData {
+		config.KafkaCompression = each.compression
+		producerConfig, err := kafkaProducerConfig()
+		if err != nil {
+			t.Errorf("Compression %s: %s", each.compression, err.Error())
+			continue
+		}
+		if producerConfig.Producer.Compression != each.codec {
+			t.Errorf("Compression %s: expected codec %s, got %s", each.compression, each.codec, producerConfig.Producer.Compression)
+		}
+	}
+	config.KafkaCompression = "brotli"
+	if _, err := kafkaProducerConfig(); err == nil {
+		t.Error("Expected unknown compression to be rejected")
+	}
+}
+
+func TestKafkaProducerConfig_Sasl(t *testing.T) {
+	withKafkaConfig(t)
+	config.KafkaSaslUser = "user"
+	config.KafkaSaslPassword = "password"
+	for _, each := range []string{sarama.SASLTypePlaintext, sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512} {
+		config.KafkaSaslMechanism = each
+		producerConfig, err := kafkaProducerConfig()
+		if err != nil {
+			t.Errorf("Mechanism %s: %s", each, err.Error())
+			continue
+		}
+		if string(producerConfig.Net.SASL.Mechanism) != each {
+			t.Errorf("Mechanism %s: got %s", each, producerConfig.Net.SASL.Mechanism)
+		}
+	}
+	config.KafkaSaslMechanism = "GSSAPI-UNKNOWN"
+	if _, err := kafkaProducerConfig(); err == nil {
+		t.Error("Expected unknown sasl mechanism to be rejected")
+	}
+}
+
+func TestKafkaProducerConfig_Tls(t *testing.T) {
+	withKafkaConfig(t)
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.pem")
+	if err := ioutil.WriteFile(invalid, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	testData := []struct {
+		name                      string
+		caFile, certFile, keyFile string
+	}{
+		{"missing ca file", filepath.Join(dir, "missing-ca.pem"), "", ""},
+		{"ca file without certificate", invalid, "", ""},
+		{"missing client certificate", "", filepath.Join(dir, "missing-cert.pem"), filepath.Join(dir, "missing-key.pem")},
+		{"invalid client certificate", "", invalid, invalid},
+	}
+	config.KafkaTlsEnabled = true
+	for _, each := range testData {
+		config.KafkaTlsCaFile, config.KafkaTlsCertFile, config.KafkaTlsKeyFile = each.caFile, each.certFile, each.keyFile
+		if _, err := kafkaProducerConfig(); err == nil {
+			t.Errorf("%s: expected error", each.name)
+		}
+	}
+	config.KafkaTlsCaFile, config.KafkaTlsCertFile, config.KafkaTlsKeyFile = "", "", ""
+	producerConfig, err := kafkaProducerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !producerConfig.Net.TLS.Enable || producerConfig.Net.TLS.Config == nil {
+		t.Error("Expected tls to be enabled")
+	}
+}
+
+// stalledProducer producer whose buffers are full, it never takes a message.
+type stalledProducer struct {
+	input chan *sarama.ProducerMessage
+}
+
+func (s stalledProducer) AsyncClose()                               {}
+func (s stalledProducer) Close() error                              { return nil }
+func (s stalledProducer) Input() chan<- *sarama.ProducerMessage     { return s.input }
+func (s stalledProducer) Successes() <-chan *sarama.ProducerMessage { return nil }
+func (s stalledProducer) Errors() <-chan *sarama.ProducerError      { return nil }
+
+func TestKubeEventKafkaPublisher_PublishDropsWhenProducerIsFull(t *testing.T) {
+	withKafkaConfig(t)
+	kafkaProducerMutex.Lock()
+	kafkaProducer = stalledProducer{input: make(chan *sarama.ProducerMessage)}
+	kafkaProducerClosed = false
+	kafkaProducerMutex.Unlock()
+	t.Cleanup(func() {
+		kafkaProducerMutex.Lock()
+		kafkaProducer = nil
+		kafkaProducerMutex.Unlock()
+	})
+	dropped := atomic.LoadInt64(&kafkaDropped)
+	published := make(chan struct{})
+	go func() {
+		NewKubeEventKafkaPublisher().Publish(v1.KubeEventMessage{Body: testPod("uid-dropped"), Header: v1.MessageHeader{Command: enums.ADD}})
+		close(published)
+	}()
+	select {
+	case <-published:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Expected publish not to block on a full producer")
+	}
+	if atomic.LoadInt64(&kafkaDropped) != dropped+1 {
+		t.Error("Expected message to be counted as dropped")
+	}
+}
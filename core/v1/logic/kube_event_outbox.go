@@ -0,0 +1,388 @@
+package logic
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/klovercloud-ci-cd/agent/config"
+	v1 "github.com/klovercloud-ci-cd/agent/core/v1"
+	"github.com/klovercloud-ci-cd/agent/core/v1/service"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	outboxRetryInterval    = time.Millisecond * 500
+	maxOutboxRetryInterval = time.Second * 30
+	// outboxSegmentSize is the size a spool segment is rotated at.
+	outboxSegmentSize = 4 * 1024 * 1024
+)
+
+// kubeEventOutbox delivers kube event messages to api service in batches, from a bounded in-memory queue.
+// Messages overflowing the queue are spilled to segment files in the spool directory, if one is configured,
+// and dropped otherwise. Once spilling has started, every message goes to the spool until it is drained, so
+// messages are delivered in the order they were published.
+type kubeEventOutbox struct {
+	httpClient service.HttpClient
+	queue      chan json.RawMessage
+	stop       chan struct{}
+	done       chan struct{}
+	sending    int32
+	flushOnce  sync.Once
+	spoolMutex sync.Mutex
+	// segment is the spool segment messages are appended to, empty if none is open.
+	segment     string
+	segmentSize int64
+	spooled     int64
+	spoolSize   int64
+	metrics     v1.KubeEventOutboxMetrics
+}
+
+// outbox is shared by every kube event http publisher of the agent, it is started on first publish.
+var (
+	outbox      *kubeEventOutbox
+	outboxMutex sync.Mutex
+)
+
+func getKubeEventOutbox(httpClient service.HttpClient) *kubeEventOutbox {
+	outboxMutex.Lock()
+	defer outboxMutex.Unlock()
+	if outbox == nil {
+		outbox = &kubeEventOutbox{
+			httpClient: httpClient,
+			queue:      make(chan json.RawMessage, config.KubeEventQueueSize),
+			stop:       make(chan struct{}),
+			done:       make(chan struct{}),
+		}
+		outbox.recoverSpool()
+		go outbox.run()
+	}
+	return outbox
+}
+
+func (o *kubeEventOutbox) enqueue(message json.RawMessage) {
+	o.spoolMutex.Lock()
+	spooling := o.spooled > 0
+	o.spoolMutex.Unlock()
+	if !spooling {
+		select {
+		case o.queue <- message:
+			atomic.AddInt64(&o.metrics.Queued, 1)
+			return
+		default:
+		}
+	}
+	if err := o.spill([]json.RawMessage{message}); err != nil {
+		atomic.AddInt64(&o.metrics.Dropped, 1)
+		log.Println("[ERROR] Dropping kube event:", err.Error())
+	}
+}
+
+// run sends batches until outbox is stopped. Spooled messages are sent once the in-memory queue is empty.
+func (o *kubeEventOutbox) run() {
+	defer close(o.done)
+	var pending []json.RawMessage
+	pendingSegment := ""
+	for {
+		batch := o.collect()
+		fromSpool := false
+		if len(batch) == 0 && len(pending) == 0 && pendingSegment != "" {
+			o.removeSegment(pendingSegment, 0)
+			pendingSegment = ""
+		}
+		if len(batch) == 0 && len(pending) == 0 {
+			pending, pendingSegment = o.loadSegment()
+		}
+		if len(batch) == 0 && len(pending) > 0 {
+			size := config.KubeEventBatchSize
+			if size > len(pending) {
+				size = len(pending)
+			}
+			batch, fromSpool = pending[:size], true
+		}
+		if len(batch) == 0 {
+			select {
+			case <-o.stop:
+				return
+			case message := <-o.queue:
+				batch = append([]json.RawMessage{message}, o.collect()...)
+			case <-time.After(time.Second):
+				continue
+			}
+		}
+		atomic.StoreInt32(&o.sending, 1)
+		sent := o.send(batch)
+		atomic.StoreInt32(&o.sending, 0)
+		if !sent {
+			undelivered := o.drain()
+			if !fromSpool {
+				undelivered = append(batch, undelivered...)
+			}
+			if err := o.spill(undelivered); err != nil {
+				atomic.AddInt64(&o.metrics.Dropped, int64(len(undelivered)))
+				log.Println("[ERROR] Dropping", len(undelivered), "undelivered kube events:", err.Error())
+			}
+			return
+		}
+		if fromSpool {
+			pending = pending[len(batch):]
+			o.spoolMutex.Lock()
+			o.spooled -= int64(len(batch))
+			o.spoolMutex.Unlock()
+		}
+	}
+}
+
+// collect takes up to a batch of messages from the in-memory queue without waiting.
+func (o *kubeEventOutbox) collect() []json.RawMessage {
+	var batch []json.RawMessage
+	for len(batch) < config.KubeEventBatchSize {
+		select {
+		case message := <-o.queue:
+			batch = append(batch, message)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+func (o *kubeEventOutbox) drain() []json.RawMessage {
+	var messages []json.RawMessage
+	for {
+		select {
+		case message := <-o.queue:
+			messages = append(messages, message)
+		default:
+			return messages
+		}
+	}
+}
+
+// send posts batch to api service, retrying with backoff until it is accepted. Returns false if outbox is stopped first.
+func (o *kubeEventOutbox) send(batch []json.RawMessage) bool {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		log.Println("[ERROR] Dropping", len(batch), "malformed kube events:", err.Error())
+		atomic.AddInt64(&o.metrics.Dropped, int64(len(batch)))
+		return true
+	}
+	header := make(map[string]string)
+	header["token"] = config.Token
+	header["Content-Type"] = "application/json"
+	backoff := outboxRetryInterval
+	for {
+		err = o.httpClient.Post(config.ApiServiceUrl+"/kube_events", header, body)
+		if err == nil {
+			atomic.AddInt64(&o.metrics.Sent, int64(len(batch)))
+			atomic.AddInt64(&o.metrics.Batches, 1)
+			return true
+		}
+		atomic.AddInt64(&o.metrics.Failures, 1)
+		log.Println("[ERROR] Failed to deliver", len(batch), "kube events, retrying in", backoff.String()+":", err.Error())
+		select {
+		case <-o.stop:
+			return false
+		case <-time.After(backoff):
+		}
+		atomic.AddInt64(&o.metrics.Retries, 1)
+		backoff = backoff * 2
+		if backoff > maxOutboxRetryInterval {
+			backoff = maxOutboxRetryInterval
+		}
+	}
+}
+
+// spill appends messages to the open spool segment, rotating it once it grows past segment size.
+// Messages are spilled all together or not at all.
+func (o *kubeEventOutbox) spill(messages []json.RawMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	if config.KubeEventSpoolDir == "" {
+		return errors.New("kube event queue is full and no spool directory is configured")
+	}
+	o.spoolMutex.Lock()
+	defer o.spoolMutex.Unlock()
+	var data []byte
+	for _, each := range messages {
+		data = append(data, each...)
+		data = append(data, '\n')
+	}
+	if o.spoolSize+int64(len(data)) > config.KubeEventSpoolMaxSize {
+		return errors.New("kube event spool is full")
+	}
+	if err := os.MkdirAll(config.KubeEventSpoolDir, 0700); err != nil {
+		return err
+	}
+	if o.segment == "" || o.segmentSize > outboxSegmentSize {
+		o.segment = filepath.Join(config.KubeEventSpoolDir, "segment-"+strconv.FormatInt(time.Now().UnixNano(), 10)+".log")
+		o.segmentSize = 0
+	}
+	file, err := os.OpenFile(o.segment, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		return err
+	}
+	o.segmentSize += int64(len(data))
+	o.spoolSize += int64(len(data))
+	o.spooled += int64(len(messages))
+	atomic.AddInt64(&o.metrics.Spilled, int64(len(messages)))
+	return nil
+}
+
+func (o *kubeEventOutbox) segments() []string {
+	files, err := filepath.Glob(filepath.Join(config.KubeEventSpoolDir, "segment-*.log"))
+	if err != nil {
+		return nil
+	}
+	sort.Strings(files)
+	return files
+}
+
+// loadSegment reads the oldest spool segment. The open segment is closed first, so nothing is appended to it while it is sent.
+func (o *kubeEventOutbox) loadSegment() ([]json.RawMessage, string) {
+	if config.KubeEventSpoolDir == "" {
+		return nil, ""
+	}
+	o.spoolMutex.Lock()
+	defer o.spoolMutex.Unlock()
+	if o.spooled == 0 {
+		return nil, ""
+	}
+	segments := o.segments()
+	if len(segments) == 0 {
+		return nil, ""
+	}
+	if segments[0] == o.segment {
+		o.segment = ""
+	}
+	data, err := ioutil.ReadFile(segments[0])
+	if err != nil {
+		log.Println("[ERROR] Failed to read kube event spool:", err.Error())
+		return nil, ""
+	}
+	var messages []json.RawMessage
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), outboxSegmentSize*2)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			messages = append(messages, json.RawMessage(line))
+		}
+	}
+	if len(messages) == 0 {
+		o.removeSegmentLocked(segments[0], int64(len(data)))
+		return nil, ""
+	}
+	return messages, segments[0]
+}
+
+func (o *kubeEventOutbox) removeSegment(segment string, size int64) {
+	o.spoolMutex.Lock()
+	defer o.spoolMutex.Unlock()
+	o.removeSegmentLocked(segment, size)
+}
+
+func (o *kubeEventOutbox) removeSegmentLocked(segment string, size int64) {
+	if size == 0 {
+		if info, err := os.Stat(segment); err == nil {
+			size = info.Size()
+		}
+	}
+	if err := os.Remove(segment); err != nil && !os.IsNotExist(err) {
+		log.Println("[ERROR] Failed to remove kube event spool segment:", err.Error())
+		return
+	}
+	o.spoolSize -= size
+	if o.spoolSize < 0 {
+		o.spoolSize = 0
+	}
+}
+
+// recoverSpool accounts segments left behind by a previous run, so they are delivered before new messages.
+func (o *kubeEventOutbox) recoverSpool() {
+	if config.KubeEventSpoolDir == "" {
+		return
+	}
+	for _, each := range o.segments() {
+		data, err := ioutil.ReadFile(each)
+		if err != nil {
+			continue
+		}
+		o.spoolSize += int64(len(data))
+		o.spooled += int64(bytes.Count(data, []byte("\n")))
+	}
+	if o.spooled > 0 {
+		log.Println("Recovered", o.spooled, "spooled kube events")
+	}
+}
+
+// flush waits until queued messages and the batch being sent are delivered or ctx is done, then stops the outbox.
+// Undelivered messages are spilled.
+func (o *kubeEventOutbox) flush(ctx context.Context) error {
+	var err error
+	o.flushOnce.Do(func() {
+		ticker := time.NewTicker(time.Millisecond * 100)
+		defer ticker.Stop()
+	wait:
+		for len(o.queue) > 0 || atomic.LoadInt32(&o.sending) == 1 {
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+				break wait
+			case <-ticker.C:
+			}
+		}
+		close(o.stop)
+		<-o.done
+	})
+	return err
+}
+
+func (o *kubeEventOutbox) snapshot() v1.KubeEventOutboxMetrics {
+	o.spoolMutex.Lock()
+	spooled := o.spooled
+	o.spoolMutex.Unlock()
+	return v1.KubeEventOutboxMetrics{
+		Queued:   atomic.LoadInt64(&o.metrics.Queued),
+		Sent:     atomic.LoadInt64(&o.metrics.Sent),
+		Batches:  atomic.LoadInt64(&o.metrics.Batches),
+		Failures: atomic.LoadInt64(&o.metrics.Failures),
+		Retries:  atomic.LoadInt64(&o.metrics.Retries),
+		Spilled:  atomic.LoadInt64(&o.metrics.Spilled),
+		Dropped:  atomic.LoadInt64(&o.metrics.Dropped),
+		Pending:  int64(len(o.queue)) + spooled,
+	}
+}
+
+type kubeEventOutboxService struct {
+}
+
+// Metrics returns delivery metrics of the outbox, zero if nothing has been published through it.
+func (k kubeEventOutboxService) Metrics() v1.KubeEventOutboxMetrics {
+	outboxMutex.Lock()
+	current := outbox
+	outboxMutex.Unlock()
+	if current == nil {
+		return v1.KubeEventOutboxMetrics{}
+	}
+	return current.snapshot()
+}
+
+// NewKubeEventOutboxService returns KubeEventOutbox type service
+func NewKubeEventOutboxService() service.KubeEventOutbox {
+	return kubeEventOutboxService{}
+}
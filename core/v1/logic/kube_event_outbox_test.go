@@ -0,0 +1,249 @@
+package logic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klovercloud-ci-cd/agent/config"
+)
+
+// batchRecorder http client recording messages of delivered batches. Posts wait for gate, if set, and the first
+// failures posts are rejected.
+type batchRecorder struct {
+	sync.Mutex
+	gate     chan struct{}
+	failures int
+	messages []int
+}
+
+func (b *batchRecorder) Post(url string, header map[string]string, body []byte) error {
+	if b.gate != nil {
+		<-b.gate
+	}
+	b.Lock()
+	defer b.Unlock()
+	if b.failures != 0 {
+		b.failures--
+		return errors.New("Status: 503 Service Unavailable, code: 503")
+	}
+	var batch []int
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return err
+	}
+	b.messages = append(b.messages, batch...)
+	return nil
+}
+
+func (b *batchRecorder) Get(url string, header map[string]string) ([]byte, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (b *batchRecorder) Stream(ctx context.Context, url string, header map[string]string) (io.ReadCloser, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (b *batchRecorder) delivered() []int {
+	b.Lock()
+	defer b.Unlock()
+	return append([]int{}, b.messages...)
+}
+
+// withOutboxConfig sets outbox config of a test, restoring the previous one once the test is done.
+func withOutboxConfig(t *testing.T, queueSize int, spoolDir string) {
+	queue, batch, dir, maxSize := config.KubeEventQueueSize, config.KubeEventBatchSize, config.KubeEventSpoolDir, config.KubeEventSpoolMaxSize
+	t.Cleanup(func() {
+		config.KubeEventQueueSize, config.KubeEventBatchSize, config.KubeEventSpoolDir, config.KubeEventSpoolMaxSize = queue, batch, dir, maxSize
+	})
+	config.KubeEventQueueSize = queueSize
+	config.KubeEventBatchSize = 3
+	config.KubeEventSpoolDir = spoolDir
+	config.KubeEventSpoolMaxSize = 1024 * 1024
+}
+
+func newTestOutbox(client *batchRecorder) *kubeEventOutbox {
+	o := &kubeEventOutbox{
+		httpClient: client,
+		queue:      make(chan json.RawMessage, config.KubeEventQueueSize),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	o.recoverSpool()
+	go o.run()
+	return o
+}
+
+func enqueueTestMessages(o *kubeEventOutbox, from, to int) {
+	for i := from; i < to; i++ {
+		o.enqueue(json.RawMessage(strconv.Itoa(i)))
+	}
+}
+
+// waitDelivered waits until count messages are delivered and nothing is pending anymore.
+func waitDelivered(t *testing.T, o *kubeEventOutbox, client *batchRecorder, count int) {
+	deadline := time.Now().Add(time.Second * 10)
+	for len(client.delivered()) < count || o.snapshot().Pending > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d messages to be delivered, got %v with %d pending", count, client.delivered(), o.snapshot().Pending)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func sequence(from, to int) string {
+	var messages []int
+	for i := from; i < to; i++ {
+		messages = append(messages, i)
+	}
+	return fmt.Sprint(messages)
+}
+
+func TestKubeEventOutbox_Flush(t *testing.T) {
+	withOutboxConfig(t, 100, "")
+	client := &batchRecorder{}
+	o := newTestOutbox(client)
+	enqueueTestMessages(o, 0, 10)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	if err := o.flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if delivered := fmt.Sprint(client.delivered()); delivered != sequence(0, 10) {
+		t.Errorf("Expected %s to be delivered, got %s", sequence(0, 10), delivered)
+	}
+	metrics := o.snapshot()
+	if metrics.Sent != 10 || metrics.Queued != 10 || metrics.Pending != 0 || metrics.Dropped != 0 {
+		t.Errorf("Unexpected metrics %+v", metrics)
+	}
+}
+
+func TestKubeEventOutbox_Spill(t *testing.T) {
+	testData := []struct {
+		name      string
+		spoolDir  string
+		delivered string
+	}{
+		{"spilled in order", t.TempDir(), sequence(0, 10)},
+		{"dropped without spool", "", "[0 1 2]"},
+	}
+	for _, each := range testData {
+		withOutboxConfig(t, 2, each.spoolDir)
+		client := &batchRecorder{gate: make(chan struct{})}
+		o := newTestOutbox(client)
+		// first message is taken by the stalled delivery, the next two fill the queue.
+		o.enqueue(json.RawMessage("0"))
+		for o.snapshot().Pending > 0 {
+			time.Sleep(time.Millisecond * 10)
+		}
+		enqueueTestMessages(o, 1, 10)
+		metrics := o.snapshot()
+		if each.spoolDir == "" {
+			if metrics.Dropped != 7 || metrics.Spilled != 0 {
+				t.Errorf("%s: unexpected metrics %+v", each.name, metrics)
+			}
+			close(client.gate)
+			waitDelivered(t, o, client, 3)
+		} else {
+			if metrics.Spilled != 7 || metrics.Dropped != 0 {
+				t.Errorf("%s: unexpected metrics %+v", each.name, metrics)
+			}
+			close(client.gate)
+			waitDelivered(t, o, client, 10)
+		}
+		if delivered := fmt.Sprint(client.delivered()); delivered != each.delivered {
+			t.Errorf("%s: expected %s to be delivered, got %s", each.name, each.delivered, delivered)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		if err := o.flush(ctx); err != nil {
+			t.Errorf("%s: %s", each.name, err.Error())
+		}
+		cancel()
+		if each.spoolDir != "" {
+			if segments, _ := filepath.Glob(filepath.Join(each.spoolDir, "segment-*.log")); len(segments) != 0 {
+				t.Errorf("%s: expected delivered segments to be removed, got %v", each.name, segments)
+			}
+		}
+	}
+}
+
+func TestKubeEventOutbox_Recover(t *testing.T) {
+	dir := t.TempDir()
+	withOutboxConfig(t, 100, dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "segment-0000000000000000001.log"), []byte("0\n1\n2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "segment-0000000000000000002.log"), []byte("3\n4\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	client := &batchRecorder{gate: make(chan struct{})}
+	o := newTestOutbox(client)
+	// recovered messages are delivered before new ones, segments are named by the nanosecond they were opened at.
+	enqueueTestMessages(o, 5, 7)
+	close(client.gate)
+	waitDelivered(t, o, client, 7)
+	if delivered := fmt.Sprint(client.delivered()); delivered != sequence(0, 7) {
+		t.Errorf("Expected %s to be delivered, got %s", sequence(0, 7), delivered)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	if err := o.flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if segments, _ := filepath.Glob(filepath.Join(dir, "segment-*.log")); len(segments) != 0 {
+		t.Errorf("Expected recovered segments to be removed, got %v", segments)
+	}
+}
+
+func TestKubeEventOutbox_Backoff(t *testing.T) {
+	withOutboxConfig(t, 100, "")
+	client := &batchRecorder{failures: 2}
+	o := newTestOutbox(client)
+	enqueueTestMessages(o, 0, 2)
+	waitDelivered(t, o, client, 2)
+	metrics := o.snapshot()
+	if metrics.Failures != 2 || metrics.Retries != 2 || metrics.Sent != 2 {
+		t.Errorf("Unexpected metrics %+v", metrics)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	if err := o.flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKubeEventOutbox_FlushSpillsUndelivered(t *testing.T) {
+	dir := t.TempDir()
+	withOutboxConfig(t, 100, dir)
+	client := &batchRecorder{failures: -1}
+	o := newTestOutbox(client)
+	enqueueTestMessages(o, 0, 5)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+	if err := o.flush(ctx); err == nil {
+		t.Error("Expected flush to time out")
+	}
+	if metrics := o.snapshot(); metrics.Spilled != 5 || metrics.Sent != 0 {
+		t.Errorf("Unexpected metrics %+v", metrics)
+	}
+
+	// spilled messages are delivered by the next run.
+	client = &batchRecorder{}
+	o = newTestOutbox(client)
+	waitDelivered(t, o, client, 5)
+	if delivered := fmt.Sprint(client.delivered()); delivered != sequence(0, 5) {
+		t.Errorf("Expected %s to be delivered, got %s", sequence(0, 5), delivered)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	if err := o.flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
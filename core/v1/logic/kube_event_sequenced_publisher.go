@@ -0,0 +1,159 @@
+package logic
+
+import (
+	"context"
+	"github.com/klovercloud-ci-cd/agent/config"
+	v1 "github.com/klovercloud-ci-cd/agent/core/v1"
+	"github.com/klovercloud-ci-cd/agent/core/v1/service"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"log"
+	"sync"
+	"time"
+)
+
+// kubeEventEpoch identifies the agent boot, offsets restart from 1 on every boot.
+var kubeEventEpoch = time.Now().UnixNano() / int64(time.Millisecond)
+
+// kubeEventBuffer ring buffer of stamped messages, shared by every sequenced publisher of the agent. Stamped messages are
+// queued to pending in offset order and handed over to underlying publishers by a single dispatcher, outside the lock.
+var kubeEventBuffer = struct {
+	sync.Mutex
+	offset      int
+	messages    []v1.KubeEventMessage
+	pending     []kubeEventDispatch
+	dispatching bool
+	wake        chan struct{}
+	once        sync.Once
+}{wake: make(chan struct{}, 1)}
+
+// kubeEventDispatch message queued for the underlying publisher.
+type kubeEventDispatch struct {
+	publisher service.KubeEventPublisher
+	message   v1.KubeEventMessage
+}
+
+type sequencedKubeEventPublisher struct {
+	publisher service.KubeEventPublisher
+}
+
+// Publish stamps message with the next offset, boot epoch, uid and resourceVersion of its object,
+// buffers it for replay and queues it for the underlying publisher. Queued messages are handed over in offset order.
+func (s sequencedKubeEventPublisher) Publish(message v1.KubeEventMessage) {
+	body := message.Body
+	if object, ok := body.(KubeObject); ok {
+		body = object.NewK8sObj
+	}
+	if accessor, err := meta.Accessor(body); err == nil {
+		message.Header.Uid = string(accessor.GetUID())
+		message.Header.ResourceVersion = accessor.GetResourceVersion()
+	}
+	message.Header.Epoch = kubeEventEpoch
+	kubeEventBuffer.Lock()
+	defer kubeEventBuffer.Unlock()
+	kubeEventBuffer.offset++
+	message.Header.Offset = kubeEventBuffer.offset
+	kubeEventBuffer.messages = append(kubeEventBuffer.messages, message)
+	if len(kubeEventBuffer.messages) > config.KubeEventBufferSize {
+		kubeEventBuffer.messages = kubeEventBuffer.messages[len(kubeEventBuffer.messages)-config.KubeEventBufferSize:]
+	}
+	s.queue(message)
+}
+
+// queue queues messages for the underlying publisher. Callers must hold the buffer lock. If the underlying publisher falls
+// behind by more than twice the buffer size, oldest queued messages are dropped, consumers can still replay buffered ones.
+func (s sequencedKubeEventPublisher) queue(messages ...v1.KubeEventMessage) {
+	kubeEventBuffer.once.Do(func() {
+		go dispatchKubeEvents()
+	})
+	for _, each := range messages {
+		kubeEventBuffer.pending = append(kubeEventBuffer.pending, kubeEventDispatch{publisher: s.publisher, message: each})
+	}
+	if limit := 2 * config.KubeEventBufferSize; len(kubeEventBuffer.pending) > limit {
+		log.Println("[ERROR] Kube event publisher is behind, dropped", len(kubeEventBuffer.pending)-limit, "queued messages")
+		kubeEventBuffer.pending = kubeEventBuffer.pending[len(kubeEventBuffer.pending)-limit:]
+	}
+	select {
+	case kubeEventBuffer.wake <- struct{}{}:
+	default:
+	}
+}
+
+// dispatchKubeEvents hands queued messages over to their publishers, one at a time in queue order.
+func dispatchKubeEvents() {
+	for range kubeEventBuffer.wake {
+		for {
+			kubeEventBuffer.Lock()
+			pending := kubeEventBuffer.pending
+			kubeEventBuffer.pending = nil
+			kubeEventBuffer.dispatching = len(pending) > 0
+			kubeEventBuffer.Unlock()
+			if len(pending) == 0 {
+				break
+			}
+			for _, each := range pending {
+				each.publisher.Publish(each.message)
+			}
+		}
+	}
+}
+
+// Flush hands queued messages over and flushes the underlying publisher, unless ctx is done first.
+func (s sequencedKubeEventPublisher) Flush(ctx context.Context) error {
+	for {
+		kubeEventBuffer.Lock()
+		drained := len(kubeEventBuffer.pending) == 0 && !kubeEventBuffer.dispatching
+		kubeEventBuffer.Unlock()
+		if drained {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Millisecond * 10):
+		}
+	}
+	return s.publisher.Flush(ctx)
+}
+
+// Replay resends buffered messages from the given offset on. Offsets of another epoch belong to a previous boot,
+// so every buffered message is resent. Replayed messages are queued at once, so live messages are not interleaved with them.
+func (s sequencedKubeEventPublisher) Replay(epoch int64, from int) v1.KubeEventReplay {
+	kubeEventBuffer.Lock()
+	defer kubeEventBuffer.Unlock()
+	latest := kubeEventBuffer.offset
+	oldest := latest - len(kubeEventBuffer.messages) + 1
+	if epoch != kubeEventEpoch || from < 1 {
+		from = 1
+	}
+	result := v1.KubeEventReplay{
+		Epoch:  kubeEventEpoch,
+		From:   from,
+		Oldest: oldest,
+		Latest: latest,
+		Gap:    from < oldest,
+	}
+	var replayed []v1.KubeEventMessage
+	for _, each := range kubeEventBuffer.messages {
+		if each.Header.Offset >= from {
+			replayed = append(replayed, each)
+		}
+	}
+	s.queue(replayed...)
+	result.Replayed = len(replayed)
+	log.Println("Replayed", result.Replayed, "kube event messages from offset", from)
+	return result
+}
+
+// NewSequencedKubeEventPublisher returns KubeEventPublisher type service
+func NewSequencedKubeEventPublisher(publisher service.KubeEventPublisher) service.KubeEventPublisher {
+	return sequencedKubeEventPublisher{
+		publisher: publisher,
+	}
+}
+
+// NewKubeEventReplayService returns KubeEventReplay type service, resending through publisher
+func NewKubeEventReplayService(publisher service.KubeEventPublisher) service.KubeEventReplay {
+	return sequencedKubeEventPublisher{
+		publisher: publisher,
+	}
+}
@@ -0,0 +1,132 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klovercloud-ci-cd/agent/config"
+	v1 "github.com/klovercloud-ci-cd/agent/core/v1"
+)
+
+// offsetRecorder publisher recording offsets of the messages handed over to it.
+type offsetRecorder struct {
+	sync.Mutex
+	offsets []int
+}
+
+func (o *offsetRecorder) Publish(message v1.KubeEventMessage) {
+	o.Lock()
+	defer o.Unlock()
+	o.offsets = append(o.offsets, message.Header.Offset)
+}
+
+func (o *offsetRecorder) Flush(ctx context.Context) error {
+	return nil
+}
+
+func (o *offsetRecorder) recorded() []int {
+	o.Lock()
+	defer o.Unlock()
+	offsets := o.offsets
+	o.offsets = nil
+	return offsets
+}
+
+// withKubeEventBuffer empties the kube event buffer and sets its size for a test, restoring the size once the test is done.
+func withKubeEventBuffer(t *testing.T, size int) {
+	previous := config.KubeEventBufferSize
+	t.Cleanup(func() {
+		config.KubeEventBufferSize = previous
+	})
+	config.KubeEventBufferSize = size
+	kubeEventBuffer.Lock()
+	kubeEventBuffer.offset = 0
+	kubeEventBuffer.messages = nil
+	kubeEventBuffer.Unlock()
+}
+
+func flushKubeEvents(t *testing.T, publisher sequencedKubeEventPublisher) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	if err := publisher.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSequencedKubeEventPublisher_Replay(t *testing.T) {
+	withKubeEventBuffer(t, 3)
+	recorder := &offsetRecorder{}
+	publisher := sequencedKubeEventPublisher{publisher: recorder}
+	for i := 0; i < 5; i++ {
+		publisher.Publish(v1.KubeEventMessage{})
+	}
+	flushKubeEvents(t, publisher)
+	if offsets := recorder.recorded(); fmt.Sprint(offsets) != "[1 2 3 4 5]" {
+		t.Fatalf("Expected offsets [1 2 3 4 5] to be published, got %v", offsets)
+	}
+	testData := []struct {
+		name     string
+		epoch    int64
+		from     int
+		expected v1.KubeEventReplay
+		offsets  string
+	}{
+		{"within buffer", kubeEventEpoch, 4, v1.KubeEventReplay{From: 4, Oldest: 3, Latest: 5, Replayed: 2}, "[4 5]"},
+		{"oldest buffered", kubeEventEpoch, 3, v1.KubeEventReplay{From: 3, Oldest: 3, Latest: 5, Replayed: 3}, "[3 4 5]"},
+		{"trimmed from buffer", kubeEventEpoch, 2, v1.KubeEventReplay{From: 2, Oldest: 3, Latest: 5, Replayed: 3, Gap: true}, "[3 4 5]"},
+		{"up to date", kubeEventEpoch, 6, v1.KubeEventReplay{From: 6, Oldest: 3, Latest: 5}, "[]"},
+		{"no offset", kubeEventEpoch, 0, v1.KubeEventReplay{From: 1, Oldest: 3, Latest: 5, Replayed: 3, Gap: true}, "[3 4 5]"},
+		{"previous epoch", kubeEventEpoch - 1, 4, v1.KubeEventReplay{From: 1, Oldest: 3, Latest: 5, Replayed: 3, Gap: true}, "[3 4 5]"},
+	}
+	for _, each := range testData {
+		each.expected.Epoch = kubeEventEpoch
+		result := publisher.Replay(each.epoch, each.from)
+		if result != each.expected {
+			t.Errorf("%s: expected %+v, got %+v", each.name, each.expected, result)
+		}
+		flushKubeEvents(t, publisher)
+		if offsets := recorder.recorded(); fmt.Sprint(offsets) != each.offsets {
+			t.Errorf("%s: expected offsets %s to be replayed, got %v", each.name, each.offsets, offsets)
+		}
+	}
+}
+
+// Run with -race, replayed messages must not be interleaved with messages published meanwhile.
+func TestSequencedKubeEventPublisher_ReplayOrder(t *testing.T) {
+	withKubeEventBuffer(t, 100)
+	recorder := &offsetRecorder{}
+	publisher := sequencedKubeEventPublisher{publisher: recorder}
+	for i := 0; i < 50; i++ {
+		publisher.Publish(v1.KubeEventMessage{})
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			publisher.Publish(v1.KubeEventMessage{})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		publisher.Replay(kubeEventEpoch, 1)
+	}()
+	wg.Wait()
+	flushKubeEvents(t, publisher)
+	// replay copies offsets 1 to k and queues them right after live message k.
+	offsets := recorder.recorded()
+	k := len(offsets) - 100
+	var expected []int
+	for i := 1; i <= k; i++ {
+		expected = append(expected, i)
+	}
+	for i := 1; i <= 100; i++ {
+		expected = append(expected, i)
+	}
+	if k < 50 || fmt.Sprint(offsets) != fmt.Sprint(expected) {
+		t.Errorf("Expected offsets %v, got %v", expected, offsets)
+	}
+}
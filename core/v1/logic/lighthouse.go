@@ -0,0 +1,212 @@
+package logic
+
+import (
+	"fmt"
+	"github.com/klovercloud-ci-cd/agent/config"
+	v1 "github.com/klovercloud-ci-cd/agent/core/v1"
+	"github.com/klovercloud-ci-cd/agent/enums"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/cache"
+	"log"
+	"strings"
+)
+
+// lighthouseResource resource watched by lighthouse, resolved through discovery.
+type lighthouseResource struct {
+	resource   schema.GroupVersionResource
+	kind       string
+	namespaced bool
+}
+
+// lighthouseKind type and object names published for a kind.
+type lighthouseKind struct {
+	typ    string
+	object enums.RESOURCE_TYPE
+}
+
+// lighthouseKinds keeps names published for kinds lighthouse watched before it became generic, consumers rely on them.
+// Other kinds are published by their lower camel case kind.
+var lighthouseKinds = map[string]lighthouseKind{
+	"Namespace":             {typ: "namespace", object: enums.NAMESPACE},
+	"Service":               {typ: "service", object: enums.SERVICE},
+	"Pod":                   {typ: "pod", object: enums.POD},
+	"Deployment":            {typ: "deployment", object: enums.DEPLOYMENT},
+	"Ingress":               {typ: "ingress", object: enums.INGRESS},
+	"NetworkPolicy":         {typ: "networkPolicy", object: enums.NETWORK_POLICY},
+	"ClusterRoleBinding":    {typ: "clusterRoleBinding", object: enums.CLUSTER_ROLE_BINDGING},
+	"ClusterRole":           {typ: "clusterRole", object: enums.CLUSTER_ROLE},
+	"RoleBinding":           {typ: "roleBinding", object: enums.ROLE_BINDING},
+	"Role":                  {typ: "role", object: enums.ROLE},
+	"ServiceAccount":        {typ: "serviceAccount", object: enums.SERVICE_ACCOUNT},
+	"Secret":                {typ: "secret", object: enums.SECRET},
+	"ConfigMap":             {typ: "configMap", object: enums.CONFIG_MAP},
+	"PersistentVolumeClaim": {typ: "pvc", object: enums.PERSISTENT_VOLUME_CLAIM},
+	"PersistentVolume":      {typ: "persistentVolume", object: enums.PERSISTENT_VOLUME},
+	"DaemonSet":             {typ: "daemonSet", object: enums.DAEMONSET},
+	"ReplicaSet":            {typ: "replicaSet", object: enums.REPLICASET},
+	"StatefulSet":           {typ: "statefulSet", object: enums.STATEFULSET},
+	"Event":                 {object: enums.EVENT},
+}
+
+// ListenLighthouseEvents watches every configured lighthouse resource served by the cluster and publishes changes of
+// klovercloud_ci labelled objects. Resources the cluster does not serve, like CRDs not installed, are skipped.
+func (k k8sService) ListenLighthouseEvents() error {
+	apiResourceLists, err := k.discoveryClient.ServerPreferredResources()
+	if err != nil && len(apiResourceLists) == 0 {
+		return err
+	}
+	for _, each := range config.LighthouseResources {
+		resource, err := k.lighthouseResourceOf(each, apiResourceLists)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		k.informers.watch(resource, k.lighthouseHandler(resource))
+		log.Println("lighthouse watching:", resource.resource.String())
+	}
+	k.informers.start()
+	return nil
+}
+
+// lighthouseResourceOf resolves resource arg, as resource[.version][.group], through discovery. Preferred version of the group
+// is used if arg has no version or the version is not served.
+func (k k8sService) lighthouseResourceOf(arg string, apiResourceLists []*metaV1.APIResourceList) (lighthouseResource, error) {
+	groupVersionResource, groupResource := schema.ParseResourceArg(strings.ToLower(arg))
+	if groupVersionResource != nil {
+		if apiResourceList, err := k.discoveryClient.ServerResourcesForGroupVersion(groupVersionResource.GroupVersion().String()); err == nil {
+			if resource, ok := lighthouseResourceIn(apiResourceList, groupVersionResource.Resource); ok {
+				return resource, nil
+			}
+		}
+	}
+	for _, apiResourceList := range apiResourceLists {
+		gv, err := schema.ParseGroupVersion(apiResourceList.GroupVersion)
+		if err != nil || gv.Group != groupResource.Group {
+			continue
+		}
+		if resource, ok := lighthouseResourceIn(apiResourceList, groupResource.Resource); ok {
+			return resource, nil
+		}
+	}
+	return lighthouseResource{}, fmt.Errorf("lighthouse resource %s is not served by the cluster", arg)
+}
+
+// lighthouseResourceIn finds resource in apiResourceList by its plural or singular name. Subresources and resources that can not
+// be listed and watched are ignored.
+func lighthouseResourceIn(apiResourceList *metaV1.APIResourceList, name string) (lighthouseResource, bool) {
+	gv, err := schema.ParseGroupVersion(apiResourceList.GroupVersion)
+	if err != nil {
+		return lighthouseResource{}, false
+	}
+	for _, apiResource := range apiResourceList.APIResources {
+		if strings.Contains(apiResource.Name, "/") || !hasVerbs(apiResource.Verbs, "list", "watch") {
+			continue
+		}
+		if apiResource.Name == name || apiResource.SingularName == name {
+			return lighthouseResource{
+				resource:   gv.WithResource(apiResource.Name),
+				kind:       apiResource.Kind,
+				namespaced: apiResource.Namespaced,
+			}, true
+		}
+	}
+	return lighthouseResource{}, false
+}
+
+// lighthouseHandler publishes changes of the resource objects. Informers only cache klovercloud_ci labelled objects, except pod
+// events which carry no labels, those are published if the involved pod is labelled.
+func (k k8sService) lighthouseHandler(resource lighthouseResource) cache.ResourceEventHandler {
+	kind, ok := lighthouseKinds[resource.kind]
+	if !ok {
+		name := strings.ToLower(resource.kind[:1]) + resource.kind[1:]
+		kind = lighthouseKind{typ: name, object: enums.RESOURCE_TYPE(name)}
+	}
+	extrasMap := map[string]string{"agent": config.AgentName, "object": string(kind.object)}
+	if kind.typ != "" {
+		extrasMap["type"] = kind.typ
+	}
+	publish := func(command enums.Command, obj *unstructured.Unstructured, body interface{}) {
+		if resource.resource == eventsResource && !k.involvesCiPod(obj) {
+			return
+		}
+		k.kubeEventPublisher.Publish(v1.KubeEventMessage{
+			Body: body,
+			Header: v1.MessageHeader{
+				Offset:  0,
+				Command: command,
+				Extras:  extrasMap,
+			},
+		})
+		log.Println(strings.ToLower(string(command)), resource.kind+":", obj.GetNamespace(), obj.GetName())
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if object, ok := lighthouseObjectOf(obj); ok {
+				publish(enums.ADD, object, typedObjectOf(object))
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldK8sObj, oldOk := lighthouseObjectOf(oldObj)
+			newK8sObj, newOk := lighthouseObjectOf(newObj)
+			if oldOk && newOk {
+				publish(enums.UPDATE, newK8sObj, KubeObject{
+					OldK8sObj: typedObjectOf(oldK8sObj),
+					NewK8sObj: typedObjectOf(newK8sObj),
+				})
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if object, ok := lighthouseObjectOf(obj); ok {
+				publish(enums.DELETE, object, typedObjectOf(object))
+			}
+		},
+	}
+}
+
+// lighthouseObjectOf returns object delivered to a handler, unwrapping objects whose deletion was missed while disconnected.
+func lighthouseObjectOf(obj interface{}) (*unstructured.Unstructured, bool) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	object, ok := obj.(*unstructured.Unstructured)
+	return object, ok
+}
+
+// typedObjectOf converts object of a built-in kind into its typed object, published the way typed informers did before lighthouse
+// became generic: without apiVersion and kind. Objects of other kinds, like custom resources, are published as they are.
+func typedObjectOf(object *unstructured.Unstructured) interface{} {
+	typed, err := scheme.Scheme.New(object.GroupVersionKind())
+	if err != nil {
+		return object
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object.UnstructuredContent(), typed); err != nil {
+		log.Println("Failed to convert", object.GetKind(), object.GetName()+":", err.Error())
+		return object
+	}
+	typed.GetObjectKind().SetGroupVersionKind(schema.GroupVersionKind{})
+	return typed
+}
+
+// involvesCiPod returns true if the event is about a klovercloud_ci labelled pod.
+func (k k8sService) involvesCiPod(event *unstructured.Unstructured) bool {
+	kind, _, _ := unstructured.NestedString(event.Object, "involvedObject", "kind")
+	if kind != "Pod" {
+		return false
+	}
+	namespace, _, _ := unstructured.NestedString(event.Object, "involvedObject", "namespace")
+	name, _, _ := unstructured.NestedString(event.Object, "involvedObject", "name")
+	pod, err := k.cachedPod(namespace, name)
+	if err != nil {
+		if !k8sErrors.IsNotFound(err) {
+			log.Println(err.Error())
+		}
+		return false
+	}
+	_, ok := pod.Labels[ciLabelSelector]
+	return ok
+}
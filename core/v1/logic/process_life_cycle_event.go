@@ -51,6 +51,9 @@ func (e eventStoreProcessLifeCycleService) Listen(subject v1.Subject) {
 					CreatedAt: time.Now().UTC(),
 				})
 			}
+		} else if subject.EventData["status"] == enums.DEPLOYMENT_ROLLED_BACK {
+			processLifeCycleEvent.Status = enums.ROLLED_BACK
+			data = append(data, processLifeCycleEvent)
 		}else{
 			return
 		}
@@ -0,0 +1,206 @@
+package logic
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	v1 "github.com/klovercloud-ci-cd/agent/core/v1"
+	"github.com/klovercloud-ci-cd/agent/core/v1/service"
+	"github.com/klovercloud-ci-cd/agent/enums"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"io"
+	"io/ioutil"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"log"
+	"path"
+	"sigs.k8s.io/kustomize/api/filesys"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/yaml"
+	"strings"
+)
+
+// maxArchiveSize caps size of files extracted from a kustomization archive, so that a crafted archive can not exhaust memory.
+const maxArchiveSize = 64 << 20
+
+type rendererService struct {
+}
+
+func (r rendererService) Render(resource v1.Resource, release v1.Release) ([]unstructured.Unstructured, error) {
+	if resource.Render.Type == enums.HELM {
+		return r.renderChart(resource, release)
+	} else if resource.Render.Type == enums.KUSTOMIZE {
+		return r.renderKustomization(resource)
+	}
+	return nil, fmt.Errorf("unsupported render type: %s", resource.Render.Type)
+}
+
+// renderChart renders packaged chart with values, helm install semantics for first revision and upgrade for the rest.
+// Hooks are not run by the agent, they are skipped.
+func (r rendererService) renderChart(resource v1.Resource, release v1.Release) ([]unstructured.Unstructured, error) {
+	archive, err := base64.StdEncoding.DecodeString(resource.Render.Archive)
+	if err != nil {
+		return nil, err
+	}
+	chart, err := loader.LoadArchive(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+	values, err := chartutil.ReadValues([]byte(resource.Render.ValuesYaml))
+	if err != nil {
+		return nil, err
+	}
+	// values are merged into a copy, so that they are left as requested for the release record.
+	encoded, err := json.Marshal(resource.Render.Values)
+	if err != nil {
+		return nil, err
+	}
+	overrides, err := chartutil.ReadValues(encoded)
+	if err != nil {
+		return nil, err
+	}
+	values = chartutil.CoalesceTables(overrides, values)
+	options := chartutil.ReleaseOptions{
+		Name:      release.Name,
+		Namespace: release.Namespace,
+		Revision:  release.Revision,
+		IsInstall: release.Revision == 1,
+		IsUpgrade: release.Revision > 1,
+	}
+	renderValues, err := chartutil.ToRenderValues(chart, values, options, chartutil.DefaultCapabilities)
+	if err != nil {
+		return nil, err
+	}
+	files, err := engine.Render(chart, renderValues)
+	if err != nil {
+		return nil, err
+	}
+	for name := range files {
+		if strings.HasSuffix(name, "NOTES.txt") {
+			delete(files, name)
+		}
+	}
+	hooks, manifests, err := releaseutil.SortManifests(files, chartutil.DefaultVersionSet, releaseutil.InstallOrder)
+	if err != nil {
+		return nil, err
+	}
+	for _, each := range hooks {
+		log.Println("Skipping chart hook", each.Path)
+	}
+	var descriptors []unstructured.Unstructured
+	for _, each := range manifests {
+		descriptor, err := toDescriptor([]byte(each.Content))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", each.Name, err.Error())
+		}
+		if descriptor != nil {
+			descriptors = append(descriptors, *descriptor)
+		}
+	}
+	return descriptors, nil
+}
+
+// renderKustomization builds kustomization from inline files, or from a tar.gz archive, in an in-memory file system.
+func (r rendererService) renderKustomization(resource v1.Resource) ([]unstructured.Unstructured, error) {
+	fSys := filesys.MakeFsInMemory()
+	if resource.Render.Archive != "" {
+		if err := extractArchive(fSys, resource.Render.Archive); err != nil {
+			return nil, err
+		}
+	}
+	for name, content := range resource.Render.Files {
+		name = path.Join("/", name)
+		if err := fSys.MkdirAll(path.Dir(name)); err != nil {
+			return nil, err
+		}
+		if err := fSys.WriteFile(name, []byte(content)); err != nil {
+			return nil, err
+		}
+	}
+	resMap, err := krusty.MakeKustomizer(krusty.MakeDefaultOptions()).Run(fSys, path.Join("/", resource.Render.Path))
+	if err != nil {
+		return nil, err
+	}
+	var descriptors []unstructured.Unstructured
+	for _, each := range resMap.Resources() {
+		object, err := each.Map()
+		if err != nil {
+			return nil, err
+		}
+		descriptors = append(descriptors, unstructured.Unstructured{Object: object})
+	}
+	return descriptors, nil
+}
+
+// extractArchive writes files of base64 encoded tar.gz archive into file system.
+func extractArchive(fSys filesys.FileSystem, encoded string) error {
+	archive, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return err
+	}
+	gzipReader, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return err
+	}
+	defer gzipReader.Close()
+	tarReader := tar.NewReader(gzipReader)
+	remaining := int64(maxArchiveSize)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Join("/", header.Name)
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := fSys.MkdirAll(name); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			content, err := ioutil.ReadAll(io.LimitReader(tarReader, remaining+1))
+			if err != nil {
+				return err
+			}
+			remaining -= int64(len(content))
+			if remaining < 0 {
+				return fmt.Errorf("archive exceeds %d bytes once extracted", maxArchiveSize)
+			}
+			if err := fSys.MkdirAll(path.Dir(name)); err != nil {
+				return err
+			}
+			if err := fSys.WriteFile(name, content); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// toDescriptor converts yaml manifest into descriptor, nil if manifest is empty.
+func toDescriptor(manifest []byte) (*unstructured.Unstructured, error) {
+	object := make(map[string]interface{})
+	if err := yaml.Unmarshal(manifest, &object); err != nil {
+		return nil, err
+	}
+	if len(object) == 0 {
+		return nil, nil
+	}
+	descriptor := &unstructured.Unstructured{Object: object}
+	if descriptor.GetKind() == "" {
+		return nil, errors.New("manifest has no kind")
+	}
+	return descriptor, nil
+}
+
+// NewRendererService returns Renderer type service
+func NewRendererService() service.Renderer {
+	return &rendererService{}
+}
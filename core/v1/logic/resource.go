@@ -1,91 +1,239 @@
 package logic
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/klovercloud-ci-cd/agent/api/common"
 	"github.com/klovercloud-ci-cd/agent/config"
 	v1 "github.com/klovercloud-ci-cd/agent/core/v1"
 	"github.com/klovercloud-ci-cd/agent/core/v1/service"
 	"github.com/klovercloud-ci-cd/agent/enums"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"log"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"text/template"
+	"time"
 )
 
 type resourceService struct {
 	K8s          service.K8s
 	observerList []service.Observer
 	httpClient   service.HttpClient
+	renderer     service.Renderer
 }
 
-func (r resourceService) Pull() {
-	pullSize := config.PullSize - config.CurrentConcurrentJobs
-	if config.CurrentConcurrentJobs < 0 {
-		config.CurrentConcurrentJobs = 0
-	}
-	if pullSize < 1 {
-		log.Println("Pull size is loaded with jobs. Skipping new pulls... ")
-		return
-	}
-	url := config.ApiServiceUrl + "/process_life_cycle_events?count=" + strconv.FormatInt(pullSize, 10) + "&agent=" + config.AgentName
+func (r resourceService) Pull(count int64) ([]v1.Resource, error) {
+	url := config.ApiServiceUrl + "/process_life_cycle_events?count=" + strconv.FormatInt(count, 10) + "&agent=" + config.AgentName
 	header := make(map[string]string)
 	header["Accept"] = "application/json"
 	header["token"] = config.Token
 	data, err := r.httpClient.Get(url, header)
 	if err != nil {
-		// send to observer
-		log.Println(err.Error())
-		return
+		return nil, err
 	}
 	response := common.ResponseDTO{}
 	err = json.Unmarshal(data, &response)
 	if err != nil {
-		log.Println(err.Error())
-		// send to observer
-		return
+		return nil, err
 	}
 	b, err := json.Marshal(response.Data)
 	if err != nil {
-		//log.Println(err.Error())
-		// send to observer
-		return
+		return nil, err
 	}
-
 	resources := []v1.Resource{}
 	err = json.Unmarshal(b, &resources)
 	if err != nil {
-		log.Println(err.Error())
-		// send to observer
+		return nil, err
+	}
+	return resources, nil
+}
+
+// jobStreamIdleTimeout is how long job stream may stay silent before it is considered broken.
+// Api service is expected to send keep-alive comments more often than that.
+const jobStreamIdleTimeout = time.Second * 90
+
+// Stream receives jobs pushed by api service as server-sent "job" events, passing each to handle.
+// It returns once the stream breaks, goes idle or ctx is done.
+func (r resourceService) Stream(ctx context.Context, handle func(resource v1.Resource)) error {
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	url := config.ApiServiceUrl + "/process_life_cycle_events/stream?agent=" + config.AgentName
+	header := make(map[string]string)
+	header["Accept"] = "text/event-stream"
+	header["token"] = config.Token
+	body, err := r.httpClient.Stream(streamCtx, url, header)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	log.Println("Receiving jobs from job stream ...")
+	idle := time.AfterFunc(jobStreamIdleTimeout, cancel)
+	defer idle.Stop()
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 32*1024*1024)
+	event, data := "", ""
+	for scanner.Scan() {
+		idle.Reset(jobStreamIdleTimeout)
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if event == "job" && data != "" {
+				r.handleStreamedJobs(data, handle)
+			}
+			event, data = "", ""
+		case strings.HasPrefix(line, ":"):
+			// keep-alive comment
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if data != "" {
+				data = data + "\n"
+			}
+			data = data + strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")
+		}
+	}
+	if ctx.Err() == nil && streamCtx.Err() != nil {
+		return errors.New("job stream idle for " + jobStreamIdleTimeout.String())
+	}
+	return scanner.Err()
+}
+
+// handleStreamedJobs decodes data of a job event, holding either a single job or a list of jobs.
+func (r resourceService) handleStreamedJobs(data string, handle func(resource v1.Resource)) {
+	resources := []v1.Resource{}
+	var err error
+	if strings.HasPrefix(strings.TrimSpace(data), "[") {
+		err = json.Unmarshal([]byte(data), &resources)
+	} else {
+		resource := v1.Resource{}
+		err = json.Unmarshal([]byte(data), &resource)
+		resources = append(resources, resource)
+	}
+	if err != nil {
+		log.Println("Failed to decode streamed job:", err.Error())
 		return
 	}
-	config.CurrentConcurrentJobs = config.CurrentConcurrentJobs + int64(len(resources))
 	for _, each := range resources {
-		go r.apply(each)
+		handle(each)
 	}
 }
 
-func (r resourceService) apply(each v1.Resource) {
+func (r resourceService) Process(each v1.Resource) error {
 	err := r.Update(each)
-	subject := v1.Subject{each.Step, "", each.Name, each.Namespace, each.ProcessId, nil, nil, nil}
+	r.notifyResult(each, err)
+	return err
+}
+
+// Resume takes over a journaled job that was in flight when agent stopped. A rolling update that had reached
+// the rollout is watched again, any other job can not be continued safely and is reported as failed. A failed job
+// that had updated its workload is rolled back to the previous revision if its rollback policy is enabled.
+func (r resourceService) Resume(entry v1.JournalEntry) error {
+	resource := entry.Resource
+	if resource.Namespace == "" {
+		resource.Namespace = config.DefaultNamespace
+	}
+	var err error
+	if isResumableRollout(entry) {
+		subject := v1.Subject{Step: resource.Step, Name: resource.Name, Namespace: resource.Namespace, ProcessId: resource.ProcessId, Pipeline: resource.Pipeline}
+		subject.Log = "Agent restarted, resuming rollout watch ..."
+		subject.EventData = make(map[string]interface{})
+		subject.EventData["log"] = subject.Log
+		subject.EventData["reason"] = "n/a"
+		subject.EventData["footmark"] = enums.POST_AGENT_JOB
+		subject.EventData["status"] = enums.PROCESSING
+		subject.EventData["step"] = resource.Step
+		subject.EventData["process_id"] = resource.ProcessId
+		subject.EventData["company_id"] = resource.Pipeline.MetaData.CompanyId
+		subject.EventData["claim"] = strconv.Itoa(resource.Claim)
+		r.notifyAll(subject)
+		err = r.K8s.WatchRollout(resource)
+	} else {
+		err = errors.New("agent restarted while job was in progress (footmark: " + entry.Footmark + ", status: " + entry.Status + ")")
+	}
+	if err != nil && isInterruptedUpdate(entry) {
+		err = r.K8s.RollbackToPreviousRevision(resource, err)
+	}
+	r.notifyResult(resource, err)
+	return err
+}
+
+// ScaleDownRetired scales down deployments retired by blue green switches once their delay has passed.
+func (r resourceService) ScaleDownRetired() error {
+	return r.K8s.ScaleDownRetired()
+}
+
+func isResumableRollout(entry v1.JournalEntry) bool {
+	resource := entry.Resource
+	if resource.Name == "" || resource.DryRun || resource.Verification != nil {
+		return false
+	}
+	if resource.Action != "" && resource.Action != enums.APPLY {
+		return false
+	}
+	if resource.Strategy != nil && resource.Strategy.Type != "" && resource.Strategy.Type != enums.ROLLING_UPDATE {
+		return false
+	}
+	if resource.Type != enums.DEPLOYMENT && resource.Type != enums.STATEFULSET && resource.Type != enums.DAEMONSET {
+		return false
+	}
+	// workload must have been updated and be rolling out, a rollback in progress can not be told apart from it.
+	if !hasFootmark(entry, enums.UPDATE_RESOURCE) || hasFootmark(entry, enums.ROLLBACK_RESOURCE) {
+		return false
+	}
+	return entry.Footmark == string(enums.POST_AGENT_JOB) && entry.Status == string(enums.PROCESSING)
+}
+
+// isInterruptedUpdate returns true if the job may have updated its workload and rollback policy asks to restore it on failure.
+func isInterruptedUpdate(entry v1.JournalEntry) bool {
+	resource := entry.Resource
+	if resource.DryRun || resource.RollbackPolicy == nil || !resource.RollbackPolicy.Enabled {
+		return false
+	}
+	if resource.Type != enums.DEPLOYMENT && resource.Type != enums.STATEFULSET && resource.Type != enums.DAEMONSET {
+		return false
+	}
+	return hasFootmark(entry, enums.UPDATE_RESOURCE) && !hasFootmark(entry, enums.ROLLBACK_RESOURCE)
+}
+
+// notifyResult reports final status of a job to the observers.
+func (r resourceService) notifyResult(each v1.Resource, err error) {
+	subject := v1.Subject{Step: each.Step, Name: each.Name, Namespace: each.Namespace, ProcessId: each.ProcessId}
 	subject.EventData = make(map[string]interface{})
 	subject.EventData["step"] = each.Step
 	subject.EventData["process_id"] = each.ProcessId
 	subject.EventData["company_id"] = each.Pipeline.MetaData.CompanyId
 	subject.EventData["claim"] = strconv.Itoa(each.Claim)
 	subject.EventData["footmark"] = enums.POST_AGENT_JOB
-	config.CurrentConcurrentJobs = config.CurrentConcurrentJobs - 1
-	if err != nil {
+	var rolledBack rolledBackError
+	if errors.As(err, &rolledBack) {
+		subject.Log = "Update Failed, Rolled Back: " + err.Error()
+		subject.EventData["log"] = subject.Log
+		subject.EventData["reason"] = rolledBack.err.Error()
+		subject.EventData["status"] = enums.DEPLOYMENT_ROLLED_BACK
+		r.notifyAll(subject)
+	} else if err != nil {
 		subject.Log = "Update Failed: " + err.Error()
 		subject.EventData["log"] = subject.Log
 		subject.EventData["status"] = enums.DEPLOYMENT_FAILED
-		go r.notifyAll(subject)
+		r.notifyAll(subject)
 	} else {
 		subject.EventData["log"] = "Agent Job Completed"
 		subject.EventData["status"] = enums.SUCCESSFUL
-		go r.notifyAll(subject)
+		r.notifyAll(subject)
 	}
 }
 
 func (r resourceService) Update(resource v1.Resource) error {
+	if resource.Namespace == "" {
+		resource.Namespace = config.DefaultNamespace
+	}
 	listener := v1.Subject{Log: "Deploy Step Started", ProcessId: resource.ProcessId, Step: resource.Step}
 	processEventData := make(map[string]interface{})
 	processEventData["step"] = resource.Step
@@ -96,23 +244,69 @@ func (r resourceService) Update(resource v1.Resource) error {
 	processEventData["footmark"] = enums.INIT_AGNET_JOB
 	processEventData["claim"] = strconv.Itoa(resource.Claim)
 	listener.EventData = processEventData
-	go r.notifyAll(listener)
-	for _, each := range *resource.Descriptors {
-		each.SetLabels(map[string]string{"company": resource.Pipeline.MetaData.CompanyId, "klovercloud_ci": "enabled", "process_id": resource.ProcessId, "claim": strconv.Itoa(resource.Claim)})
-		err := r.K8s.Apply(each)
-		if err != nil {
-			listener.Log = err.Error()
-			go r.notifyAll(listener)
+	r.notifyAll(listener)
+	if resource.DryRun {
+		return r.dryRun(resource)
+	}
+	if resource.Action == enums.SCALE {
+		return r.K8s.ScaleWorkload(resource)
+	} else if resource.Action == enums.TEARDOWN {
+		return r.K8s.Teardown(resource)
+	}
+	var release *v1.Release
+	if resource.Render != nil {
+		var err error
+		if release, err = r.prepareRelease(&resource); err != nil {
+			return err
+		}
+	}
+	descriptors, err := r.renderDescriptors(resource)
+	if err != nil {
+		return err
+	}
+	if release != nil {
+		release.Status = enums.RELEASE_PENDING
+		release.Manifests = descriptors
+		release.UpdatedAt = time.Now().UTC()
+		if err := r.K8s.SaveRelease(*release); err != nil {
+			return err
+		}
+	}
+	applied, err := r.applyDescriptors(resource, listener, descriptors)
+	if err == nil {
+		err = r.updateWorkload(resource, listener, applied)
+	}
+	if release != nil {
+		if err := r.recordRelease(resource, release, descriptors, err); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// updateWorkload prunes objects not among applied descriptors anymore, if requested, and updates the workload of the resource.
+func (r resourceService) updateWorkload(resource v1.Resource, listener v1.Subject, applied []unstructured.Unstructured) error {
+	if resource.Prune && resource.Descriptors != nil && len(applied) < len(*resource.Descriptors) {
+		listener.Log = "Skipping prune as some descriptors failed to apply"
+		listener.EventData["log"] = listener.Log
+		r.notifyAll(listener)
+	} else if resource.Prune {
+		if err := r.K8s.Prune(resource, applied); err != nil {
+			return err
 		}
 	}
 	if resource.Name == "" {
-		subject := v1.Subject{resource.Step, "Updated Successfully", resource.Name, resource.Namespace, resource.ProcessId, map[string]interface{}{"footmark": enums.POST_AGENT_JOB, "log": "Updated Successfully", "reason": "n/a", "step": resource.Step, "process_id": resource.ProcessId, "company_id": resource.Pipeline.MetaData.CompanyId, "status": enums.SUCCESSFUL, "claim": strconv.Itoa(resource.Claim)}, nil, resource.Pipeline}
-		go r.notifyAll(subject)
+		subject := v1.Subject{Step: resource.Step, Log: "Updated Successfully", Name: resource.Name, Namespace: resource.Namespace, ProcessId: resource.ProcessId, EventData: map[string]interface{}{"footmark": enums.POST_AGENT_JOB, "log": "Updated Successfully", "reason": "n/a", "step": resource.Step, "process_id": resource.ProcessId, "company_id": resource.Pipeline.MetaData.CompanyId, "status": enums.SUCCESSFUL, "claim": strconv.Itoa(resource.Claim)}, Pipeline: resource.Pipeline}
+		r.notifyAll(subject)
 		return nil
 	}
-	subject := v1.Subject{resource.Step, "Updating resource", resource.Name, resource.Namespace, resource.ProcessId, map[string]interface{}{"footmark": enums.UPDATE_RESOURCE, "log": "Updating resource", "reason": "n/a", "step": resource.Step, "process_id": resource.ProcessId, "company_id": resource.Pipeline.MetaData.CompanyId, "status": enums.PROCESSING, "claim": strconv.Itoa(resource.Claim)}, nil, resource.Pipeline}
-	go r.notifyAll(subject)
-	if resource.Type == enums.DEPLOYMENT {
+	subject := v1.Subject{Step: resource.Step, Log: "Updating resource", Name: resource.Name, Namespace: resource.Namespace, ProcessId: resource.ProcessId, EventData: map[string]interface{}{"footmark": enums.UPDATE_RESOURCE, "log": "Updating resource", "reason": "n/a", "step": resource.Step, "process_id": resource.ProcessId, "company_id": resource.Pipeline.MetaData.CompanyId, "status": enums.PROCESSING, "claim": strconv.Itoa(resource.Claim)}, Pipeline: resource.Pipeline}
+	r.notifyAll(subject)
+	if resource.Type == enums.DEPLOYMENT && resource.Strategy != nil && resource.Strategy.Type == enums.BLUE_GREEN {
+		return r.K8s.BlueGreenDeployment(resource)
+	} else if resource.Type == enums.DEPLOYMENT && resource.Strategy != nil && resource.Strategy.Type == enums.CANARY {
+		return r.K8s.CanaryDeployment(resource)
+	} else if resource.Type == enums.DEPLOYMENT {
 		return r.K8s.UpdateDeployment(resource)
 	} else if resource.Type == enums.POD {
 		return r.K8s.UpdatePod(resource)
@@ -120,20 +314,429 @@ func (r resourceService) Update(resource v1.Resource) error {
 		return r.K8s.UpdateStatefulSet(resource)
 	} else if resource.Type == enums.DAEMONSET {
 		return r.K8s.UpdateDaemonSet(resource)
+	} else if resource.Type == enums.JOB {
+		return r.K8s.UpdateJob(resource)
+	} else if resource.Type == enums.CRON_JOB {
+		return r.K8s.UpdateCronJob(resource)
+	} else if resource.Type == enums.ARGO_ROLLOUT {
+		return r.K8s.UpdateArgoRollout(resource)
+	}
+	return fmt.Errorf("unsupported resource type: %s", resource.Type)
+}
+
+// applyDescriptors applies descriptors in dependency order, failing on first required descriptor that fails.
+func (r resourceService) applyDescriptors(resource v1.Resource, listener v1.Subject, descriptors []unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+	var applied []unstructured.Unstructured
+	for _, each := range sortDescriptors(descriptors) {
+		each.SetLabels(mergeLabels(each.GetLabels(), ciLabels(resource)))
+		_, err := r.K8s.Deploy(resource, &each)
+		if err != nil {
+			log.Println(err.Error())
+			listener.Log = err.Error()
+			listener.EventData["log"] = listener.Log
+			r.notifyAll(listener)
+			if !isOptionalDescriptor(each) {
+				return applied, fmt.Errorf("failed to apply %s %s: %s", each.GetKind(), each.GetName(), err.Error())
+			}
+			continue
+		}
+		applied = append(applied, each)
+	}
+	return applied, nil
+}
+
+// releaseName returns name of the release of rendered chart or kustomization.
+func releaseName(resource v1.Resource) string {
+	if resource.Render.Release != "" {
+		return resource.Render.Release
+	} else if resource.Name != "" {
+		return resource.Name
+	}
+	return resource.Step
+}
+
+// prepareRelease renders next revision of the release, or picks the stored revision to roll back to,
+// and puts its manifests in front of payload descriptors.
+func (r resourceService) prepareRelease(resource *v1.Resource) (*v1.Release, error) {
+	name := releaseName(*resource)
+	releases, err := r.K8s.GetReleases(resource.Namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	release := &v1.Release{Name: name, Namespace: resource.Namespace, Revision: 1, ProcessId: resource.ProcessId, Values: resource.Render.Values}
+	for _, each := range releases {
+		if each.Revision >= release.Revision {
+			release.Revision = each.Revision + 1
+		}
+	}
+	var manifests []unstructured.Unstructured
+	if resource.Render.RollbackRevision > 0 {
+		var target *v1.Release
+		for i, each := range releases {
+			if each.Revision == resource.Render.RollbackRevision {
+				target = &releases[i]
+			}
+		}
+		if target == nil {
+			return nil, fmt.Errorf("revision %d of release %s not found", resource.Render.RollbackRevision, name)
+		}
+		release.Values = target.Values
+		release.Description = "Rollback to " + strconv.Itoa(target.Revision)
+		// stored manifests are already rendered along with payload descriptors of that revision.
+		resource.Templating = nil
+		manifests = target.Manifests
+	} else {
+		release.Description = "Install"
+		if release.Revision > 1 {
+			release.Description = "Upgrade"
+		}
+		if manifests, err = r.renderer.Render(*resource, *release); err != nil {
+			return nil, err
+		}
+		// only payload descriptors are templated, rendered output may hold literal ${...} and {{ }} meant for the workloads.
+		descriptors, err := r.renderDescriptors(*resource)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, descriptors...)
+		resource.Templating = nil
+	}
+	resource.Descriptors = &manifests
+	listener := v1.Subject{Step: resource.Step, Log: fmt.Sprintf("Rendered %d manifests of release %s revision %d", len(manifests), name, release.Revision), Name: resource.Name, Namespace: resource.Namespace, ProcessId: resource.ProcessId, EventData: map[string]interface{}{"footmark": enums.RENDER_RESOURCE, "reason": "n/a", "step": resource.Step, "process_id": resource.ProcessId, "company_id": resource.Pipeline.MetaData.CompanyId, "status": enums.PROCESSING, "claim": strconv.Itoa(resource.Claim)}, Pipeline: resource.Pipeline}
+	listener.EventData["log"] = listener.Log
+	r.notifyAll(listener)
+	return release, nil
+}
+
+// recordRelease sets final status of the pending release revision once descriptors and workload are applied. If applying
+// failed and rollback is enabled, last deployed revision is applied again and recorded as a new revision. Workloads rolled
+// back by their own rollback policy are left to it, so only the failed revision is recorded.
+func (r resourceService) recordRelease(resource v1.Resource, release *v1.Release, descriptors []unstructured.Unstructured, applyErr error) error {
+	releases, err := r.K8s.GetReleases(release.Namespace, release.Name)
+	if err != nil {
+		return err
+	}
+	var lastDeployed *v1.Release
+	for i, each := range releases {
+		if each.Status == enums.RELEASE_DEPLOYED && (lastDeployed == nil || each.Revision > lastDeployed.Revision) {
+			lastDeployed = &releases[i]
+		}
+	}
+	release.Manifests = descriptors
+	release.UpdatedAt = time.Now().UTC()
+	if applyErr != nil {
+		release.Status = enums.RELEASE_FAILED
+		release.Description = release.Description + " failed: " + applyErr.Error()
+		if err := r.K8s.SaveRelease(*release); err != nil {
+			return err
+		}
+		if lastDeployed == nil || resource.RollbackPolicy == nil || !resource.RollbackPolicy.Enabled {
+			return nil
+		}
+		// workload was rolled back already, applying last deployed manifests on top of it would roll it back twice.
+		var rolledBack rolledBackError
+		if errors.As(applyErr, &rolledBack) {
+			return nil
+		}
+		listener := v1.Subject{Step: resource.Step, Log: fmt.Sprintf("Rolling back release %s to revision %d", release.Name, lastDeployed.Revision), Name: resource.Name, Namespace: resource.Namespace, ProcessId: resource.ProcessId, EventData: map[string]interface{}{"footmark": enums.ROLLBACK_RESOURCE, "reason": applyErr.Error(), "step": resource.Step, "process_id": resource.ProcessId, "company_id": resource.Pipeline.MetaData.CompanyId, "status": enums.PROCESSING, "claim": strconv.Itoa(resource.Claim)}, Pipeline: resource.Pipeline}
+		listener.EventData["log"] = listener.Log
+		r.notifyAll(listener)
+		if _, err := r.applyDescriptors(resource, listener, lastDeployed.Manifests); err != nil {
+			return fmt.Errorf("%s, rollback failed: %s", applyErr.Error(), err.Error())
+		}
+		rollback := *lastDeployed
+		rollback.Revision = release.Revision + 1
+		rollback.Description = "Rollback to " + strconv.Itoa(lastDeployed.Revision)
+		rollback.ProcessId = resource.ProcessId
+		rollback.UpdatedAt = time.Now().UTC()
+		lastDeployed.Status = enums.RELEASE_SUPERSEDED
+		if err := r.K8s.SaveRelease(*lastDeployed); err != nil {
+			return err
+		}
+		if err := r.K8s.SaveRelease(rollback); err != nil {
+			return err
+		}
+		return rolledBackError{err: applyErr}
+	}
+	release.Status = enums.RELEASE_DEPLOYED
+	if lastDeployed != nil {
+		lastDeployed.Status = enums.RELEASE_SUPERSEDED
+		if err := r.K8s.SaveRelease(*lastDeployed); err != nil {
+			return err
+		}
+	}
+	return r.K8s.SaveRelease(*release)
+}
+
+// dryRun publishes changes the resource would make, without persisting anything.
+func (r resourceService) dryRun(resource v1.Resource) error {
+	diffs, err := r.Diff(resource)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, each := range diffs {
+		if each.Error != "" {
+			failed++
+		}
+	}
+	subject := v1.Subject{Step: resource.Step, Log: "Dry run completed", Name: resource.Name, Namespace: resource.Namespace, ProcessId: resource.ProcessId, EventData: map[string]interface{}{"footmark": enums.DRY_RUN, "log": "Dry run completed", "reason": "n/a", "step": resource.Step, "process_id": resource.ProcessId, "company_id": resource.Pipeline.MetaData.CompanyId, "status": enums.PROCESSING, "claim": strconv.Itoa(resource.Claim), "diff": diffs}, Pipeline: resource.Pipeline}
+	r.notifyAll(subject)
+	if failed > 0 {
+		return fmt.Errorf("dry run failed for %d of %d objects", failed, len(diffs))
 	}
 	return nil
 }
-func (r resourceService) notifyAll(subject v1.Subject) {
-	for _, observer := range r.observerList {
-		go observer.Listen(subject)
+
+func (r resourceService) Diff(resource v1.Resource) ([]v1.ResourceDiff, error) {
+	if resource.Namespace == "" {
+		resource.Namespace = config.DefaultNamespace
+	}
+	resource.DryRun = true
+	if resource.Action == enums.SCALE {
+		return []v1.ResourceDiff{r.K8s.DiffScale(resource)}, nil
+	} else if resource.Action == enums.TEARDOWN {
+		return r.K8s.TeardownPreview(resource)
+	}
+	if resource.Render != nil {
+		if _, err := r.prepareRelease(&resource); err != nil {
+			return nil, err
+		}
+	}
+	diffs := []v1.ResourceDiff{}
+	descriptors, err := r.renderDescriptors(resource)
+	if err != nil {
+		return nil, err
+	}
+	var applied []unstructured.Unstructured
+	if resource.Descriptors != nil {
+		for _, each := range sortDescriptors(descriptors) {
+			each.SetLabels(mergeLabels(each.GetLabels(), ciLabels(resource)))
+			diff := r.K8s.Diff(resource, &each)
+			diffs = append(diffs, diff)
+			if diff.Error == "" {
+				applied = append(applied, each)
+			}
+		}
+	}
+	if resource.Prune && (resource.Descriptors == nil || len(applied) == len(*resource.Descriptors)) {
+		pruned, err := r.K8s.PrunePreview(resource, applied)
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, pruned...)
+	}
+	if resource.Name != "" {
+		diffs = append(diffs, r.K8s.DiffWorkload(resource))
+	}
+	return diffs, nil
+}
+
+func (r resourceService) Abort(processId, step string) error {
+	return r.K8s.AbortStrategy(processId, step)
+}
+
+// optionalDescriptorAnnotation marks a descriptor whose failure does not fail the step.
+const optionalDescriptorAnnotation = "ci.klovercloud.com/optional"
+
+// descriptorKindOrder apply order of well known kinds, dependencies first. Other kinds, like custom resources, are applied last.
+var descriptorKindOrder = []string{
+	"Namespace",
+	"CustomResourceDefinition",
+	"NetworkPolicy",
+	"ResourceQuota",
+	"LimitRange",
+	"PodSecurityPolicy",
+	"PodDisruptionBudget",
+	"PriorityClass",
+	"ServiceAccount",
+	"ClusterRole",
+	"ClusterRoleBinding",
+	"Role",
+	"RoleBinding",
+	"Secret",
+	"ConfigMap",
+	"StorageClass",
+	"PersistentVolume",
+	"PersistentVolumeClaim",
+	"Service",
+	"DaemonSet",
+	"Pod",
+	"ReplicationController",
+	"ReplicaSet",
+	"Deployment",
+	"HorizontalPodAutoscaler",
+	"StatefulSet",
+	"Job",
+	"CronJob",
+	"IngressClass",
+	"Ingress",
+	"APIService",
+}
+
+// sortDescriptors returns descriptors ordered by kind, keeping the received order within the same kind.
+func sortDescriptors(descriptors []unstructured.Unstructured) []unstructured.Unstructured {
+	sorted := append([]unstructured.Unstructured{}, descriptors...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return kindPriority(sorted[i].GetKind()) < kindPriority(sorted[j].GetKind())
+	})
+	return sorted
+}
+
+// kindPriority returns apply priority of the kind, lower is applied first.
+func kindPriority(kind string) int {
+	for i, each := range descriptorKindOrder {
+		if each == kind {
+			return i
+		}
 	}
+	return len(descriptorKindOrder)
+}
+
+func isOptionalDescriptor(descriptor unstructured.Unstructured) bool {
+	return descriptor.GetAnnotations()[optionalDescriptorAnnotation] == "true"
+}
+
+// templateVariablePattern matches ${VAR} and ${VAR:-default} placeholders.
+var templateVariablePattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// templateVariables returns variables available to descriptor templates. Job payload variables override agent level ones,
+// which override cluster facts.
+func (r resourceService) templateVariables(resource v1.Resource) (map[string]string, error) {
+	variables, err := r.K8s.ClusterFacts()
+	if err != nil {
+		return nil, err
+	}
+	variables["AGENT_NAME"] = config.AgentName
+	variables["PROCESS_ID"] = resource.ProcessId
+	variables["STEP"] = resource.Step
+	variables["NAMESPACE"] = resource.Namespace
+	variables["COMPANY_ID"] = resource.Pipeline.MetaData.CompanyId
+	variables["PIPELINE_NAME"] = resource.Pipeline.Name
+	for key, value := range config.TemplateVariables {
+		variables[key] = value
+	}
+	for key, value := range resource.Templating.Variables {
+		variables[key] = value
+	}
+	return variables, nil
+}
+
+// renderDescriptors runs templating pass over every string value of the descriptors.
+func (r resourceService) renderDescriptors(resource v1.Resource) ([]unstructured.Unstructured, error) {
+	if resource.Descriptors == nil {
+		return nil, nil
+	}
+	if resource.Templating == nil {
+		return *resource.Descriptors, nil
+	}
+	variables, err := r.templateVariables(resource)
+	if err != nil {
+		return nil, err
+	}
+	var render func(value string) (string, error)
+	if resource.Templating.Engine == enums.GO_TEMPLATE {
+		render = func(value string) (string, error) {
+			return renderGoTemplate(value, variables, resource.Templating.Strict)
+		}
+	} else {
+		render = func(value string) (string, error) {
+			return renderEnvsubst(value, variables, resource.Templating.Strict)
+		}
+	}
+	rendered := make([]unstructured.Unstructured, 0, len(*resource.Descriptors))
+	for _, each := range *resource.Descriptors {
+		object, err := renderValue(each.Object, render)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render %s %s: %s", each.GetKind(), each.GetName(), err.Error())
+		}
+		rendered = append(rendered, unstructured.Unstructured{Object: object.(map[string]interface{})})
+	}
+	return rendered, nil
+}
+
+// renderValue returns copy of the value with every nested string rendered.
+func renderValue(value interface{}, render func(string) (string, error)) (interface{}, error) {
+	switch typed := value.(type) {
+	case string:
+		return render(typed)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(typed))
+		for key, each := range typed {
+			rendered, err := renderValue(each, render)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = rendered
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(typed))
+		for i, each := range typed {
+			rendered, err := renderValue(each, render)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = rendered
+		}
+		return out, nil
+	}
+	return value, nil
+}
+
+// renderEnvsubst replaces ${VAR} and ${VAR:-default} placeholders. Undefined placeholders are kept as is, or fail in strict mode.
+func renderEnvsubst(value string, variables map[string]string, strict bool) (string, error) {
+	if !strings.Contains(value, "${") {
+		return value, nil
+	}
+	var undefined []string
+	rendered := templateVariablePattern.ReplaceAllStringFunc(value, func(placeholder string) string {
+		match := templateVariablePattern.FindStringSubmatch(placeholder)
+		if variable, ok := variables[match[1]]; ok {
+			return variable
+		}
+		if match[2] != "" {
+			return match[3]
+		}
+		undefined = append(undefined, match[1])
+		return placeholder
+	})
+	if strict && len(undefined) > 0 {
+		return "", errors.New("undefined variables: " + strings.Join(undefined, ", "))
+	}
+	return rendered, nil
+}
+
+// renderGoTemplate executes value as go template with variables as data. Undefined variables render empty, or fail in strict mode.
+func renderGoTemplate(value string, variables map[string]string, strict bool) (string, error) {
+	if !strings.Contains(value, "{{") {
+		return value, nil
+	}
+	missingKey := "missingkey=zero"
+	if strict {
+		missingKey = "missingkey=error"
+	}
+	tmpl, err := template.New("descriptor").Option(missingKey).Parse(value)
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, variables); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+func (r resourceService) notifyAll(subject v1.Subject) {
+	notifyObservers(r.observerList, subject)
 }
 
 // NewResourceService returns resource type service.
-func NewResourceService(k8s service.K8s, observerList []service.Observer, httpClient service.HttpClient) service.Resource {
+func NewResourceService(k8s service.K8s, observerList []service.Observer, httpClient service.HttpClient, renderer service.Renderer) service.Resource {
 	return &resourceService{
 		K8s:          k8s,
 		observerList: observerList,
 		httpClient:   httpClient,
+		renderer:     renderer,
 	}
 }
@@ -0,0 +1,145 @@
+package logic
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestKindPriority(t *testing.T) {
+	testData := []struct {
+		before, after string
+	}{
+		{"Namespace", "Deployment"},
+		{"CustomResourceDefinition", "Deployment"},
+		{"ServiceAccount", "RoleBinding"},
+		{"ConfigMap", "Deployment"},
+		{"PersistentVolumeClaim", "StatefulSet"},
+		{"Service", "Deployment"},
+		{"Deployment", "HorizontalPodAutoscaler"},
+		{"Deployment", "Ingress"},
+		{"APIService", "MyCustomKind"},
+	}
+	for _, each := range testData {
+		if kindPriority(each.before) >= kindPriority(each.after) {
+			t.Errorf("Expected %s to be applied before %s", each.before, each.after)
+		}
+	}
+	if kindPriority("MyCustomKind") != kindPriority("OtherCustomKind") {
+		t.Error("Expected unknown kinds to share the lowest priority")
+	}
+}
+
+func TestSortDescriptors(t *testing.T) {
+	descriptor := func(kind, name string) unstructured.Unstructured {
+		object := unstructured.Unstructured{Object: map[string]interface{}{}}
+		object.SetKind(kind)
+		object.SetName(name)
+		return object
+	}
+	names := func(descriptors []unstructured.Unstructured) string {
+		var out []string
+		for _, each := range descriptors {
+			out = append(out, each.GetName())
+		}
+		return strings.Join(out, ",")
+	}
+	testData := []struct {
+		name        string
+		descriptors []unstructured.Unstructured
+		expected    string
+	}{
+		{"empty", nil, ""},
+		{"ordered by kind", []unstructured.Unstructured{
+			descriptor("Ingress", "ingress"),
+			descriptor("Deployment", "deployment"),
+			descriptor("Service", "service"),
+			descriptor("ConfigMap", "config"),
+			descriptor("Namespace", "namespace"),
+		}, "namespace,config,service,deployment,ingress"},
+		{"received order kept within kind", []unstructured.Unstructured{
+			descriptor("Deployment", "b"),
+			descriptor("ConfigMap", "c2"),
+			descriptor("Deployment", "a"),
+			descriptor("ConfigMap", "c1"),
+		}, "c2,c1,b,a"},
+		{"unknown kinds last", []unstructured.Unstructured{
+			descriptor("Certificate", "certificate"),
+			descriptor("Deployment", "deployment"),
+			descriptor("Issuer", "issuer"),
+		}, "deployment,certificate,issuer"},
+	}
+	for _, each := range testData {
+		original := names(each.descriptors)
+		if sorted := names(sortDescriptors(each.descriptors)); sorted != each.expected {
+			t.Errorf("%s: expected %s, got %s", each.name, each.expected, sorted)
+		}
+		if names(each.descriptors) != original {
+			t.Errorf("%s: expected received descriptors to be left unsorted", each.name)
+		}
+	}
+}
+
+func TestRenderEnvsubst(t *testing.T) {
+	variables := map[string]string{"NAMESPACE": "dev", "IMAGE": "nginx:1.21", "EMPTY": ""}
+	testData := []struct {
+		name     string
+		value    string
+		strict   bool
+		expected string
+		err      bool
+	}{
+		{"no placeholder", "plain value", false, "plain value", false},
+		{"defined", "${IMAGE}", false, "nginx:1.21", false},
+		{"embedded", "ns-${NAMESPACE}-svc", false, "ns-dev-svc", false},
+		{"several", "${NAMESPACE}/${IMAGE}", false, "dev/nginx:1.21", false},
+		{"defined empty", "[${EMPTY}]", false, "[]", false},
+		{"defined over default", "${NAMESPACE:-default}", false, "dev", false},
+		{"default", "${REPLICAS:-3}", false, "3", false},
+		{"empty default", "[${REPLICAS:-}]", false, "[]", false},
+		{"undefined kept", "${UNDEFINED}", false, "${UNDEFINED}", false},
+		{"undefined in strict mode", "${UNDEFINED}", true, "", true},
+		{"default in strict mode", "${UNDEFINED:-x}", true, "x", false},
+		{"shell variable untouched", "$HOME", true, "$HOME", false},
+	}
+	for _, each := range testData {
+		rendered, err := renderEnvsubst(each.value, variables, each.strict)
+		if (err != nil) != each.err {
+			t.Errorf("%s: unexpected error %v", each.name, err)
+			continue
+		}
+		if rendered != each.expected {
+			t.Errorf("%s: expected %q, got %q", each.name, each.expected, rendered)
+		}
+	}
+}
+
+func TestRenderGoTemplate(t *testing.T) {
+	variables := map[string]string{"NAMESPACE": "dev", "IMAGE": "nginx:1.21"}
+	testData := []struct {
+		name     string
+		value    string
+		strict   bool
+		expected string
+		err      bool
+	}{
+		{"no action", "plain ${IMAGE}", false, "plain ${IMAGE}", false},
+		{"defined", "{{ .IMAGE }}", false, "nginx:1.21", false},
+		{"embedded", "ns-{{ .NAMESPACE }}-svc", false, "ns-dev-svc", false},
+		{"function", "{{ printf \"%s/%s\" .NAMESPACE .IMAGE }}", false, "dev/nginx:1.21", false},
+		{"undefined renders empty", "[{{ .UNDEFINED }}]", false, "[]", false},
+		{"undefined in strict mode", "{{ .UNDEFINED }}", true, "", true},
+		{"malformed", "{{ .IMAGE", false, "", true},
+	}
+	for _, each := range testData {
+		rendered, err := renderGoTemplate(each.value, variables, each.strict)
+		if (err != nil) != each.err {
+			t.Errorf("%s: unexpected error %v", each.name, err)
+			continue
+		}
+		if rendered != each.expected {
+			t.Errorf("%s: expected %q, got %q", each.name, each.expected, rendered)
+		}
+	}
+}
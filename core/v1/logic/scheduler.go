@@ -0,0 +1,239 @@
+package logic
+
+import (
+	"context"
+	"github.com/klovercloud-ci-cd/agent/config"
+	v1 "github.com/klovercloud-ci-cd/agent/core/v1"
+	"github.com/klovercloud-ci-cd/agent/core/v1/service"
+	"github.com/klovercloud-ci-cd/agent/enums"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	pullInterval   = time.Millisecond * 500
+	maxPullBackoff = time.Second * 30
+	// streamRetryInterval is how long jobs are polled after job stream breaks, before reconnecting.
+	streamRetryInterval = time.Second * 30
+	// retiredScaleDownInterval is how often deployments retired by blue green switches are checked for scale down.
+	retiredScaleDownInterval = time.Minute
+)
+
+type jobScheduler struct {
+	resourceService service.Resource
+	journal         service.Journal
+	jobLease        service.JobLease
+}
+
+// job pulled or journaled job run by a worker.
+type job struct {
+	resource v1.Resource
+	entry    *v1.JournalEntry
+}
+
+// run runs the job, unless ctx is done. Jobs not started by then are released for api service to reassign.
+func (j jobScheduler) run(ctx context.Context, each job) {
+	if ctx.Err() != nil {
+		if err := j.jobLease.Release(each.resource, "agent is shutting down"); err != nil {
+			log.Println("Failed to release job:", err.Error())
+		}
+		j.removeJournalEntry(each.resource)
+		return
+	}
+	var err error
+	if each.entry != nil {
+		log.Println("Resuming journaled job of process", each.resource.ProcessId, "step", each.resource.Step)
+		err = j.resourceService.Resume(*each.entry)
+	} else {
+		err = j.resourceService.Process(each.resource)
+	}
+	if err != nil {
+		log.Println(err.Error())
+	}
+	j.jobLease.Complete(each.resource)
+	j.removeJournalEntry(each.resource)
+}
+
+func (j jobScheduler) removeJournalEntry(resource v1.Resource) {
+	if j.journal == nil {
+		return
+	}
+	if err := j.journal.Remove(resource.ProcessId, resource.Step); err != nil {
+		log.Println("Failed to remove journal entry:", err.Error())
+	}
+}
+
+// journaled returns jobs left unfinished in the journal by a previous run of the agent.
+func (j jobScheduler) journaled() []job {
+	if j.journal == nil {
+		return nil
+	}
+	entries, err := j.journal.List()
+	if err != nil {
+		log.Println("Failed to read job journal:", err.Error())
+		return nil
+	}
+	var jobs []job
+	for i := range entries {
+		jobs = append(jobs, job{resource: entries[i].Resource, entry: &entries[i]})
+	}
+	return jobs
+}
+
+// Start receives jobs by the configured delivery mode while there is free capacity and runs them on a worker pool sized by pull size.
+// Jobs left in the journal by a previous run are resumed first. Leases of jobs are heartbeated while they run.
+// Once ctx is done, receiving stops, queued jobs are released and Start returns after running jobs are drained.
+func (j jobScheduler) Start(ctx context.Context) {
+	heartbeatCtx, stopHeartbeat := context.WithCancel(context.Background())
+	defer stopHeartbeat()
+	go j.jobLease.Heartbeat(heartbeatCtx)
+	go j.scaleDownRetired(ctx)
+	jobs := make(chan job, config.PullSize)
+	var workers sync.WaitGroup
+	for i := int64(0); i < config.PullSize; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for each := range jobs {
+				j.run(ctx, each)
+				atomic.AddInt64(&config.CurrentConcurrentJobs, -1)
+			}
+		}()
+	}
+	for _, each := range j.journaled() {
+		if err := j.jobLease.Acquire(each.resource); err != nil {
+			log.Println("Failed to acknowledge journaled job:", err.Error())
+		}
+		atomic.AddInt64(&config.CurrentConcurrentJobs, 1)
+		jobs <- each
+	}
+	if config.JobDelivery == enums.SSE_DELIVERY {
+		j.stream(ctx, jobs)
+	} else {
+		j.poll(ctx, jobs, nil)
+	}
+	close(jobs)
+	log.Println("Draining", atomic.LoadInt64(&config.CurrentConcurrentJobs), "in-flight jobs ...")
+	workers.Wait()
+}
+
+// scaleDownRetired scales down retired deployments until ctx is done. Retirement is recorded on the deployments, so
+// scale downs due while agent was down are caught up at start.
+func (j jobScheduler) scaleDownRetired(ctx context.Context) {
+	ticker := time.NewTicker(retiredScaleDownInterval)
+	defer ticker.Stop()
+	for {
+		if err := j.resourceService.ScaleDownRetired(); err != nil {
+			log.Println("Failed to scale down retired deployments:", err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (j jobScheduler) capacity() int64 {
+	return config.PullSize - atomic.LoadInt64(&config.CurrentConcurrentJobs)
+}
+
+// enqueue journals the job and hands it over to the workers.
+func (j jobScheduler) enqueue(jobs chan<- job, each v1.Resource) {
+	if j.journal != nil {
+		now := time.Now().UTC()
+		if err := j.journal.Save(v1.JournalEntry{Resource: each, ClaimedAt: now, UpdatedAt: now}); err != nil {
+			log.Println("Failed to journal job:", err.Error())
+		}
+	}
+	atomic.AddInt64(&config.CurrentConcurrentJobs, 1)
+	jobs <- job{resource: each}
+}
+
+// poll pulls jobs until ctx is done or until, if not nil, stop fires. Jobs that can not be acknowledged are released, not run.
+func (j jobScheduler) poll(ctx context.Context, jobs chan<- job, stop <-chan time.Time) {
+	backoff := pullInterval
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stop:
+			return
+		case <-time.After(backoff):
+		}
+		capacity := j.capacity()
+		if capacity < 1 {
+			continue
+		}
+		resources, err := j.resourceService.Pull(capacity)
+		if err != nil {
+			backoff = backoff * 2
+			if backoff > maxPullBackoff {
+				backoff = maxPullBackoff
+			}
+			log.Println("Failed to pull jobs, retrying in", backoff.String()+":", err.Error())
+			continue
+		}
+		backoff = pullInterval
+		for i, each := range resources {
+			if int64(i) >= capacity {
+				if err := j.jobLease.Release(each, "agent is at capacity"); err != nil {
+					log.Println("Failed to release job:", err.Error())
+				}
+				continue
+			}
+			if err := j.jobLease.Acquire(each); err != nil {
+				log.Println("Failed to acknowledge job:", err.Error())
+				if err := j.jobLease.Release(each, "acknowledgement failed"); err != nil {
+					log.Println("Failed to release job:", err.Error())
+				}
+				continue
+			}
+			j.enqueue(jobs, each)
+		}
+	}
+}
+
+// stream receives jobs pushed by api service. Whenever the stream breaks, jobs are polled for a while before reconnecting.
+// Pushed jobs are only acknowledged, and so claimed, if there is free capacity, others are left for api service to redeliver.
+func (j jobScheduler) stream(ctx context.Context, jobs chan<- job) {
+	for {
+		err := j.resourceService.Stream(ctx, func(each v1.Resource) {
+			if j.capacity() < 1 {
+				log.Println("No capacity left, leaving job of process", each.ProcessId, "step", each.Step, "unacknowledged")
+				return
+			}
+			if err := j.jobLease.Acquire(each); err != nil {
+				log.Println("Failed to acknowledge job:", err.Error())
+				if err := j.jobLease.Release(each, "acknowledgement failed"); err != nil {
+					log.Println("Failed to release job:", err.Error())
+				}
+				return
+			}
+			j.enqueue(jobs, each)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Println("Job stream broke, polling for", streamRetryInterval.String()+":", err.Error())
+		} else {
+			log.Println("Job stream closed, polling for", streamRetryInterval.String())
+		}
+		j.poll(ctx, jobs, time.After(streamRetryInterval))
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// NewJobScheduler returns Scheduler type service. Journal is optional.
+func NewJobScheduler(resourceService service.Resource, journal service.Journal, jobLease service.JobLease) service.Scheduler {
+	return &jobScheduler{
+		resourceService: resourceService,
+		journal:         journal,
+		jobLease:        jobLease,
+	}
+}
@@ -0,0 +1,20 @@
+package v1
+
+import (
+	"github.com/klovercloud-ci-cd/agent/enums"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"time"
+)
+
+// Release revision of a rendered chart or kustomization applied by the agent.
+type Release struct {
+	Name        string                      `json:"name"`
+	Namespace   string                      `json:"namespace"`
+	Revision    int                         `json:"revision"`
+	Status      enums.RELEASE_STATUS        `json:"status"`
+	Description string                      `json:"description"`
+	Values      map[string]interface{}      `json:"values"`
+	Manifests   []unstructured.Unstructured `json:"manifests"`
+	ProcessId   string                      `json:"process_id"`
+	UpdatedAt   time.Time                   `json:"updated_at"`
+}
@@ -7,17 +7,138 @@ import (
 
 // Resource agent applicable workload info.
 type Resource struct {
-	Step           string                       `json:"step"`
-	ProcessId      string                       `json:"process_id"`
-	Descriptors    *[]unstructured.Unstructured `json:"descriptors" yaml:"descriptors"`
-	Type           enums.RESOURCE_TYPE          `json:"type"`
-	Name           string                       `json:"name"`
-	Namespace      string                       `json:"namespace"`
-	Replica        int32                        `json:"replica"`
-	Images         []string                     `json:"images"`
-	Pipeline       *Pipeline                    `bson:"pipeline" json:"pipeline"`
-	Claim          int                          `bson:"claim" json:"claim"`
-	RolloutRestart bool                         `bson:"rollout_restart" json:"rollout_restart"`
+	Step            string                       `json:"step"`
+	Action          enums.ACTION                 `bson:"action" json:"action"`
+	ProcessId       string                       `json:"process_id"`
+	Descriptors     *[]unstructured.Unstructured `json:"descriptors" yaml:"descriptors"`
+	Type            enums.RESOURCE_TYPE          `json:"type"`
+	Name            string                       `json:"name"`
+	Namespace       string                       `json:"namespace"`
+	Replica         int32                        `json:"replica"`
+	Images          []string                     `json:"images"`
+	ContainerImages map[string]string            `bson:"container_images" json:"container_images"`
+	Pipeline        *Pipeline                    `bson:"pipeline" json:"pipeline"`
+	Claim           int                          `bson:"claim" json:"claim"`
+	RolloutRestart  bool                         `bson:"rollout_restart" json:"rollout_restart"`
+	ForceConflicts  bool                         `bson:"force_conflicts" json:"force_conflicts"`
+	RollbackPolicy  *RollbackPolicy              `bson:"rollback_policy" json:"rollback_policy"`
+	Trigger         bool                         `bson:"trigger" json:"trigger"`
+	Strategy        *DeployStrategy              `bson:"strategy" json:"strategy"`
+	Verification    *Verification                `bson:"verification" json:"verification"`
+	DryRun          bool                         `bson:"dry_run" json:"dry_run"`
+	Prune           bool                         `bson:"prune" json:"prune"`
+	Teardown        *Teardown                    `bson:"teardown" json:"teardown"`
+	Templating      *Templating                  `bson:"templating" json:"templating"`
+	Render          *Render                      `bson:"render" json:"render"`
+}
+
+// Render chart or kustomization the agent renders into descriptors, tracked as a release.
+type Render struct {
+	Type             enums.RENDER_TYPE      `bson:"type" json:"type"`
+	Release          string                 `bson:"release" json:"release"`
+	Archive          string                 `bson:"archive" json:"archive"`
+	Files            map[string]string      `bson:"files" json:"files"`
+	Path             string                 `bson:"path" json:"path"`
+	Values           map[string]interface{} `bson:"values" json:"values"`
+	ValuesYaml       string                 `bson:"values_yaml" json:"values_yaml"`
+	RollbackRevision int                    `bson:"rollback_revision" json:"rollback_revision"`
+}
+
+// Templating variable substitution over descriptors before they are applied.
+type Templating struct {
+	Engine    enums.TEMPLATE_ENGINE `bson:"engine" json:"engine"`
+	Variables map[string]string     `bson:"variables" json:"variables"`
+	Strict    bool                  `bson:"strict" json:"strict"`
+}
+
+// Teardown options of teardown action. Objects matching selector are deleted along with descriptors. Selector only matches
+// objects in the namespace of the resource, unless ClusterScope is set, then it matches cluster scoped objects and objects
+// of every namespace too.
+type Teardown struct {
+	Selector          map[string]string `bson:"selector" json:"selector"`
+	ClusterScope      bool              `bson:"cluster_scope" json:"cluster_scope"`
+	PropagationPolicy string            `bson:"propagation_policy" json:"propagation_policy"`
+	Timeout           int64             `bson:"timeout" json:"timeout"`
+}
+
+// ResourceDiff change that applying the resource would make to a live object.
+type ResourceDiff struct {
+	ApiVersion string                 `bson:"api_version" json:"api_version"`
+	Kind       string                 `bson:"kind" json:"kind"`
+	Name       string                 `bson:"name" json:"name"`
+	Namespace  string                 `bson:"namespace" json:"namespace"`
+	Operation  enums.DIFF_OPERATION   `bson:"operation" json:"operation"`
+	Patch      map[string]interface{} `bson:"patch" json:"patch"`
+	Replicas   *ReplicaDiff           `bson:"replicas" json:"replicas,omitempty"`
+	Error      string                 `bson:"error" json:"error"`
+}
+
+// ReplicaDiff replica change of a workload. If a HorizontalPodAutoscaler targets the workload, target is bounded by it.
+type ReplicaDiff struct {
+	Current                 int32  `bson:"current" json:"current"`
+	Target                  int32  `bson:"target" json:"target"`
+	HorizontalPodAutoscaler string `bson:"horizontal_pod_autoscaler" json:"horizontal_pod_autoscaler"`
+}
+
+// Verification post deploy checks, failing any of them fails the step.
+type Verification struct {
+	Readiness *ReadinessVerification `bson:"readiness" json:"readiness"`
+	Http      *HttpVerification      `bson:"http" json:"http"`
+	Job       *JobVerification       `bson:"job" json:"job"`
+}
+
+// ReadinessVerification requires pods to stay ready without restarts for the window.
+type ReadinessVerification struct {
+	WindowSeconds int64 `bson:"window_seconds" json:"window_seconds"`
+}
+
+// HttpVerification probes a service port from inside the cluster, expecting a 2xx response.
+type HttpVerification struct {
+	Service         string `bson:"service" json:"service"`
+	Port            string `bson:"port" json:"port"`
+	Path            string `bson:"path" json:"path"`
+	Scheme          string `bson:"scheme" json:"scheme"`
+	Retries         int    `bson:"retries" json:"retries"`
+	IntervalSeconds int64  `bson:"interval_seconds" json:"interval_seconds"`
+}
+
+// JobVerification runs a smoke test job, expecting it to complete.
+type JobVerification struct {
+	Image   string            `bson:"image" json:"image"`
+	Command []string          `bson:"command" json:"command"`
+	Args    []string          `bson:"args" json:"args"`
+	Env     map[string]string `bson:"env" json:"env"`
+	Timeout int64             `bson:"timeout" json:"timeout"`
+}
+
+// DeployStrategy progressive delivery strategy of a deployment.
+type DeployStrategy struct {
+	Type      enums.DEPLOY_STRATEGY `bson:"type" json:"type"`
+	BlueGreen *BlueGreenStrategy    `bson:"blue_green" json:"blue_green"`
+	Canary    *CanaryStrategy       `bson:"canary" json:"canary"`
+}
+
+// BlueGreenStrategy deploys a parallel deployment and switches service selector to it once ready.
+type BlueGreenStrategy struct {
+	Service               string `bson:"service" json:"service"`
+	ScaleDownDelayMinutes int64  `bson:"scale_down_delay_minutes" json:"scale_down_delay_minutes"`
+}
+
+// CanaryStrategy shifts replicas from stable deployment to a canary deployment step by step.
+type CanaryStrategy struct {
+	Steps []CanaryStep `bson:"steps" json:"steps"`
+}
+
+// CanaryStep canary weight in percentage and pause before next step.
+type CanaryStep struct {
+	Weight       int32 `bson:"weight" json:"weight"`
+	PauseSeconds int64 `bson:"pause_seconds" json:"pause_seconds"`
+}
+
+// RollbackPolicy restores previous workload revision if update fails.
+type RollbackPolicy struct {
+	Enabled bool  `bson:"enabled" json:"enabled"`
+	Timeout int64 `bson:"timeout" json:"timeout"`
 }
 
 // Pipeline pipeline stuct
@@ -1,7 +1,15 @@
 package service
 
+import (
+	"context"
+	"io"
+)
+
 // HttpClient HttpClient operations.
 type HttpClient interface {
+	// Post returns an error if the request fails or is answered with a non 2xx status, callers that deliver
+	// best effort may ignore it.
 	Post(url string, header map[string]string, body []byte) error
 	Get(url string, header map[string]string) ([]byte, error)
+	Stream(ctx context.Context, url string, header map[string]string) (io.ReadCloser, error)
 }
@@ -0,0 +1,15 @@
+package service
+
+import (
+	"context"
+	v1 "github.com/klovercloud-ci-cd/agent/core/v1"
+)
+
+// JobLease JobLease operations. As an Observer, it keeps the progress of leased jobs.
+type JobLease interface {
+	Observer
+	Acquire(resource v1.Resource) error
+	Complete(resource v1.Resource)
+	Release(resource v1.Resource, reason string) error
+	Heartbeat(ctx context.Context)
+}
@@ -0,0 +1,11 @@
+package service
+
+import v1 "github.com/klovercloud-ci-cd/agent/core/v1"
+
+// Journal durable record of claimed jobs. As an Observer, it keeps the progress of recorded jobs.
+type Journal interface {
+	Observer
+	Save(entry v1.JournalEntry) error
+	Remove(processId, step string) error
+	List() ([]v1.JournalEntry, error)
+}
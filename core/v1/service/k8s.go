@@ -5,7 +5,6 @@ import (
 	apiV1 "k8s.io/api/apps/v1"
 	coreV1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/client-go/tools/cache"
 )
 
 // K8s operations.
@@ -18,25 +17,27 @@ type K8s interface {
 	UpdatePod(resource v1.Resource) error
 	UpdateStatefulSet(resource v1.Resource) error
 	UpdateDaemonSet(resource v1.Resource) error
-	Apply(data unstructured.Unstructured) error
-	Deploy(data *unstructured.Unstructured) (bool, error)
-	ListenNamespaceEvents() (cache.Store, cache.Controller)
-	ListenServiceEvents() (cache.Store, cache.Controller)
-	ListenPodEvents() (cache.Store, cache.Controller)
-	ListenDeployEvents() (cache.Store, cache.Controller)
-	ListenIngressEvents() (cache.Store, cache.Controller)
-	ListenNetworkPolicyEvents() (cache.Store, cache.Controller)
-	ListenClusterRoleBindingEvents() (cache.Store, cache.Controller)
-	ListenClusterRoleEvents() (cache.Store, cache.Controller)
-	ListenRoleBindingEvents() (cache.Store, cache.Controller)
-	ListenRoleEvents() (cache.Store, cache.Controller)
-	ListenServiceAccountEvents() (cache.Store, cache.Controller)
-	ListenSecretEvents() (cache.Store, cache.Controller)
-	ListenConfigMapEvents() (cache.Store, cache.Controller)
-	ListenPVCEvents() (cache.Store, cache.Controller)
-	ListenPVEvents() (cache.Store, cache.Controller)
-	ListenDaemonSetEvents() (cache.Store, cache.Controller)
-	ListenReplicaSetEvents() (cache.Store, cache.Controller)
-	ListenStateFullSetSetEvents() (cache.Store, cache.Controller)
-	ListenKubeEvents() (cache.Store, cache.Controller)
+	UpdateJob(resource v1.Resource) error
+	UpdateCronJob(resource v1.Resource) error
+	UpdateArgoRollout(resource v1.Resource) error
+	BlueGreenDeployment(resource v1.Resource) error
+	CanaryDeployment(resource v1.Resource) error
+	AbortStrategy(processId, step string) error
+	ScaleDownRetired() error
+	ScaleWorkload(resource v1.Resource) error
+	WatchRollout(resource v1.Resource) error
+	RollbackToPreviousRevision(resource v1.Resource, cause error) error
+	Teardown(resource v1.Resource) error
+	TeardownPreview(resource v1.Resource) ([]v1.ResourceDiff, error)
+	ClusterFacts() (map[string]string, error)
+	GetReleases(namespace, name string) ([]v1.Release, error)
+	SaveRelease(release v1.Release) error
+	Apply(resource v1.Resource, data unstructured.Unstructured) error
+	Deploy(resource v1.Resource, data *unstructured.Unstructured) (bool, error)
+	Diff(resource v1.Resource, data *unstructured.Unstructured) v1.ResourceDiff
+	DiffWorkload(resource v1.Resource) v1.ResourceDiff
+	DiffScale(resource v1.Resource) v1.ResourceDiff
+	Prune(resource v1.Resource, applied []unstructured.Unstructured) error
+	PrunePreview(resource v1.Resource, applied []unstructured.Unstructured) ([]v1.ResourceDiff, error)
+	ListenLighthouseEvents() error
 }
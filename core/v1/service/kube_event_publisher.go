@@ -1,7 +1,21 @@
 package service
 
-import v1 "github.com/klovercloud-ci-cd/agent/core/v1"
+import (
+	"context"
+	v1 "github.com/klovercloud-ci-cd/agent/core/v1"
+)
 
 type KubeEventPublisher interface {
 	Publish(message v1.KubeEventMessage)
+	Flush(ctx context.Context) error
+}
+
+// KubeEventReplay KubeEventReplay operations.
+type KubeEventReplay interface {
+	Replay(epoch int64, from int) v1.KubeEventReplay
+}
+
+// KubeEventOutbox KubeEventOutbox operations.
+type KubeEventOutbox interface {
+	Metrics() v1.KubeEventOutboxMetrics
 }
@@ -0,0 +1,11 @@
+package service
+
+import (
+	v1 "github.com/klovercloud-ci-cd/agent/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Renderer renders helm charts and kustomizations into descriptors.
+type Renderer interface {
+	Render(resource v1.Resource, release v1.Release) ([]unstructured.Unstructured, error)
+}
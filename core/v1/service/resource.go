@@ -1,9 +1,18 @@
 package service
 
-import v1 "github.com/klovercloud-ci-cd/agent/core/v1"
+import (
+	"context"
+	v1 "github.com/klovercloud-ci-cd/agent/core/v1"
+)
 
 // Resource K8s Resource operations.
 type Resource interface {
 	Update(resource v1.Resource) error
-	Pull()
+	Abort(processId, step string) error
+	Diff(resource v1.Resource) ([]v1.ResourceDiff, error)
+	Pull(count int64) ([]v1.Resource, error)
+	Stream(ctx context.Context, handle func(resource v1.Resource)) error
+	Process(resource v1.Resource) error
+	Resume(entry v1.JournalEntry) error
+	ScaleDownRetired() error
 }
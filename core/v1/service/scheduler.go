@@ -0,0 +1,8 @@
+package service
+
+import "context"
+
+// Scheduler runs pulled agent jobs.
+type Scheduler interface {
+	Start(ctx context.Context)
+}
@@ -8,9 +8,35 @@ type KubeEventMessage struct {
 }
 
 type MessageHeader struct {
-	Offset  int               `json:"offset"`
-	Command enums.Command     `json:"command"`
-	Extras  map[string]string `json:"extras"`
+	Offset          int               `json:"offset"`
+	Epoch           int64             `json:"epoch"`
+	ResourceVersion string            `json:"resource_version"`
+	Uid             string            `json:"uid"`
+	Command         enums.Command     `json:"command"`
+	Extras          map[string]string `json:"extras"`
+}
+
+// KubeEventReplay result of resending buffered kube event messages.
+// Gap is set if messages from the requested offset are not buffered anymore.
+type KubeEventReplay struct {
+	Epoch    int64 `json:"epoch"`
+	From     int   `json:"from"`
+	Oldest   int   `json:"oldest"`
+	Latest   int   `json:"latest"`
+	Replayed int   `json:"replayed"`
+	Gap      bool  `json:"gap"`
+}
+
+// KubeEventOutboxMetrics delivery counters of the kube event outbox.
+type KubeEventOutboxMetrics struct {
+	Queued   int64 `json:"queued"`
+	Sent     int64 `json:"sent"`
+	Batches  int64 `json:"batches"`
+	Failures int64 `json:"failures"`
+	Retries  int64 `json:"retries"`
+	Spilled  int64 `json:"spilled"`
+	Dropped  int64 `json:"dropped"`
+	Pending  int64 `json:"pending"`
 }
 
 type Agent struct {
@@ -4,10 +4,16 @@ import (
 	"github.com/klovercloud-ci-cd/agent/config"
 	"github.com/klovercloud-ci-cd/agent/core/v1/logic"
 	"github.com/klovercloud-ci-cd/agent/core/v1/service"
+	"github.com/klovercloud-ci-cd/agent/enums"
 )
 
 // GetV1ResourceService returns Resource service
 func GetV1ResourceService() service.Resource {
+	return getV1ResourceService()
+}
+
+// getV1ResourceService returns Resource service notifying given observers along with the event store ones
+func getV1ResourceService(extraObservers ...service.Observer) service.Resource {
 	var observers []service.Observer
 	eventStoreLogEventService := logic.NewEventStoreLogEventService(logic.NewHttpClientService())
 	eventStoreProcessEventService := logic.NewEventStoreProcessEventService(logic.NewHttpClientService())
@@ -15,14 +21,31 @@ func GetV1ResourceService() service.Resource {
 	observers = append(observers, eventStoreLogEventService)
 	observers = append(observers, eventStoreProcessEventService)
 	observers = append(observers, eventStoreProcessLifeCycleEvent)
+	if journal := GetV1Journal(); journal != nil {
+		observers = append(observers, journal)
+	}
+	observers = append(observers, extraObservers...)
 	k8sClientSet, dynamicClient, discoveryClient := config.GetClientSet()
-	var kubeEventPublisher service.KubeEventPublisher
-	if config.KafkaPublisherEnabled {
-		kubeEventPublisher = logic.NewKubeEventKafkaPublisher()
-	} else {
-		kubeEventPublisher = logic.NewKubeEventHttpPublisher(logic.NewHttpClientService())
+	kubeEventPublisher := logic.NewSequencedKubeEventPublisher(GetV1KubeEventPublisher())
+	return logic.NewResourceService(logic.NewK8sService(k8sClientSet, dynamicClient, discoveryClient, observers, kubeEventPublisher), observers, logic.NewHttpClientService(), logic.NewRendererService())
+}
+
+// GetV1Scheduler returns Scheduler service
+func GetV1Scheduler() service.Scheduler {
+	jobLease := logic.NewJobLeaseService(logic.NewHttpClientService())
+	return logic.NewJobScheduler(getV1ResourceService(jobLease), GetV1Journal(), jobLease)
+}
+
+// GetV1Journal returns Journal service, nil if journal is disabled
+func GetV1Journal() service.Journal {
+	switch config.JournalStore {
+	case enums.FILE_JOURNAL:
+		return logic.NewFileJournal()
+	case enums.CONFIGMAP_JOURNAL:
+		k8sClientSet, _, _ := config.GetClientSet()
+		return logic.NewConfigMapJournal(k8sClientSet)
 	}
-	return logic.NewResourceService(logic.NewK8sService(k8sClientSet, dynamicClient, discoveryClient, observers, kubeEventPublisher), observers, logic.NewHttpClientService())
+	return nil
 }
 
 // GetV1JwtService returns Jwt services
@@ -40,17 +63,30 @@ func GetV1KubeEventService() service.KubeEvent {
 	observers = append(observers, eventStoreProcessEventService)
 	observers = append(observers, eventStoreProcessLifeCycleEvent)
 	k8sClientSet, dynamicClient, discoveryClient := config.GetClientSet()
-	var kubeEventPublisher service.KubeEventPublisher
-	if config.KafkaPublisherEnabled {
-		kubeEventPublisher = logic.NewKubeEventKafkaPublisher()
-	} else {
-		kubeEventPublisher = logic.NewKubeEventHttpPublisher(logic.NewHttpClientService())
-	}
+	kubeEventPublisher := logic.NewSequencedKubeEventPublisher(GetV1KubeEventPublisher())
 	return logic.NewKubeEventService(logic.NewK8sService(k8sClientSet, dynamicClient, discoveryClient, observers, kubeEventPublisher))
 }
 
 
 
+// GetV1KubeEventReplayService returns KubeEventReplay service
+func GetV1KubeEventReplayService() service.KubeEventReplay {
+	return logic.NewKubeEventReplayService(GetV1KubeEventPublisher())
+}
+
+// GetV1KubeEventOutboxService returns KubeEventOutbox service
+func GetV1KubeEventOutboxService() service.KubeEventOutbox {
+	return logic.NewKubeEventOutboxService()
+}
+
+// GetV1KubeEventPublisher returns configured KubeEventPublisher service
+func GetV1KubeEventPublisher() service.KubeEventPublisher {
+	if config.KafkaPublisherEnabled {
+		return logic.NewKubeEventKafkaPublisher()
+	}
+	return logic.NewKubeEventHttpPublisher(logic.NewHttpClientService())
+}
+
 // GetV1HttpClient returns HttpClient service
 func GetV1HttpClient()service.HttpClient{
 	return logic.NewHttpClientService()
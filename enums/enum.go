@@ -45,6 +45,24 @@ const ( // CERTIFICATE k8s certificate as resource
 	STATEFULSET = RESOURCE_TYPE("statefulset")
 	// EVENT k8s statefulset as resource
 	EVENT = RESOURCE_TYPE("event")
+	// JOB k8s job as resource
+	JOB = RESOURCE_TYPE("job")
+	// CRON_JOB k8s cronJob as resource
+	CRON_JOB = RESOURCE_TYPE("cronJob")
+	// ARGO_ROLLOUT argo rollout as resource
+	ARGO_ROLLOUT = RESOURCE_TYPE("rollout")
+)
+
+// ACTION agent job action
+type ACTION string
+
+const (
+	// APPLY applies descriptors and updates the resource, default action
+	APPLY = ACTION("apply")
+	// SCALE only scales the resource to requested replicas
+	SCALE = ACTION("scale")
+	// TEARDOWN deletes descriptors or objects matching teardown selector
+	TEARDOWN = ACTION("teardown")
 )
 
 // PIPELINE_STATUS pipeline status
@@ -63,6 +81,8 @@ const (
 	SUCCESSFUL = PIPELINE_STATUS("SUCCESSFUL")
 	// ERROR step deploy has been ERROR
 	ERROR = PIPELINE_STATUS("ERROR")
+	// DEPLOYMENT_ROLLED_BACK step deploy has been FAILED and ROLLED_BACK
+	DEPLOYMENT_ROLLED_BACK = PIPELINE_STATUS("ROLLED_BACK")
 )
 
 // PROCESS_STATUS pipeline steps status
@@ -77,6 +97,8 @@ const (
 	PAUSED = PROCESS_STATUS("paused")
 	// QUEUED pipeline steps status queued
 	QUEUED=PROCESS_STATUS("queued")
+	// ROLLED_BACK pipeline steps status rolled_back
+	ROLLED_BACK = PROCESS_STATUS("rolled_back")
 )
 
 // ENVIRONMENT run environment
@@ -101,6 +123,102 @@ const (
 	POST_AGENT_JOB = FOOTMARK("post_agent_job")
 	// UPDATE_RESOURCE FOOTMARK name
 	UPDATE_RESOURCE = FOOTMARK("update_resource")
+	// ROLLBACK_RESOURCE FOOTMARK name
+	ROLLBACK_RESOURCE = FOOTMARK("rollback_resource")
+	// PROGRESSIVE_DELIVERY FOOTMARK name
+	PROGRESSIVE_DELIVERY = FOOTMARK("progressive_delivery")
+	// VERIFY_RESOURCE FOOTMARK name
+	VERIFY_RESOURCE = FOOTMARK("verify_resource")
+	// DRY_RUN FOOTMARK name
+	DRY_RUN = FOOTMARK("dry_run")
+	// PRUNE_RESOURCE FOOTMARK name
+	PRUNE_RESOURCE = FOOTMARK("prune_resource")
+	// TEARDOWN_RESOURCE FOOTMARK name
+	TEARDOWN_RESOURCE = FOOTMARK("teardown_resource")
+	// RENDER_RESOURCE FOOTMARK name
+	RENDER_RESOURCE = FOOTMARK("render_resource")
+)
+
+// DEPLOY_STRATEGY deployment strategy
+type DEPLOY_STRATEGY string
+
+const (
+	// ROLLING_UPDATE default rolling update of the deployment
+	ROLLING_UPDATE = DEPLOY_STRATEGY("rollingUpdate")
+	// BLUE_GREEN parallel deployment with service switch
+	BLUE_GREEN = DEPLOY_STRATEGY("blueGreen")
+	// CANARY step-wise canary deployment
+	CANARY = DEPLOY_STRATEGY("canary")
+)
+
+// DIFF_OPERATION change on a live object in dry run
+type DIFF_OPERATION string
+
+const (
+	// OBJECT_CREATED object does not exist and would be created
+	OBJECT_CREATED = DIFF_OPERATION("create")
+	// OBJECT_UPDATED object exists and would be updated
+	OBJECT_UPDATED = DIFF_OPERATION("update")
+	// OBJECT_UNCHANGED object exists and would not change
+	OBJECT_UNCHANGED = DIFF_OPERATION("unchanged")
+	// OBJECT_PRUNED object is not among descriptors anymore and would be pruned
+	OBJECT_PRUNED = DIFF_OPERATION("prune")
+	// OBJECT_DELETED object matches a teardown and would be deleted
+	OBJECT_DELETED = DIFF_OPERATION("delete")
+)
+
+// TEMPLATE_ENGINE descriptor templating engine
+type TEMPLATE_ENGINE string
+
+const (
+	// ENVSUBST ${VAR} style substitution, default engine
+	ENVSUBST = TEMPLATE_ENGINE("envsubst")
+	// GO_TEMPLATE go text/template
+	GO_TEMPLATE = TEMPLATE_ENGINE("go")
+)
+
+// RENDER_TYPE source rendered into descriptors by the agent
+type RENDER_TYPE string
+
+const (
+	// HELM packaged helm chart
+	HELM = RENDER_TYPE("helm")
+	// KUSTOMIZE kustomization directory
+	KUSTOMIZE = RENDER_TYPE("kustomize")
+)
+
+// RELEASE_STATUS status of a release revision
+type RELEASE_STATUS string
+
+const (
+	// RELEASE_DEPLOYED revision is the currently deployed one
+	RELEASE_DEPLOYED = RELEASE_STATUS("deployed")
+	// RELEASE_SUPERSEDED revision has been replaced by a newer one
+	RELEASE_SUPERSEDED = RELEASE_STATUS("superseded")
+	// RELEASE_FAILED revision failed to be applied
+	RELEASE_FAILED = RELEASE_STATUS("failed")
+	// RELEASE_PENDING revision is being applied
+	RELEASE_PENDING = RELEASE_STATUS("pending")
+)
+
+// JOURNAL_STORE store of the local job journal
+type JOURNAL_STORE string
+
+const (
+	// FILE_JOURNAL journal entries are kept as files in a directory
+	FILE_JOURNAL = JOURNAL_STORE("file")
+	// CONFIGMAP_JOURNAL journal entries are kept as ConfigMaps in cluster
+	CONFIGMAP_JOURNAL = JOURNAL_STORE("configmap")
+)
+
+// JOB_DELIVERY how agent receives jobs from api service
+type JOB_DELIVERY string
+
+const (
+	// POLL_DELIVERY agent polls api service for jobs
+	POLL_DELIVERY = JOB_DELIVERY("poll")
+	// SSE_DELIVERY api service pushes jobs over a server-sent events stream
+	SSE_DELIVERY = JOB_DELIVERY("sse")
 )
 
 // Command kafka command
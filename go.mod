@@ -3,6 +3,7 @@ module github.com/klovercloud-ci-cd/agent
 go 1.15
 
 require (
+	github.com/Shopify/sarama v1.29.1
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/gogo/protobuf v1.3.2 // indirect
 	github.com/googleapis/gnostic v0.5.5 // indirect
@@ -13,12 +14,15 @@ require (
 	github.com/opentracing/opentracing-go v1.2.0
 	github.com/swaggo/echo-swagger v1.1.4
 	github.com/swaggo/swag v1.7.4
-	golang.org/x/net v0.0.0-20210520170846-37e1c6afe023 // indirect
+	github.com/xdg/scram v1.0.3
 	golang.org/x/sys v0.0.0-20210616094352-59db8d763f22 // indirect
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
+	helm.sh/helm/v3 v3.5.0
 	k8s.io/api v0.20.1
 	k8s.io/apimachinery v0.20.1
 	k8s.io/client-go v0.20.1
 	k8s.io/klog/v2 v2.9.0 // indirect
+	sigs.k8s.io/kustomize/api v0.8.8
 	sigs.k8s.io/structured-merge-diff/v4 v4.1.2 // indirect
+	sigs.k8s.io/yaml v1.2.0
 )
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/klovercloud-ci-cd/agent/api"
 	"github.com/klovercloud-ci-cd/agent/config"
@@ -11,6 +12,10 @@ import (
 	"github.com/labstack/echo-contrib/jaegertracing"
 	"io"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
@@ -29,20 +34,35 @@ func main() {
 	}
 	go JoinIntegrationManager(dependency.GetV1HttpClient())
 	api.Routes(e)
-	resourceService := dependency.GetV1ResourceService()
-	go ContinuePullingAgentEvent(resourceService)
+	ctx, cancel := context.WithCancel(context.Background())
+	drained := make(chan struct{})
+	go func() {
+		dependency.GetV1Scheduler().Start(ctx)
+		close(drained)
+	}()
 	if config.LighthouseEnabled {
 		kubeEventService := dependency.GetV1KubeEventService()
 		go kubeEventService.GetK8sObjectChangeEvents()
 	}
-	e.Logger.Fatal(e.Start(":" + config.ServerPort))
-}
-
-// ContinuePullingAgentEvent routine that pulls deploy steps in every interval.
-func ContinuePullingAgentEvent(service service.Resource) {
-	service.Pull()
-	time.Sleep(time.Millisecond*500)
-	ContinuePullingAgentEvent(service)
+	go func() {
+		if err := e.Start(":" + config.ServerPort); err != nil && err != http.ErrServerClosed {
+			e.Logger.Fatal(err)
+		}
+	}()
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGTERM, os.Interrupt)
+	<-quit
+	log.Println("Shutting down ...")
+	cancel()
+	<-drained
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer shutdownCancel()
+	if err := dependency.GetV1KubeEventPublisher().Flush(shutdownCtx); err != nil {
+		log.Println("Failed to flush kube events:", err.Error())
+	}
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		log.Println(err.Error())
+	}
 }
 
 // JoinIntegrationManager joins clusters terminal with integration manager